@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+)
+
+// getCatalogChanges is the catalog change feed. A station passes the sync_token from its
+// previous response as "since" (0 for a full download) and applies the returned products,
+// categories and deletions to its offline catalog.
+func (app *Application) getCatalogChanges(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	since, err := strconv.ParseInt(app.readString(qs, "since", "0"), 10, 64)
+	if err != nil {
+		v.AddError("since", "must be an integer value")
+	}
+	limit := app.readInt(qs, "limit", 1000, v)
+
+	v.Check(since >= 0, "since", "must not be negative")
+	v.Check(limit > 0 && limit <= 5000, "limit", "must be between 1 and 5000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, err := app.Models.Catalog.Changes(since, limit)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, changes)
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -21,6 +23,10 @@ func (app *Application) createCategory(w http.ResponseWriter, r *http.Request) {
 
 	err = app.Models.Category.Create(&newCategory)
 	if err != nil {
+		if errors.Is(err, model.ErrUnknownCategory) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -102,3 +108,86 @@ func (app *Application) deleteCategory(w http.ResponseWriter, r *http.Request) {
 
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+// getCategoryTree returns all categories as a tree, the top-level ones with their
+// descendants nested under `children`.
+func (app *Application) getCategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := app.Models.Category.Tree()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"categories": tree})
+}
+
+// moveCategory moves a category and its subtree under the category given as `parent_id`,
+// or to the top level when that is null.
+func (app *Application) moveCategory(w http.ResponseWriter, r *http.Request) {
+	categoryId, err := strconv.Atoi(mux.Vars(r)["categoryId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Category ID")
+		return
+	}
+
+	var input struct {
+		ParentId *int `json:"parent_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	category, err := app.Models.Category.Move(categoryId, input.ParentId)
+	if err != nil {
+		app.categoryError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, category)
+}
+
+// mergeCategory folds a category into the one given as `target_id` and returns the target.
+func (app *Application) mergeCategory(w http.ResponseWriter, r *http.Request) {
+	categoryId, err := strconv.Atoi(mux.Vars(r)["categoryId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Category ID")
+		return
+	}
+
+	var input struct {
+		TargetId int `json:"target_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.TargetId > 0, "target_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	category, err := app.Models.Category.Merge(categoryId, input.TargetId)
+	if err != nil {
+		app.categoryError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, category)
+}
+
+// categoryError responds to the errors moving and merging categories have in common.
+func (app *Application) categoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Category Not Found")
+	case errors.Is(err, model.ErrUnknownCategory), errors.Is(err, model.ErrCategoryCycle):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createCommissionRule(w http.ResponseWriter, r *http.Request) {
+	rule := model.CommissionRule{Active: true}
+
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateCommissionRule(v, &rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Commission.CreateRule(&rule)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, rule)
+}
+
+func (app *Application) getAllCommissionRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.Models.Commission.GetAllRules()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"commission_rules": rules})
+}
+
+func (app *Application) getCommissionRule(w http.ResponseWriter, r *http.Request) {
+	ruleId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Commission Rule ID")
+		return
+	}
+
+	rule, err := app.Models.Commission.GetRule(ruleId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, rule)
+}
+
+func (app *Application) updateCommissionRule(w http.ResponseWriter, r *http.Request) {
+	ruleId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Commission Rule ID")
+		return
+	}
+
+	var rule model.CommissionRule
+	err = json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateCommissionRule(v, &rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Commission.UpdateRule(ruleId, &rule)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, rule)
+}
+
+func (app *Application) deleteCommissionRule(w http.ResponseWriter, r *http.Request) {
+	ruleId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Commission Rule ID")
+		return
+	}
+
+	err = app.Models.Commission.DeleteRule(ruleId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getCommissionStatement returns an employee's commissions for a period. The period
+// defaults to the current month and both dates are inclusive. Employees see their own
+// statement; anyone else's requires commissions:read.
+func (app *Application) getCommissionStatement(w http.ResponseWriter, r *http.Request) {
+	employeeId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Employee ID")
+		return
+	}
+	if !app.allowSelfOrPermission(w, r, employeeId, "commissions:read") {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := app.readDate(qs, "from", monthStart, v)
+	to := app.readDate(qs, "to", monthStart.AddDate(0, 1, -1), v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	statement, err := app.Models.Commission.Statement(employeeId, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"statement": statement})
+}
@@ -22,3 +22,57 @@ func (app *Application) contextGetUser(r *http.Request) *model.Employee {
 	}
 	return user
 }
+
+// contextGetUserID returns the ID of the authenticated employee, or nil for anonymous
+// requests.
+func (app *Application) contextGetUserID(r *http.Request) *int {
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		return &user.Id
+	}
+	return nil
+}
+
+const stationContextKey = contextKey("station")
+
+func (app *Application) contextSetStation(r *http.Request, station *model.Station) *http.Request {
+	ctx := context.WithValue(r.Context(), stationContextKey, station)
+	return r.WithContext(ctx)
+}
+
+// contextGetStation returns the station the request was made from, or nil when the caller
+// did not present a station credential.
+func (app *Application) contextGetStation(r *http.Request) *model.Station {
+	station, _ := r.Context().Value(stationContextKey).(*model.Station)
+	return station
+}
+
+const displayContextKey = contextKey("display")
+
+func (app *Application) contextSetDisplay(r *http.Request, station *model.Station) *http.Request {
+	ctx := context.WithValue(r.Context(), displayContextKey, station)
+	return r.WithContext(ctx)
+}
+
+// contextGetDisplay returns the station whose customer-facing display made the request,
+// or nil when the caller did not present a display credential.
+func (app *Application) contextGetDisplay(r *http.Request) *model.Station {
+	station, _ := r.Context().Value(displayContextKey).(*model.Station)
+	return station
+}
+
+// contextGetStationID is a convenience wrapper returning the station's ID or nil.
+func (app *Application) contextGetStationID(r *http.Request) *int {
+	if station := app.contextGetStation(r); station != nil {
+		return &station.Id
+	}
+	return nil
+}
+
+// contextGetStoreID returns the store of the station the request was made from, or 0 (the
+// default store) when the caller did not present a station credential.
+func (app *Application) contextGetStoreID(r *http.Request) int {
+	if station := app.contextGetStation(r); station != nil {
+		return station.StoreId
+	}
+	return 0
+}
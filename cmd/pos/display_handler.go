@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"strings"
+	"time"
+)
+
+// displayRefresh is how often an open display stream re-evaluates its state even without
+// order activity, so that it drops back to idle on time.
+const displayRefresh = 5 * time.Second
+
+// displaySnapshot reloads the station too, so changes to its idle content show up on a
+// display that is already connected.
+func (app *Application) displaySnapshot(stationId int) (*model.DisplaySnapshot, error) {
+	station, err := app.Models.Station.Get(stationId)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := app.Models.Order.GetLatestForStation(station.Id)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	snapshot := model.NewDisplaySnapshot(order, station.DisplayContent, time.Now())
+	return &snapshot, nil
+}
+
+// getDisplay returns what the customer-facing display of the caller's station should
+// show right now.
+func (app *Application) getDisplay(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := app.displaySnapshot(app.contextGetDisplay(r).Id)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, snapshot)
+}
+
+// streamDisplay pushes the display state as Server-Sent Events. A "display" event is
+// sent straight away and then whenever the state changes as the cashier works.
+func (app *Application) streamDisplay(w http.ResponseWriter, r *http.Request) {
+	station := app.contextGetDisplay(r)
+
+	stream, ok := app.startSSE(w)
+	if !ok {
+		return
+	}
+
+	sub, _, _ := app.events.Subscribe(0)
+	defer sub.Cancel()
+
+	var last []byte
+	push := func() error {
+		snapshot, err := app.displaySnapshot(station.Id)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, last) {
+			return nil
+		}
+		last = data
+		return stream.write("event: display\ndata: %s\n\n", data)
+	}
+
+	if err := push(); err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	refresh := time.NewTicker(displayRefresh)
+	defer refresh.Stop()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown:
+			return
+		case <-keepAlive.C:
+			err = stream.keepAlive()
+		case <-refresh.C:
+			err = push()
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if strings.HasPrefix(e.Type, "order.") {
+				err = push()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
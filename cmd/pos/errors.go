@@ -15,6 +15,31 @@ func (app *Application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.respondWithError(w, http.StatusUnauthorized, message)
 }
 
+func (app *Application) invalidStationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or unregistered station token"
+	app.respondWithError(w, http.StatusUnauthorized, message)
+}
+
+func (app *Application) stationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can only be accessed from a registered station"
+	app.respondWithError(w, http.StatusUnauthorized, message)
+}
+
+func (app *Application) invalidDisplayTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or revoked display token"
+	app.respondWithError(w, http.StatusUnauthorized, message)
+}
+
+func (app *Application) displayRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can only be accessed with a display token"
+	app.respondWithError(w, http.StatusUnauthorized, message)
+}
+
+func (app *Application) displayNotPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a display token only grants access to the display feed"
+	app.respondWithError(w, http.StatusForbidden, message)
+}
+
 func (app *Application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.respondWithError(w, http.StatusUnauthorized, message)
@@ -29,3 +54,7 @@ func (app *Application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.respondWithError(w, http.StatusForbidden, message)
 }
+
+func (app *Application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.respondWithJSON(w, http.StatusUnprocessableEntity, envelope{"error": errors})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"pos-rs/pkg/pos/events"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+)
+
+const eventKeepAlive = 15 * time.Second
+
+// publishEvent publishes a domain event tagged with the store and station the request
+// was made from.
+func (app *Application) publishEvent(r *http.Request, eventType string, data interface{}) {
+	e := events.Event{Type: eventType, Data: data}
+	if station := app.contextGetStation(r); station != nil {
+		e.StoreId = &station.StoreId
+		e.StationId = &station.Id
+	}
+	app.events.Publish(e)
+}
+
+// eventFilter decides which events a stream subscriber gets to see.
+type eventFilter struct {
+	permissions model.Permissions
+	types       []string
+	storeId     *int
+	stationId   *int
+}
+
+func (f eventFilter) match(e events.Event) bool {
+	if !f.permissions.Include(events.Permissions[e.Type]) {
+		return false
+	}
+	if len(f.types) > 0 && !validator.In(e.Type, f.types...) {
+		return false
+	}
+	if f.storeId != nil && (e.StoreId == nil || *e.StoreId != *f.storeId) {
+		return false
+	}
+	if f.stationId != nil && (e.StationId == nil || *e.StationId != *f.stationId) {
+		return false
+	}
+	return true
+}
+
+// eventStream is one transport (SSE or WebSocket) events are pushed over.
+type eventStream interface {
+	send(e events.Event) error
+	keepAlive() error
+}
+
+// readEventFilter builds the subscriber's filter from its permissions and the "types",
+// "store" and "station" query parameters, and reads the ID of the last event the client
+// saw from the Last-Event-ID header or the "last_event_id" query parameter. A caller on a
+// registered station only ever sees that station's events.
+func (app *Application) readEventFilter(w http.ResponseWriter, r *http.Request) (eventFilter, uint64, bool) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	permissions, err := app.Models.Permissions.GetAllForUser(app.contextGetUser(r).Id)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return eventFilter{}, 0, false
+	}
+
+	filter := eventFilter{
+		permissions: permissions,
+		types:       app.readCSV(qs, "types", nil),
+	}
+	for _, t := range filter.types {
+		_, ok := events.Permissions[t]
+		v.Check(ok, "types", fmt.Sprintf("unknown event type %q", t))
+	}
+
+	if qs.Get("store") != "" {
+		storeId := app.readInt(qs, "store", 0, v)
+		filter.storeId = &storeId
+	}
+	if station := app.contextGetStation(r); station != nil {
+		filter.stationId = &station.Id
+	} else if qs.Get("station") != "" {
+		stationId := app.readInt(qs, "station", 0, v)
+		filter.stationId = &stationId
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = qs.Get("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+		v.Check(err == nil, "last_event_id", "must be a valid event ID")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return eventFilter{}, 0, false
+	}
+
+	return filter, lastID, true
+}
+
+// pumpEvents replays missed events and then forwards live ones until the client goes
+// away, the subscriber falls behind, or the server shuts down. If the requested events
+// are no longer buffered the client first gets a "reset" event telling it to reload.
+func (app *Application) pumpEvents(stream eventStream, filter eventFilter, lastID uint64, done <-chan struct{}) {
+	sub, backlog, complete := app.events.Subscribe(lastID)
+	defer sub.Cancel()
+
+	if !complete {
+		if err := stream.send(events.Event{ID: sub.StartID, Type: "reset", Time: time.Now()}); err != nil {
+			return
+		}
+	}
+	for _, e := range backlog {
+		if !filter.match(e) {
+			continue
+		}
+		if err := stream.send(e); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-app.shutdown:
+			return
+		case <-ticker.C:
+			if err := stream.keepAlive(); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !filter.match(e) {
+				continue
+			}
+			if err := stream.send(e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type sseStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// startSSE sends the headers of a Server-Sent Events response. When it fails an error
+// response has already been written.
+func (app *Application) startSSE(w http.ResponseWriter) (sseStream, bool) {
+	rc := http.NewResponseController(w)
+	// The stream is long lived, so lift the server's write timeout for this response.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return sseStream{}, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return sseStream{}, false
+	}
+
+	return sseStream{w: w, rc: rc}, true
+}
+
+func (s sseStream) write(format string, args ...interface{}) error {
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s sseStream) send(e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.write("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+func (s sseStream) keepAlive() error {
+	return s.write(": keep-alive\n\n")
+}
+
+// streamEvents pushes domain events to the client as Server-Sent Events.
+func (app *Application) streamEvents(w http.ResponseWriter, r *http.Request) {
+	filter, lastID, ok := app.readEventFilter(w, r)
+	if !ok {
+		return
+	}
+
+	stream, ok := app.startSSE(w)
+	if !ok {
+		return
+	}
+
+	app.pumpEvents(stream, filter, lastID, r.Context().Done())
+}
+
+type wsStream struct {
+	conn *wsConn
+}
+
+func (s wsStream) send(e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.conn.writeMessage(websocket.TextMessage, data)
+}
+
+func (s wsStream) keepAlive() error {
+	return s.conn.writeMessage(websocket.PingMessage, nil)
+}
+
+// streamEventsWebSocket pushes domain events to the client as WebSocket text messages,
+// one JSON encoded event per message.
+func (app *Application) streamEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, lastID, ok := app.readEventFilter(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := app.upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.readLoop(3 * eventKeepAlive)
+	}()
+
+	app.pumpEvents(wsStream{conn: conn}, filter, lastID, done)
+	conn.closeMessage()
+}
@@ -4,6 +4,7 @@ import (
 	"net/url" // New import
 	"strconv"
 	"strings"
+	"time"
 
 	"pos-rs/pkg/pos/validator" // New import
 )
@@ -59,3 +60,39 @@ func (app *Application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// The readDate() helper reads a YYYY-MM-DD date from the query string. If no matching key
+// could be found it returns the provided default value. If the value isn't a valid date,
+// then we record an error message in the provided Validator instance.
+func (app *Application) readDate(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "must be a date in YYYY-MM-DD format")
+		return defaultValue
+	}
+
+	return t
+}
+
+// The readTime() helper reads an RFC 3339 timestamp from the query string. If no matching
+// key could be found it returns the provided default value. If the value isn't a valid
+// timestamp, then we record an error message in the provided Validator instance.
+func (app *Application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be a timestamp in RFC 3339 format")
+		return defaultValue
+	}
+
+	return t
+}
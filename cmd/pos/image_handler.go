@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"pos-rs/pkg/pos/imaging"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// uploadProductImage adds an image to a product from the "image" field of a multipart
+// form. JPEG, PNG and GIF images are accepted up to the configured size; the original is
+// stored as uploaded, along with a JPEG thumbnail in each of the fixed sizes.
+func (app *Application) uploadProductImage(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	maxSize := app.Config.Media.MaxImageSize
+	tooLarge := fmt.Sprintf("must not be more than %d bytes", maxSize)
+
+	// Leave room for the rest of the form around the image.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.failedValidationResponse(w, r, map[string]string{"image": tooLarge})
+			return
+		}
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Multipart Form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		v.AddError("image", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Multipart Form")
+		return
+	}
+
+	v.Check(len(data) > 0, "image", "must not be empty")
+	v.Check(int64(len(data)) <= maxSize, "image", tooLarge)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image, err := app.storeProductImage(r.Context(), productId, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrUnsupportedType):
+			v.AddError("image", "must be a JPEG, PNG or GIF image")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, imaging.ErrTooManyPixels):
+			v.AddError("image", fmt.Sprintf("must not have more than %d pixels", imaging.MaxPixels))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, image)
+}
+
+// storeProductImage puts an uploaded image and its thumbnails into the media storage,
+// under a prefix of their own, and records them against the product. Whatever was stored
+// is removed again when this fails.
+func (app *Application) storeProductImage(ctx context.Context, productId int, data []byte) (*model.ProductImage, error) {
+	contentType, err := imaging.DetectContentType(data)
+	if err != nil {
+		return nil, err
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("products/%d/%s", productId, hex.EncodeToString(suffix))
+
+	image := &model.ProductImage{
+		ProductId:   productId,
+		ContentType: contentType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Size:        int64(len(data)),
+		Thumbnails:  make(map[string]string, len(imaging.Thumbnails)),
+	}
+
+	put := func(key string, content []byte, contentType string) error {
+		if err := app.media.Put(ctx, key, bytes.NewReader(content), contentType); err != nil {
+			return err
+		}
+		image.Keys = append(image.Keys, key)
+		return nil
+	}
+
+	err = func() error {
+		key := prefix + "/original" + imaging.ContentTypes[contentType]
+		if err := put(key, data, contentType); err != nil {
+			return err
+		}
+		image.URL = app.media.URL(key)
+
+		flat := imaging.Flatten(img)
+		for _, thumbnail := range imaging.Thumbnails {
+			var buf bytes.Buffer
+			if err := imaging.EncodeJPEG(&buf, imaging.Scale(flat, thumbnail.Size)); err != nil {
+				return err
+			}
+			key := prefix + "/" + thumbnail.Name + ".jpg"
+			if err := put(key, buf.Bytes(), "image/jpeg"); err != nil {
+				return err
+			}
+			image.Thumbnails[thumbnail.Name] = app.media.URL(key)
+		}
+
+		return app.Models.Product.AddImage(image)
+	}()
+	if err != nil {
+		app.removeMedia(image.Keys)
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// deleteProductImage removes an image of a product, and its files.
+func (app *Application) deleteProductImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	productId, err := strconv.Atoi(vars["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+	imageId, err := strconv.Atoi(vars["imageId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Image ID")
+		return
+	}
+
+	image, err := app.Models.Product.DeleteImage(productId, imageId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Image Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.removeMedia(image.Keys)
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// removeMedia deletes files from the media storage. Failures are only logged: the files
+// are no longer referred to and at worst take up space.
+func (app *Application) removeMedia(keys []string) {
+	for _, key := range keys {
+		if err := app.media.Delete(context.Background(), key); err != nil {
+			app.logger.PrintError(err, map[string]string{"key": key})
+		}
+	}
+}
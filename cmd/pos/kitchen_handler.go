@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/events"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fireOrder sends the order's kitchen items to the prep stations and pushes the new
+// tickets to the kitchen screens.
+func (app *Application) fireOrder(r *http.Request, order *model.Order) ([]model.KitchenTicket, error) {
+	tickets, err := app.Models.Kitchen.Fire(order)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tickets {
+		app.publishEvent(r, events.KitchenTicketCreated, tickets[i])
+	}
+	return tickets, nil
+}
+
+// fireOrderIfPaid fires a fully paid order. A failure is logged rather than returned so
+// that it never blocks a sale that has already been saved.
+func (app *Application) fireOrderIfPaid(r *http.Request, order *model.Order) {
+	if order.TotalPrice <= 0 || order.TotalPaid < order.TotalPrice {
+		return
+	}
+	if _, err := app.fireOrder(r, order); err != nil {
+		app.logger.PrintError(err, map[string]string{"order_id": strconv.Itoa(order.Id)})
+	}
+}
+
+func (app *Application) fireOrderHandler(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	order, err := app.Models.Order.Get(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		return
+	}
+
+	tickets, err := app.fireOrder(r, order)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"tickets": tickets})
+}
+
+func (app *Application) getOrderKitchenTickets(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	tickets, err := app.Models.Kitchen.GetTicketsForOrder(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"tickets": tickets})
+}
+
+func (app *Application) createPrepStation(w http.ResponseWriter, r *http.Request) {
+	var station model.PrepStation
+
+	err := json.NewDecoder(r.Body).Decode(&station)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePrepStation(v, &station); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Kitchen.CreatePrepStation(&station)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, station)
+}
+
+func (app *Application) getAllPrepStations(w http.ResponseWriter, r *http.Request) {
+	stations, err := app.Models.Kitchen.GetAllPrepStations()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"prep_stations": stations})
+}
+
+func (app *Application) getPrepStation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Prep Station ID")
+		return
+	}
+
+	station, err := app.Models.Kitchen.GetPrepStation(id)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Prep Station Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, station)
+}
+
+func (app *Application) updatePrepStation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Prep Station ID")
+		return
+	}
+
+	var station model.PrepStation
+	err = json.NewDecoder(r.Body).Decode(&station)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePrepStation(v, &station); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Kitchen.UpdatePrepStation(id, &station)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Prep Station Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, station)
+}
+
+func (app *Application) deletePrepStation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Prep Station ID")
+		return
+	}
+
+	err = app.Models.Kitchen.DeletePrepStation(id)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getPrepStationTickets lists the tickets a kitchen screen shows. By default these are
+// the tickets that have not been served yet; pass "status" to choose others.
+func (app *Application) getPrepStationTickets(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Prep Station ID")
+		return
+	}
+
+	v := validator.New()
+	statuses := app.readCSV(r.URL.Query(), "status", []string{model.TicketNew, model.TicketInProgress, model.TicketReady})
+	for _, s := range statuses {
+		v.Check(validator.In(s, model.TicketNew, model.TicketInProgress, model.TicketReady, model.TicketServed),
+			"status", "must be new, in_progress, ready or served")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tickets, err := app.Models.Kitchen.GetTickets(id, statuses)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"tickets": tickets})
+}
+
+func (app *Application) bumpKitchenTicket(w http.ResponseWriter, r *http.Request) {
+	app.moveKitchenTicket(w, r, app.Models.Kitchen.Bump)
+}
+
+func (app *Application) recallKitchenTicket(w http.ResponseWriter, r *http.Request) {
+	app.moveKitchenTicket(w, r, app.Models.Kitchen.Recall)
+}
+
+func (app *Application) moveKitchenTicket(w http.ResponseWriter, r *http.Request, move func(int) (*model.KitchenTicket, error)) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Ticket ID")
+		return
+	}
+
+	ticket, err := move(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Ticket Not Found")
+		case errors.Is(err, model.ErrTicketAlreadyServed), errors.Is(err, model.ErrTicketNotBumped):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrEditConflict):
+			app.respondWithError(w, http.StatusConflict, "the ticket was changed by someone else, please reload it")
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.publishEvent(r, events.KitchenTicketUpdated, ticket)
+
+	app.respondWithJSON(w, http.StatusOK, ticket)
+}
+
+// getPrepTimes reports average and longest prep times per prep station for a period
+// (both dates inclusive, defaulting to today).
+func (app *Application) getPrepTimes(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	today := time.Now().Truncate(24 * time.Hour)
+	from := app.readDate(qs, "from", today, v)
+	to := app.readDate(qs, "to", today, v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.Models.Kitchen.PrepTimes(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"prep_stations": stats})
+}
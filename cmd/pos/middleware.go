@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 
 	"golang.org/x/time/rate"
 	"net/http"
 	"pos-rs/pkg/pos/model"
+	"strconv"
 	"strings"
 )
 
@@ -40,9 +44,51 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Hello")
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-Station-Token")
+		w.Header().Add("Vary", "X-Display-Token")
+
+		// A customer-facing display authenticates with its own credential, which is only
+		// good for the display feed. Browsers cannot set headers on EventSource
+		// connections, so the token is also accepted as a query parameter there.
+		displayToken := r.Header.Get("X-Display-Token")
+		if displayToken == "" && strings.HasPrefix(r.URL.Path, "/api/v1/display") {
+			displayToken = r.URL.Query().Get("display_token")
+		}
+		if displayToken != "" {
+			if !strings.HasPrefix(r.URL.Path, "/api/v1/display") {
+				app.displayNotPermittedResponse(w, r)
+				return
+			}
+			station, err := app.Models.Station.GetForDisplayCredential(displayToken)
+			if err != nil {
+				app.invalidDisplayTokenResponse(w, r)
+				return
+			}
+			r = app.contextSetDisplay(r, station)
+			r = app.contextSetUser(r, model.AnonymousEmployee)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if stationToken := r.Header.Get("X-Station-Token"); stationToken != "" {
+			station, err := app.Models.Station.GetForCredential(stationToken)
+			if err != nil {
+				app.invalidStationTokenResponse(w, r)
+				return
+			}
+			r = app.contextSetStation(r, station)
+		}
 
 		authorizationHeader := r.Header.Get("Authorization")
 
+		// Browsers cannot set headers on EventSource and WebSocket connections, so the
+		// event streams also accept the token as a query parameter.
+		if authorizationHeader == "" && strings.HasPrefix(r.URL.Path, "/api/v1/events") {
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				authorizationHeader = "Bearer " + token
+			}
+		}
+
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, model.AnonymousEmployee)
 			next.ServeHTTP(w, r)
@@ -117,3 +163,156 @@ func (app *Application) requirePermission(code string, next http.HandlerFunc) ht
 
 	return app.requireActivatedUser(fn)
 }
+
+// allowSelfOrPermission reports whether the caller may see the records of an employee:
+// their own, or anyone's with the permission code. It responds itself when not.
+func (app *Application) allowSelfOrPermission(w http.ResponseWriter, r *http.Request, employeeId int, code string) bool {
+	if app.contextGetUser(r).Id == employeeId {
+		return true
+	}
+
+	permitted, err := app.userHasPermission(app.contextGetUser(r), code)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// userHasPermission reports whether the user holds the permission code. The anonymous
+// user holds none.
+func (app *Application) userHasPermission(user *model.Employee, code string) (bool, error) {
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	permissions, err := app.Models.Permissions.GetAllForUser(user.Id)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(code), nil
+}
+
+// responseRecorder captures the status code and body written by a handler while still
+// passing them through to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent honours the Idempotency-Key request header. The first request with a key is
+// processed normally and its response stored; retries with the same key and body get the
+// stored response replayed, and reusing a key with a different body is rejected. Keys
+// belong to the employee and station that sent them, so callers cannot collide.
+func (app *Application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.respondWithError(w, http.StatusBadRequest, "Idempotency-Key must not be more than 255 bytes long")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		h.Write(body)
+		fingerprint := h.Sum(nil)
+
+		scope := strconv.Itoa(app.contextGetUser(r).Id)
+		if stationId := app.contextGetStationID(r); stationId != nil {
+			scope += "/" + strconv.Itoa(*stationId)
+		}
+
+		record, claimed, err := app.Models.Idempotency.Begin(scope, key, fingerprint, app.Config.Idempotency.TTL)
+		if err != nil {
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !claimed {
+			switch {
+			case !bytes.Equal(record.Fingerprint, fingerprint):
+				app.respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key has already been used for a different request")
+			case record.StatusCode == nil:
+				app.respondWithError(w, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+			default:
+				if record.ContentType != "" {
+					w.Header().Set("Content-Type", record.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*record.StatusCode)
+				w.Write(record.ResponseBody)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			// Server errors are not stored so that the client can retry with the same key.
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+				if err := app.Models.Idempotency.Release(scope, key); err != nil {
+					app.logger.PrintError(err, map[string]string{"idempotency_key": key})
+				}
+				return
+			}
+			err := app.Models.Idempotency.Complete(scope, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"idempotency_key": key})
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	}
+}
+
+// requireDisplay only lets through requests made with a customer-facing display credential.
+func (app *Application) requireDisplay(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetDisplay(r) == nil {
+			app.displayRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireStation only lets through requests made with a registered station's credential.
+func (app *Application) requireStation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetStation(r) == nil {
+			app.stationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
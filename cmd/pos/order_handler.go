@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"pos-rs/pkg/pos/events"
 	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -19,12 +23,79 @@ func (app *Application) createOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Order.Create(&newOrder)
+	v := validator.New()
+	if v.Check(len(newOrder.CustomerGroup) <= 32, "customer_group", "must not be more than 32 bytes long"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A customer group can unlock lower prices, so only employees trusted with it set one.
+	if newOrder.CustomerGroup != "" {
+		permitted, err := app.userHasPermission(app.contextGetUser(r), "pricing:apply")
+		if err != nil {
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !permitted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	newOrder.StationId = app.contextGetStationID(r)
+	if station := app.contextGetStation(r); station != nil {
+		newOrder.StoreId = station.StoreId
+	}
+
+	// Lines without a price are priced by the price list that applies now.
+	newOrder.PriceListId, err = app.Models.PriceList.PriceOrder(&newOrder, newOrder.Products, time.Now())
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) || errors.Is(err, model.ErrUnknownUnit) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if newOrder.TotalPrice == 0 {
+		newOrder.TotalPrice = calculateTotalPrice(newOrder.Products)
+	}
+	if newOrder.StationId != nil && newOrder.ReceiptID == "" {
+		newOrder.ReceiptID, err = app.Models.Station.NextReceiptNumber(*newOrder.StationId)
+		if err != nil {
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	shift, err := app.Models.Shift.GetOpen(newOrder.StationId)
+	switch {
+	case err == nil:
+		newOrder.ShiftId = &shift.Id
+	case !errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	movements, err := app.Models.Order.Create(&newOrder)
 	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) || errors.Is(err, model.ErrUnknownStore) ||
+			errors.Is(err, model.ErrExpiredLot) || isSerialError(err) ||
+			errors.Is(err, model.ErrUnknownUnit) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	app.publishEvent(r, events.OrderCreated, newOrder)
+	if newOrder.TotalPrice > 0 && newOrder.TotalPaid >= newOrder.TotalPrice {
+		app.publishEvent(r, events.OrderPaid, newOrder)
+	}
+	app.fireOrderIfPaid(r, &newOrder)
+	app.publishStockMovements(r, movements...)
+
 	app.respondWithJSON(w, http.StatusCreated, newOrder)
 }
 
@@ -79,14 +150,32 @@ func (app *Application) addProductToOrder(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// A line without a price is priced by the price list that applies to the order now.
+	lines := []model.OrderProduct{product}
+	_, err = app.Models.PriceList.PriceOrder(existingOrder, lines, time.Now())
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) || errors.Is(err, model.ErrUnknownUnit) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	product = lines[0]
+
 	existingOrder.Products = append(existingOrder.Products, product)
 	existingOrder.TotalPrice += float64(product.Price) * float64(product.Qty)
 
-	err = app.Models.Order.Update(orderId, existingOrder)
+	// The order's own copy of the line, so that the sale sets its cost.
+	added := existingOrder.Products[len(existingOrder.Products)-1:]
+	movements, err := app.Models.Order.Update(orderId, existingOrder, model.LineChange{Added: added}, app.contextGetUserID(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		app.orderUpdateErrorResponse(w, err)
 		return
 	}
+	app.publishStockMovements(r, movements...)
+
+	app.publishEvent(r, events.OrderUpdated, existingOrder)
 
 	app.respondWithJSON(w, http.StatusOK, existingOrder)
 }
@@ -127,21 +216,46 @@ func (app *Application) removeProductFromOrder(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	var removedLines []model.OrderProduct
+	for _, p := range existingOrder.Products {
+		if p.Id == productID {
+			removedLines = append(removedLines, p)
+		}
+	}
 	updatedProducts := removeProduct(existingOrder.Products, productID)
 	updatedTotalPrice := calculateTotalPrice(updatedProducts)
 
 	existingOrder.Products = updatedProducts
 	existingOrder.TotalPrice = updatedTotalPrice
 
-	err = app.Models.Order.Update(orderID, existingOrder)
+	movements, err := app.Models.Order.Update(orderID, existingOrder, model.LineChange{Removed: removedLines}, app.contextGetUserID(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		app.orderUpdateErrorResponse(w, err)
 		return
 	}
+	app.publishStockMovements(r, movements...)
+
+	app.publishEvent(r, events.OrderUpdated, existingOrder)
 
 	app.respondWithJSON(w, http.StatusOK, existingOrder)
 }
 
+// orderUpdateErrorResponse responds to an error from adding a line to or removing one from
+// an order.
+func (app *Application) orderUpdateErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrUnknownProduct), errors.Is(err, model.ErrExpiredLot), isSerialError(err),
+		errors.Is(err, model.ErrUnknownUnit):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, model.ErrEditConflict):
+		app.respondWithError(w, http.StatusConflict, "the order was changed by someone else, please try again")
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
 func calculateTotalPrice(products []model.OrderProduct) float64 {
 	totalPrice := 0.0
 	for _, p := range products {
@@ -161,11 +275,218 @@ func (app *Application) deleteOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Order.Delete(orderId)
+	movements, err := app.Models.Order.Delete(orderId, app.contextGetUserID(r))
 	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+			return
+		}
+		if errors.Is(err, model.ErrOrderRefunded) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
 	}
+	app.publishStockMovements(r, movements...)
+
+	app.publishEvent(r, events.OrderVoided, envelope{"order_id": orderId})
 
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+func (app *Application) createRefund(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	var input struct {
+		Products []struct {
+			ProductId string   `json:"product_id"`
+			Unit      string   `json:"unit"`
+			Qty       int      `json:"qty"`
+			Serials   []string `json:"serials"`
+		} `json:"products"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	existingOrder, err := app.Models.Order.Get(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		return
+	}
+
+	refunded, err := app.Models.Refund.RefundedQty(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sold := make(map[model.RefundKey]model.OrderProduct)
+	soldCogs := make(map[model.RefundKey]int)
+	soldSerials := make(map[string]map[string]bool)
+	for _, p := range existingOrder.Products {
+		for _, serial := range p.Serials {
+			if soldSerials[p.ProductId] == nil {
+				soldSerials[p.ProductId] = make(map[string]bool)
+			}
+			soldSerials[p.ProductId][serial] = true
+		}
+		key := model.RefundKey{ProductId: p.ProductId, Unit: p.Unit}
+		line := sold[key]
+		line.ProductId = p.ProductId
+		line.Unit = p.Unit
+		line.Price = p.Price
+		line.Qty += p.Qty
+		if line.Ingredients == nil {
+			line.Ingredients = p.Ingredients
+		}
+		sold[key] = line
+		if p.Cogs != nil {
+			soldCogs[key] += *p.Cogs
+		}
+	}
+
+	refund := model.Refund{
+		OrderId:    orderId,
+		EmployeeId: app.contextGetUser(r).Id,
+		StationId:  app.contextGetStationID(r),
+	}
+
+	v := validator.New()
+	v.Check(len(input.Products) > 0, "products", "must contain at least one product")
+	for _, p := range input.Products {
+		key := model.RefundKey{ProductId: p.ProductId, Unit: p.Unit}
+		name := p.ProductId
+		if p.Unit != "" {
+			name += " (" + p.Unit + ")"
+		}
+		line, ok := sold[key]
+		if !ok {
+			v.AddError("products", fmt.Sprintf("product %s is not on the order", name))
+			continue
+		}
+		v.Check(p.Qty > 0, "products", "qty must be greater than zero")
+		v.Check(p.Qty <= line.Qty-refunded[key], "products",
+			fmt.Sprintf("product %s: cannot refund more than %d", name, line.Qty-refunded[key]))
+		refunded[key] += p.Qty
+		model.ValidateSerials(v, "products", p.Serials)
+		for _, serial := range p.Serials {
+			v.Check(soldSerials[p.ProductId][serial], "products", fmt.Sprintf("product %s: serial %s was not sold on the order", p.ProductId, serial))
+		}
+
+		// Refunded goods go back into stock at what they cost when sold.
+		if cogs, ok := soldCogs[key]; ok && line.Qty > 0 {
+			unitCost := (cogs + line.Qty/2) / line.Qty
+			refundCogs := unitCost * p.Qty
+			line.UnitCost, line.Cogs = &unitCost, &refundCogs
+		}
+
+		line.Qty = p.Qty
+		line.Serials = p.Serials
+		refund.Products = append(refund.Products, line)
+		refund.Amount += float64(line.Price) * float64(line.Qty)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shift, err := app.Models.Shift.GetOpen(refund.StationId)
+	switch {
+	case err == nil:
+		refund.ShiftId = &shift.Id
+	case !errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	movements, err := app.Models.Refund.Create(&refund)
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) || isSerialError(err) || errors.Is(err, model.ErrUnknownUnit) ||
+			errors.Is(err, model.ErrRefundExceedsSold) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	app.publishStockMovements(r, movements...)
+
+	app.respondWithJSON(w, http.StatusCreated, refund)
+}
+
+func (app *Application) getOrderRefunds(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	refunds, err := app.Models.Refund.GetAllForOrder(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"refunds": refunds})
+}
+
+// getSalesByStation reports sales per register for a period (both dates inclusive,
+// defaulting to today).
+func (app *Application) getSalesByStation(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	today := time.Now().Truncate(24 * time.Hour)
+	from := app.readDate(qs, "from", today, v)
+	to := app.readDate(qs, "to", today, v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sales, err := app.Models.Order.SalesByStation(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"stations": sales})
+}
+
+// getProductMargins reports revenue, cost of goods sold and margin per product for a
+// period (both dates inclusive, default today).
+func (app *Application) getProductMargins(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	today := time.Now().Truncate(24 * time.Hour)
+	from := app.readDate(qs, "from", today, v)
+	to := app.readDate(qs, "to", today, v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	margins, err := app.Models.Order.MarginsByProduct(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"products": margins})
+}
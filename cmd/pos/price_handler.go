@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// getPriceHistory lists the price changes of a product, newest first.
+func (app *Application) getPriceHistory(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-changed_at",
+		SortSafelist: []string{"-changed_at"},
+	}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, metadata, err := app.Models.Price.History(productId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"prices": changes, "metadata": metadata})
+}
+
+// getPriceAt returns the price a product had at the time given as `at`, now by default.
+func (app *Application) getPriceAt(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	v := validator.New()
+	at := app.readTime(r.URL.Query(), "at", time.Now(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	change, err := app.Models.Price.At(productId, at)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "No Price At That Time")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{
+		"product_id": productId,
+		"at":         at,
+		"price":      change.NewPrice,
+		"change":     change,
+	})
+}
+
+// getScheduledPrices lists scheduled price changes, first to take effect first.
+func (app *Application) getScheduledPrices(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	productId := app.readInt(qs, "product_id", 0, v)
+	status := app.readString(qs, "status", "")
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "effective_at",
+		SortSafelist: []string{"effective_at"},
+	}
+
+	v.Check(status == "" || validator.In(status, model.ScheduledPricePending, model.ScheduledPriceApplied, model.ScheduledPriceCancelled),
+		"status", "invalid scheduled price status")
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	prices, metadata, err := app.Models.Price.GetScheduled(productId, status, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"scheduled_prices": prices, "metadata": metadata})
+}
+
+// schedulePrices schedules new prices for one or more products. Each price takes effect
+// at its own `effective_at`, or at the one given for the whole batch.
+func (app *Application) schedulePrices(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		EffectiveAt *time.Time `json:"effective_at"`
+		Prices      []struct {
+			ProductId   int        `json:"product_id"`
+			Price       int        `json:"price"`
+			EffectiveAt *time.Time `json:"effective_at"`
+		} `json:"prices"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	prices := make([]model.ScheduledPrice, len(input.Prices))
+	for i, line := range input.Prices {
+		prices[i] = model.ScheduledPrice{ProductId: line.ProductId, Price: line.Price}
+		if line.EffectiveAt != nil {
+			prices[i].EffectiveAt = *line.EffectiveAt
+		} else if input.EffectiveAt != nil {
+			prices[i].EffectiveAt = *input.EffectiveAt
+		}
+	}
+
+	v := validator.New()
+	if model.ValidateScheduledPrices(v, prices, time.Now()); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Price.Schedule(prices, app.contextGetUserID(r))
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"scheduled_prices": prices})
+}
+
+// cancelScheduledPrice cancels a scheduled price change that has not taken effect yet.
+func (app *Application) cancelScheduledPrice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Scheduled Price ID")
+		return
+	}
+
+	price, err := app.Models.Price.CancelScheduled(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Scheduled Price Not Found")
+		case errors.Is(err, model.ErrScheduledPriceNotPending):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, price)
+}
+
+// applyScheduledPrices gives products the scheduled prices that are due. Run by the
+// scheduled-prices worker.
+func (app *Application) applyScheduledPrices() error {
+	applied, err := app.Models.Price.ApplyDue(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, price := range applied {
+		app.logger.PrintInfo("applied scheduled price", map[string]string{
+			"scheduled_price_id": strconv.Itoa(price.Id),
+			"product_id":         strconv.Itoa(price.ProductId),
+			"price":              strconv.Itoa(price.Price),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createPriceList(w http.ResponseWriter, r *http.Request) {
+	list := model.PriceList{Active: true}
+
+	err := json.NewDecoder(r.Body).Decode(&list)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePriceList(v, &list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.PriceList.Create(&list)
+	if err != nil {
+		app.priceListError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, list)
+}
+
+func (app *Application) getAllPriceLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := app.Models.PriceList.GetAll()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"price_lists": lists})
+}
+
+func (app *Application) getPriceList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Price List ID")
+		return
+	}
+
+	list, err := app.Models.PriceList.Get(id)
+	if err != nil {
+		app.priceListError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, list)
+}
+
+func (app *Application) updatePriceList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Price List ID")
+		return
+	}
+
+	list := model.PriceList{Active: true}
+	err = json.NewDecoder(r.Body).Decode(&list)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePriceList(v, &list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.PriceList.Update(id, &list)
+	if err != nil {
+		app.priceListError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, list)
+}
+
+func (app *Application) deletePriceList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Price List ID")
+		return
+	}
+
+	err = app.Models.PriceList.Delete(id)
+	if err != nil {
+		app.priceListError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getApplicablePriceList shows which price list would price an order of a
+// `customer_group` at a `station_id` at time `at` (now by default).
+func (app *Application) getApplicablePriceList(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	customerGroup := app.readString(qs, "customer_group", "")
+	at := app.readTime(qs, "at", time.Now(), v)
+	var stationId *int
+	if id := app.readInt(qs, "station_id", 0, v); id > 0 {
+		stationId = &id
+	} else {
+		stationId = app.contextGetStationID(r)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, err := app.Models.PriceList.Applicable(customerGroup, stationId, at)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"price_list": list})
+}
+
+// priceListError responds to the errors reading and changing price lists have in common.
+func (app *Application) priceListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Price List Not Found")
+	case errors.Is(err, model.ErrDuplicatePriceList):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrUnknownProduct), errors.Is(err, model.ErrUnknownCategory),
+		errors.Is(err, model.ErrUnknownStation):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"encoding/json"
-
+	"errors"
 	"net/http"
+	"pos-rs/pkg/pos/events"
 	"pos-rs/pkg/pos/model"
 	"pos-rs/pkg/pos/validator"
 	"strconv"
@@ -20,12 +21,22 @@ func (app *Application) createProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Product.Create(&newProduct)
+	v := validator.New()
+	if model.ValidateProduct(v, &newProduct); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Product.Create(&newProduct, app.contextGetUserID(r), app.contextGetStoreID(r))
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if newProduct.Amount != 0 {
+		app.publishEvent(r, events.StockChanged, envelope{"product_id": newProduct.Id, "amount": newProduct.Amount})
+	}
+
 	app.respondWithJSON(w, http.StatusCreated, newProduct)
 }
 
@@ -39,12 +50,25 @@ func (app *Application) getProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	v := validator.New()
+	storeId, allStores := app.readStockScope(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	Product, err := app.Models.Product.Get(productId)
 	if err != nil {
 		app.respondWithError(w, http.StatusNotFound, "Not Found")
 		return
 	}
 
+	err = app.applyStockScope([]*model.Product{Product}, storeId, allStores)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	app.respondWithJSON(w, http.StatusFound, Product)
 }
 
@@ -59,15 +83,19 @@ func (app *Application) getAllProduct(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 
 	input.Name = app.readString(qs, "name", "")
-	input.Cateogry = app.readInt(qs, "category", 1, v)
+	input.Cateogry = app.readInt(qs, "category", 0, v)
+	v.Check(input.Cateogry >= 0, "category", "must not be negative")
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "name", "price", "-id", "-name", "-price"}
 
+	storeId, allStores := app.readStockScope(r, v)
+
 	if !v.Valid() {
 		app.respondWithError(w, http.StatusForbidden, "Failed Validation")
+		return
 	}
 
 	products, metadata, err := app.Models.Product.GetAll(input.Name, input.Cateogry, input.Filters)
@@ -76,9 +104,78 @@ func (app *Application) getAllProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scoped := make([]*model.Product, len(*products))
+	for i := range *products {
+		scoped[i] = &(*products)[i]
+	}
+	err = app.applyStockScope(scoped, storeId, allStores)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	app.respondWithJSON(w, http.StatusFound, envelope{"products": products, "metadata": metadata})
 }
 
+// readStockScope reads which stock a product query reports in Amount: the total across
+// stores by default, one store with store=<id>, the caller's station's store with
+// store=current, or the total plus a per-store breakdown with store=all.
+func (app *Application) readStockScope(r *http.Request, v *validator.Validator) (storeId int, allStores bool) {
+	qs := r.URL.Query()
+
+	switch app.readString(qs, "store", "") {
+	case "":
+		return 0, false
+	case "all":
+		return 0, true
+	case "current":
+		station := app.contextGetStation(r)
+		if station == nil {
+			v.AddError("store", "current requires a station credential")
+			return 0, false
+		}
+		return station.StoreId, false
+	}
+
+	storeId = app.readInt(qs, "store", 0, v)
+	v.Check(storeId > 0, "store", "must be a store ID, current or all")
+	return storeId, false
+}
+
+// applyStockScope replaces the total stock of products by the stock of one store, or
+// adds the per-store breakdown when allStores is set.
+func (app *Application) applyStockScope(products []*model.Product, storeId int, allStores bool) error {
+	if storeId == 0 && !allStores {
+		return nil
+	}
+
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.Id
+	}
+
+	levels, err := app.Models.Stock.GetLevels(ids, storeId)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		if allStores {
+			p.Stocks = levels[p.Id]
+			continue
+		}
+		p.StoreId = &storeId
+		p.Amount = 0
+		p.StockFlaggedAt = nil
+		if stock := levels[p.Id]; len(stock) > 0 {
+			p.Amount = stock[0].Amount
+			p.StockFlaggedAt = stock[0].StockFlaggedAt
+		}
+	}
+
+	return nil
+}
+
 func (app *Application) updateProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	param := vars["productId"]
@@ -96,16 +193,67 @@ func (app *Application) updateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Product.Update(productId, &updatedProduct)
+	v := validator.New()
+	if model.ValidateProduct(v, &updatedProduct); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Product.Update(productId, &updatedProduct, app.contextGetUserID(r))
 	updatedProduct.Id = productId
 	if err != nil {
+		if errors.Is(err, model.ErrBaseUnitInUse) || errors.Is(err, model.ErrTrackingInUse) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// The reorder point may have changed.
+	app.checkLowStock()
+
 	app.respondWithJSON(w, http.StatusOK, updatedProduct)
 }
 
+// revalueProduct sets the unit cost of a product and of its stock on hand.
+func (app *Application) revalueProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	var input struct {
+		Cost *int `json:"cost"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Cost != nil, "cost", "must be provided")
+	if v.Check(input.Cost == nil || *input.Cost >= 0, "cost", "must not be negative"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	product, err := app.Models.Product.Revalue(productId, *input.Cost)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, product)
+}
+
 func (app *Application) deleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	param := vars["productId"]
@@ -115,11 +263,26 @@ func (app *Application) deleteProduct(w http.ResponseWriter, r *http.Request) {
 		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
 		return
 	}
+
+	images, err := app.Models.Product.GetImages(productId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
 	err = app.Models.Product.Delete(productId)
 	if err != nil {
+		if errors.Is(err, model.ErrIngredientInUse) || errors.Is(err, model.ErrProductInUse) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
 	}
 
+	for _, image := range images {
+		app.removeMedia(image.Keys)
+	}
+
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
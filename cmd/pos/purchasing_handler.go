@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createSupplier(w http.ResponseWriter, r *http.Request) {
+	var supplier model.Supplier
+
+	err := json.NewDecoder(r.Body).Decode(&supplier)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateSupplier(v, &supplier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Purchasing.CreateSupplier(&supplier)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, supplier)
+}
+
+func (app *Application) getAllSuppliers(w http.ResponseWriter, r *http.Request) {
+	suppliers, err := app.Models.Purchasing.GetAllSuppliers()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"suppliers": suppliers})
+}
+
+func (app *Application) getSupplier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Supplier ID")
+		return
+	}
+
+	supplier, err := app.Models.Purchasing.GetSupplier(id)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Supplier Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, supplier)
+}
+
+func (app *Application) updateSupplier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Supplier ID")
+		return
+	}
+
+	var supplier model.Supplier
+	err = json.NewDecoder(r.Body).Decode(&supplier)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateSupplier(v, &supplier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Purchasing.UpdateSupplier(id, &supplier)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Supplier Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, supplier)
+}
+
+func (app *Application) deleteSupplier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Supplier ID")
+		return
+	}
+
+	err = app.Models.Purchasing.DeleteSupplier(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Supplier Not Found")
+		case errors.Is(err, model.ErrSupplierInUse):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// purchaseOrderError responds to the errors creating and changing purchase orders have
+// in common.
+func (app *Application) purchaseOrderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Purchase Order Not Found")
+	case errors.Is(err, model.ErrUnknownSupplier), errors.Is(err, model.ErrUnknownProduct),
+		errors.Is(err, model.ErrUnknownPurchaseOrderLine), errors.Is(err, model.ErrUnknownStore),
+		errors.Is(err, model.ErrLotsNotTracked), isSerialError(err), errors.Is(err, model.ErrUnknownUnit):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, model.ErrPurchaseOrderNotDraft), errors.Is(err, model.ErrPurchaseOrderNotOpen):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (app *Application) createPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var order model.PurchaseOrder
+
+	err := json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePurchaseOrder(v, &order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order.EmployeeId = app.contextGetUserID(r)
+	if order.StoreId == 0 {
+		order.StoreId = app.contextGetStoreID(r)
+	}
+	err = app.Models.Purchasing.CreatePurchaseOrder(&order)
+	if err != nil {
+		app.purchaseOrderError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, order)
+}
+
+func (app *Application) getPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	supplierId := app.readInt(qs, "supplier_id", 0, v)
+	status := app.readString(qs, "status", "")
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: []string{"-id"},
+	}
+
+	v.Check(status == "" || validator.In(status, model.PurchaseOrderDraft, model.PurchaseOrderSent,
+		model.PurchaseOrderPartiallyReceived, model.PurchaseOrderReceived), "status", "invalid purchase order status")
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.Models.Purchasing.GetPurchaseOrders(supplierId, status, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"purchase_orders": orders, "metadata": metadata})
+}
+
+func (app *Application) getPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	order, err := app.Models.Purchasing.GetPurchaseOrder(id)
+	if err != nil {
+		app.purchaseOrderError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, order)
+}
+
+func (app *Application) updatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	var order model.PurchaseOrder
+	err = json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePurchaseOrder(v, &order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Purchasing.UpdatePurchaseOrder(id, &order)
+	if err != nil {
+		app.purchaseOrderError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, order)
+}
+
+func (app *Application) deletePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	err = app.Models.Purchasing.DeletePurchaseOrder(id)
+	if err != nil {
+		app.purchaseOrderError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (app *Application) sendPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	order, err := app.Models.Purchasing.Send(id)
+	if err != nil {
+		app.purchaseOrderError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, order)
+}
+
+// receivePurchaseOrder books the goods of a delivery into stock.
+func (app *Application) receivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	var input struct {
+		Lines []model.PurchaseReceipt `json:"lines"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePurchaseReceipts(v, input.Lines); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, movements, err := app.Models.Purchasing.Receive(id, input.Lines, app.contextGetUserID(r))
+	if err != nil {
+		app.purchaseOrderError(w, err)
+		return
+	}
+
+	app.publishStockMovements(r, movements...)
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"purchase_order": order, "stock_movements": movements})
+}
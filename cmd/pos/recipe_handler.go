@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// getRecipe returns the ingredients of a product, with what they cost.
+func (app *Application) getRecipe(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	recipe, err := app.Models.Recipe.Get(productId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, recipe)
+}
+
+// setRecipe replaces the ingredients of a product. An empty list removes the recipe.
+func (app *Application) setRecipe(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	var input struct {
+		Lines []model.RecipeLine `json:"lines"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	recipe := &model.Recipe{ProductId: productId, Lines: input.Lines}
+
+	v := validator.New()
+	if model.ValidateRecipe(v, recipe); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Recipe.Set(recipe)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+		case errors.Is(err, model.ErrUnknownProduct), errors.Is(err, model.ErrNestedRecipe),
+			errors.Is(err, model.ErrSerializedIngredient), errors.Is(err, model.ErrUnknownUnit):
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, recipe)
+}
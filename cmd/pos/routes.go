@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
+
+	"pos-rs/pkg/pos/handler"
+	"pos-rs/pkg/pos/storage"
 
 	"github.com/gorilla/mux"
 )
@@ -12,6 +16,12 @@ func (app *Application) routes() http.Handler {
 
 	r.HandleFunc("/api/v1/healthcheck", app.healthcheckHandler).Methods("GET")
 
+	// Images on the local filesystem are served by the API itself, unless another server
+	// serves them from an absolute media URL.
+	if local, ok := app.media.(*storage.Local); ok && strings.HasPrefix(local.BaseURL, "/") {
+		r.PathPrefix(local.BaseURL+"/").Handler(http.StripPrefix(local.BaseURL, local.Handler())).Methods("GET", "HEAD")
+	}
+
 	v1 := r.PathPrefix("/api/v1").Subrouter()
 	fmt.Println("Running")
 
@@ -22,25 +32,151 @@ func (app *Application) routes() http.Handler {
 	v1.HandleFunc("/employees", app.registerEmployee).Methods("POST")
 	v1.HandleFunc("/employees/{id}", app.updateEmployee).Methods("PUT")
 	v1.HandleFunc("/employees/{id}", app.requireActivatedUser(app.deleteEmployee)).Methods("DELETE")
+	v1.HandleFunc("/employees/{id}/timesheet", app.requirePermission("timeclock:write", app.getTimesheet)).Methods("GET")
+	v1.HandleFunc("/employees/{id}/commissions", app.requireActivatedUser(app.getCommissionStatement)).Methods("GET")
+
+	v1.HandleFunc("/timeclock/clock-in", app.requireActivatedUser(app.clockIn)).Methods("POST")
+	v1.HandleFunc("/timeclock/clock-out", app.requireActivatedUser(app.clockOut)).Methods("POST")
+	v1.HandleFunc("/timeclock/breaks/start", app.requireActivatedUser(app.startBreak)).Methods("POST")
+	v1.HandleFunc("/timeclock/breaks/end", app.requireActivatedUser(app.endBreak)).Methods("POST")
+	v1.HandleFunc("/timeclock/entries/{id}", app.requireActivatedUser(app.getTimeEntry)).Methods("GET")
+	v1.HandleFunc("/timeclock/entries/{id}", app.requirePermission("timeclock:write", app.editTimeEntry)).Methods("PUT")
+	v1.HandleFunc("/timeclock/entries/{id}/audits", app.requirePermission("timeclock:write", app.getTimeEntryAudits)).Methods("GET")
 
 	v1.HandleFunc("/categories", app.getAllCategory).Methods("GET")
+	v1.HandleFunc("/categories/tree", app.getCategoryTree).Methods("GET")
 	v1.HandleFunc("/categories/{categoryId}", app.getCategory).Methods("GET")
-	v1.HandleFunc("/categories", app.createCategory).Methods("POST")
-	v1.HandleFunc("/categories/{categoryId}", app.updateCategory).Methods("PUT")
-	v1.HandleFunc("/categories/{categoryId}", app.deleteCategory).Methods("DELETE")
+	v1.HandleFunc("/categories", app.requirePermission("products:write", app.createCategory)).Methods("POST")
+	v1.HandleFunc("/categories/{categoryId}", app.requirePermission("products:write", app.updateCategory)).Methods("PUT")
+	v1.HandleFunc("/categories/{categoryId}", app.requirePermission("products:write", app.deleteCategory)).Methods("DELETE")
+	v1.HandleFunc("/categories/{categoryId}/move", app.requirePermission("products:write", app.moveCategory)).Methods("POST")
+	v1.HandleFunc("/categories/{categoryId}/merge", app.requirePermission("products:write", app.mergeCategory)).Methods("POST")
 
 	v1.HandleFunc("/products", app.getAllProduct).Methods("GET")
 	v1.HandleFunc("/products/{productId}", app.getProduct).Methods("GET")
 	v1.HandleFunc("/products", app.createProduct).Methods("POST")
 	v1.HandleFunc("/products/{productId}", app.updateProduct).Methods("PUT")
 	v1.HandleFunc("/products/{productId}", app.requirePermission("products:write", app.deleteProduct)).Methods("DELETE")
+	v1.HandleFunc("/products/{productId}/stock-movements", app.requirePermission("inventory:read", app.getStockMovements)).Methods("GET")
+	v1.HandleFunc("/products/{productId}/stock-movements", app.requirePermission("inventory:write", app.createStockMovement)).Methods("POST")
+	v1.HandleFunc("/products/{productId}/serials", app.requirePermission("inventory:read", app.getProductSerials)).Methods("GET")
+	v1.HandleFunc("/products/{productId}/lots", app.requirePermission("inventory:read", app.getProductLots)).Methods("GET")
+	v1.HandleFunc("/serials/{serial}", app.requirePermission("inventory:read", app.lookupSerial)).Methods("GET")
+	v1.HandleFunc("/products/{productId}/recipe", app.requirePermission("inventory:read", app.getRecipe)).Methods("GET")
+	v1.HandleFunc("/products/{productId}/recipe", app.requirePermission("products:write", app.setRecipe)).Methods("PUT")
+	v1.HandleFunc("/products/{productId}/images", app.requirePermission("products:write", app.uploadProductImage)).Methods("POST")
+	v1.HandleFunc("/products/{productId}/images/{imageId}", app.requirePermission("products:write", app.deleteProductImage)).Methods("DELETE")
+	v1.HandleFunc("/products/{productId}/prices", app.getPriceHistory).Methods("GET")
+	v1.HandleFunc("/products/{productId}/price", app.getPriceAt).Methods("GET")
+	v1.HandleFunc("/products/{productId}/cost", app.requirePermission("inventory:write", app.revalueProduct)).Methods("PUT")
+
+	v1.HandleFunc("/scheduled-prices", app.getScheduledPrices).Methods("GET")
+	v1.HandleFunc("/scheduled-prices", app.requirePermission("products:write", app.schedulePrices)).Methods("POST")
+	v1.HandleFunc("/scheduled-prices/{id}", app.requirePermission("products:write", app.cancelScheduledPrice)).Methods("DELETE")
+
+	v1.HandleFunc("/price-lists", app.getAllPriceLists).Methods("GET")
+	v1.HandleFunc("/price-lists/applicable", app.getApplicablePriceList).Methods("GET")
+	v1.HandleFunc("/price-lists/{id}", app.getPriceList).Methods("GET")
+	v1.HandleFunc("/price-lists", app.requirePermission("pricing:write", app.createPriceList)).Methods("POST")
+	v1.HandleFunc("/price-lists/{id}", app.requirePermission("pricing:write", app.updatePriceList)).Methods("PUT")
+	v1.HandleFunc("/price-lists/{id}", app.requirePermission("pricing:write", app.deletePriceList)).Methods("DELETE")
+
+	v1.HandleFunc("/stocktakes", app.requirePermission("inventory:read", app.getStocktakes)).Methods("GET")
+	v1.HandleFunc("/stocktakes/{id}", app.requirePermission("inventory:read", app.getStocktake)).Methods("GET")
+	v1.HandleFunc("/stocktakes/{id}/variance", app.requirePermission("inventory:read", app.getStocktakeVariance)).Methods("GET")
+	v1.HandleFunc("/stocktakes/{id}/usage", app.requirePermission("inventory:read", app.getStocktakeUsage)).Methods("GET")
+	v1.HandleFunc("/stocktakes", app.requirePermission("inventory:write", app.createStocktake)).Methods("POST")
+	v1.HandleFunc("/stocktakes/{id}/counts", app.requirePermission("inventory:write", app.idempotent(app.addStocktakeCounts))).Methods("POST")
+	v1.HandleFunc("/stocktakes/{id}/finalize", app.requirePermission("inventory:write", app.finalizeStocktake)).Methods("POST")
+	v1.HandleFunc("/stocktakes/{id}/cancel", app.requirePermission("inventory:write", app.cancelStocktake)).Methods("POST")
+
+	v1.HandleFunc("/transfers", app.requirePermission("inventory:read", app.getTransfers)).Methods("GET")
+	v1.HandleFunc("/transfers/{id}", app.requirePermission("inventory:read", app.getTransfer)).Methods("GET")
+	v1.HandleFunc("/transfers", app.requirePermission("inventory:write", app.createTransfer)).Methods("POST")
+	v1.HandleFunc("/transfers/{id}", app.requirePermission("inventory:write", app.updateTransfer)).Methods("PUT")
+	v1.HandleFunc("/transfers/{id}", app.requirePermission("inventory:write", app.deleteTransfer)).Methods("DELETE")
+	v1.HandleFunc("/transfers/{id}/ship", app.requirePermission("inventory:write", app.shipTransfer)).Methods("POST")
+	v1.HandleFunc("/transfers/{id}/receive", app.requirePermission("inventory:write", app.idempotent(app.receiveTransfer))).Methods("POST")
+	v1.HandleFunc("/transfers/{id}/cancel", app.requirePermission("inventory:write", app.cancelTransfer)).Methods("POST")
+
+	v1.HandleFunc("/suppliers", app.requirePermission("purchasing:read", app.getAllSuppliers)).Methods("GET")
+	v1.HandleFunc("/suppliers/{id}", app.requirePermission("purchasing:read", app.getSupplier)).Methods("GET")
+	v1.HandleFunc("/suppliers", app.requirePermission("purchasing:write", app.createSupplier)).Methods("POST")
+	v1.HandleFunc("/suppliers/{id}", app.requirePermission("purchasing:write", app.updateSupplier)).Methods("PUT")
+	v1.HandleFunc("/suppliers/{id}", app.requirePermission("purchasing:write", app.deleteSupplier)).Methods("DELETE")
+	v1.HandleFunc("/purchase-orders", app.requirePermission("purchasing:read", app.getPurchaseOrders)).Methods("GET")
+	v1.HandleFunc("/purchase-orders/{id}", app.requirePermission("purchasing:read", app.getPurchaseOrder)).Methods("GET")
+	v1.HandleFunc("/purchase-orders", app.requirePermission("purchasing:write", app.createPurchaseOrder)).Methods("POST")
+	v1.HandleFunc("/purchase-orders/{id}", app.requirePermission("purchasing:write", app.updatePurchaseOrder)).Methods("PUT")
+	v1.HandleFunc("/purchase-orders/{id}", app.requirePermission("purchasing:write", app.deletePurchaseOrder)).Methods("DELETE")
+	v1.HandleFunc("/purchase-orders/{id}/send", app.requirePermission("purchasing:write", app.sendPurchaseOrder)).Methods("POST")
+	v1.HandleFunc("/purchase-orders/{id}/receive", app.requirePermission("purchasing:write", app.idempotent(app.receivePurchaseOrder))).Methods("POST")
 
 	v1.HandleFunc("/orders", app.getAllOrders).Methods("GET")
 	v1.HandleFunc("/orders/{id}", app.getOrder).Methods("GET")
-	v1.HandleFunc("/orders", app.createOrder).Methods("POST")
-	v1.HandleFunc("/orders/{id}/products", app.addProductToOrder).Methods("PUT")
-	v1.HandleFunc("/orders/{id}/products/{productId}", app.removeProductFromOrder).Methods("PUT")
-	v1.HandleFunc("/orders/{id}", app.deleteOrder).Methods("DELETE")
+	v1.HandleFunc("/orders", app.idempotent(app.createOrder)).Methods("POST")
+	v1.HandleFunc("/orders/{id}/products", app.idempotent(app.addProductToOrder)).Methods("PUT")
+	v1.HandleFunc("/orders/{id}/products/{productId}", app.idempotent(app.removeProductFromOrder)).Methods("PUT")
+	v1.HandleFunc("/orders/{id}", app.idempotent(app.deleteOrder)).Methods("DELETE")
+	v1.HandleFunc("/orders/{id}/fire", app.requireActivatedUser(app.fireOrderHandler)).Methods("POST")
+	v1.HandleFunc("/orders/{id}/kitchen-tickets", app.getOrderKitchenTickets).Methods("GET")
+	v1.HandleFunc("/orders/{id}/refunds", app.requirePermission("orders:read", app.getOrderRefunds)).Methods("GET")
+	v1.HandleFunc("/orders/{id}/refunds", app.requireActivatedUser(app.idempotent(app.createRefund))).Methods("POST")
+
+	v1.HandleFunc("/commission-rules", app.requirePermission("commissions:read", app.getAllCommissionRules)).Methods("GET")
+	v1.HandleFunc("/commission-rules/{id}", app.requirePermission("commissions:read", app.getCommissionRule)).Methods("GET")
+	v1.HandleFunc("/commission-rules", app.requirePermission("commissions:write", app.createCommissionRule)).Methods("POST")
+	v1.HandleFunc("/commission-rules/{id}", app.requirePermission("commissions:write", app.updateCommissionRule)).Methods("PUT")
+	v1.HandleFunc("/commission-rules/{id}", app.requirePermission("commissions:write", app.deleteCommissionRule)).Methods("DELETE")
+
+	v1.HandleFunc("/stores", app.getAllStores).Methods("GET")
+	v1.HandleFunc("/stores/{id}", app.getStore).Methods("GET")
+	v1.HandleFunc("/stores", app.requirePermission("stores:write", app.createStore)).Methods("POST")
+	v1.HandleFunc("/stores/{id}", app.requirePermission("stores:write", app.updateStore)).Methods("PUT")
+	v1.HandleFunc("/stores/{id}", app.requirePermission("stores:write", app.deleteStore)).Methods("DELETE")
+
+	stations := handler.NewStationHandler(app.Models)
+	v1.HandleFunc("/stations", stations.GetAllStations).Methods("GET")
+	v1.HandleFunc("/stations/{stationId}", stations.GetStation).Methods("GET")
+	v1.HandleFunc("/stations", app.requirePermission("stations:write", stations.CreateStation)).Methods("POST")
+	v1.HandleFunc("/stations/{stationId}", app.requirePermission("stations:write", stations.UpdateStation)).Methods("PUT")
+	v1.HandleFunc("/stations/{stationId}", app.requirePermission("stations:write", stations.DeleteStation)).Methods("DELETE")
+	v1.HandleFunc("/stations/{stationId}/register", app.requirePermission("stations:write", stations.RegisterStation)).Methods("POST")
+	v1.HandleFunc("/stations/{stationId}/display-credential", app.requirePermission("stations:write", stations.IssueDisplayCredential)).Methods("POST")
+
+	v1.HandleFunc("/display", app.requireDisplay(app.getDisplay)).Methods("GET")
+	v1.HandleFunc("/display/stream", app.requireDisplay(app.streamDisplay)).Methods("GET")
+
+	v1.HandleFunc("/prep-stations", app.getAllPrepStations).Methods("GET")
+	v1.HandleFunc("/prep-stations/{id}", app.getPrepStation).Methods("GET")
+	v1.HandleFunc("/prep-stations", app.requirePermission("kitchen:write", app.createPrepStation)).Methods("POST")
+	v1.HandleFunc("/prep-stations/{id}", app.requirePermission("kitchen:write", app.updatePrepStation)).Methods("PUT")
+	v1.HandleFunc("/prep-stations/{id}", app.requirePermission("kitchen:write", app.deletePrepStation)).Methods("DELETE")
+	v1.HandleFunc("/prep-stations/{id}/tickets", app.requirePermission("kitchen:read", app.getPrepStationTickets)).Methods("GET")
+	v1.HandleFunc("/kitchen-tickets/{id}/bump", app.requirePermission("kitchen:write", app.bumpKitchenTicket)).Methods("POST")
+	v1.HandleFunc("/kitchen-tickets/{id}/recall", app.requirePermission("kitchen:write", app.recallKitchenTicket)).Methods("POST")
+
+	v1.HandleFunc("/reports/sales-by-station", app.requirePermission("orders:read", app.getSalesByStation)).Methods("GET")
+	v1.HandleFunc("/reports/prep-times", app.requirePermission("kitchen:read", app.getPrepTimes)).Methods("GET")
+	v1.HandleFunc("/reports/margins", app.requirePermission("inventory:read", app.getProductMargins)).Methods("GET")
+	v1.HandleFunc("/reports/expiring", app.requirePermission("inventory:read", app.getExpiringLots)).Methods("GET")
+	v1.HandleFunc("/reports/low-stock", app.requirePermission("inventory:read", app.getLowStock)).Methods("GET")
+	v1.HandleFunc("/reports/stock-in-transit", app.requirePermission("inventory:read", app.getStockInTransit)).Methods("GET")
+	v1.HandleFunc("/reports/transfers", app.requirePermission("inventory:read", app.getTransferHistory)).Methods("GET")
+
+	v1.HandleFunc("/sync/orders", app.requireStation(app.syncOrders)).Methods("POST")
+	v1.HandleFunc("/sync/flagged-products", app.getFlaggedProducts).Methods("GET")
+	v1.HandleFunc("/catalog/changes", app.getCatalogChanges).Methods("GET")
+
+	v1.HandleFunc("/events", app.requireActivatedUser(app.streamEvents)).Methods("GET")
+	v1.HandleFunc("/events/ws", app.requireActivatedUser(app.streamEventsWebSocket)).Methods("GET")
+
+	v1.HandleFunc("/shifts", app.requireActivatedUser(app.openShift)).Methods("POST")
+	v1.HandleFunc("/shifts/current", app.requirePermission("shifts:read", app.getCurrentShift)).Methods("GET")
+	v1.HandleFunc("/shifts/{id}/close", app.requireActivatedUser(app.closeShift)).Methods("POST")
+	v1.HandleFunc("/shifts/{id}/report", app.requirePermission("shifts:read", app.getShiftReport)).Methods("GET")
+	v1.HandleFunc("/shifts/{id}/cash-movements", app.requirePermission("shifts:read", app.getShiftCashMovements)).Methods("GET")
+	v1.HandleFunc("/cash-movements", app.requireActivatedUser(app.idempotent(app.createCashMovement))).Methods("POST")
 
 	return app.recoverPanic(app.rateLimit(app.authenticate(r)))
 }
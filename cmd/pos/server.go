@@ -24,6 +24,8 @@ func (app *Application) serve() error {
 
 	shutdownError := make(chan error)
 
+	app.startWorkers()
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -38,6 +40,8 @@ func (app *Application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		close(app.shutdown)
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
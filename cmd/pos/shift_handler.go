@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/events"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) openShift(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OpeningCash float64 `json:"opening_cash"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.OpeningCash >= 0, "opening_cash", "must not be negative"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shift := model.Shift{
+		EmployeeId:  app.contextGetUser(r).Id,
+		StationId:   app.contextGetStationID(r),
+		OpeningCash: input.OpeningCash,
+	}
+
+	err = app.Models.Shift.Open(&shift)
+	if err != nil {
+		if errors.Is(err, model.ErrShiftAlreadyOpen) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.publishEvent(r, events.ShiftOpened, shift)
+
+	app.respondWithJSON(w, http.StatusCreated, shift)
+}
+
+func (app *Application) getCurrentShift(w http.ResponseWriter, r *http.Request) {
+	shift, err := app.Models.Shift.GetOpen(app.contextGetStationID(r))
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "No Open Shift")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, shift)
+}
+
+func (app *Application) closeShift(w http.ResponseWriter, r *http.Request) {
+	shiftId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Shift ID")
+		return
+	}
+
+	var input struct {
+		CountedCash float64 `json:"counted_cash"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.CountedCash >= 0, "counted_cash", "must not be negative"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.Models.Shift.Close(shiftId, input.CountedCash)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Open Shift Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	report, err := app.Models.Shift.ZReport(shiftId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.publishEvent(r, events.ShiftClosed, report)
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"z_report": report})
+}
+
+func (app *Application) getShiftReport(w http.ResponseWriter, r *http.Request) {
+	shiftId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Shift ID")
+		return
+	}
+
+	report, err := app.Models.Shift.ZReport(shiftId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Shift Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"z_report": report})
+}
+
+func (app *Application) createCashMovement(w http.ResponseWriter, r *http.Request) {
+	var movement model.CashMovement
+
+	err := json.NewDecoder(r.Body).Decode(&movement)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateCashMovement(v, &movement); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shift, err := app.Models.Shift.GetOpen(app.contextGetStationID(r))
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusConflict, "No Open Shift")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	movement.ShiftId = shift.Id
+	movement.EmployeeId = app.contextGetUser(r).Id
+
+	err = app.Models.CashMovement.Create(&movement)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, movement)
+}
+
+func (app *Application) getShiftCashMovements(w http.ResponseWriter, r *http.Request) {
+	shiftId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Shift ID")
+		return
+	}
+
+	movements, err := app.Models.CashMovement.GetAllForShift(shiftId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"cash_movements": movements})
+}
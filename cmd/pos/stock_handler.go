@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pos-rs/pkg/pos/events"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// publishStockMovements tells event stream subscribers about new stock levels and wakes
+// the low-stock alert worker when stock went down.
+func (app *Application) publishStockMovements(r *http.Request, movements ...*model.StockMovement) {
+	for _, m := range movements {
+		app.publishStockMovements(r, m.Ingredients...)
+		if m.Id == 0 {
+			continue
+		}
+		if m.QtyDelta < 0 {
+			app.checkLowStock()
+		}
+		app.publishEvent(r, events.StockChanged, envelope{
+			"product_id": m.ProductId,
+			"amount":     m.BalanceAfter,
+			"qty_delta":  m.QtyDelta,
+			"type":       m.Type,
+			"store_id":   m.StoreId,
+		})
+	}
+}
+
+// createStockMovement books a goods receipt or a manual adjustment for a product.
+func (app *Application) createStockMovement(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	var input struct {
+		Type       string   `json:"type"`
+		QtyDelta   int      `json:"qty_delta"`
+		Reason     string   `json:"reason"`
+		SourceType string   `json:"source_type"`
+		SourceId   string   `json:"source_id"`
+		StoreId    *int     `json:"store_id"`
+		UnitCost   *int     `json:"unit_cost"`
+		LotNumber  string   `json:"lot_number"`
+		ExpiresOn  *string  `json:"expires_on"`
+		Serials    []string `json:"serials"`
+		Unit       string   `json:"unit"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	movement := &model.StockMovement{
+		ProductId:  productId,
+		Type:       input.Type,
+		QtyDelta:   input.QtyDelta,
+		Reason:     input.Reason,
+		EmployeeId: app.contextGetUserID(r),
+		SourceType: input.SourceType,
+		SourceId:   input.SourceId,
+		StoreId:    app.contextGetStoreID(r),
+		UnitCost:   input.UnitCost,
+		Serials:    input.Serials,
+		Unit:       input.Unit,
+	}
+	if input.LotNumber != "" || input.ExpiresOn != nil {
+		movement.Lots = []model.LotAllocation{{LotNumber: input.LotNumber, ExpiresOn: input.ExpiresOn, Qty: input.QtyDelta}}
+	}
+	if input.StoreId != nil {
+		movement.StoreId = *input.StoreId
+	}
+
+	v := validator.New()
+	if model.ValidateManualMovement(v, movement); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Stock.Record(movement)
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownProduct) {
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+			return
+		}
+		if errors.Is(err, model.ErrUnknownStore) || errors.Is(err, model.ErrLotsNotTracked) || isSerialError(err) ||
+			errors.Is(err, model.ErrUnknownUnit) {
+			app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.publishStockMovements(r, movement)
+
+	app.respondWithJSON(w, http.StatusCreated, movement)
+}
+
+// getStockMovements returns a product's movement history, newest first.
+func (app *Application) getStockMovements(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	movementType := app.readString(qs, "type", "")
+	storeId := app.readInt(qs, "store_id", 0, v)
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: []string{"-id"},
+	}
+
+	v.Check(movementType == "" || validator.In(movementType, model.MovementSale, model.MovementRefund, model.MovementReceipt,
+		model.MovementAdjustment, model.MovementTransfer, model.MovementStocktake), "type", "invalid movement type")
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movements, metadata, err := app.Models.Stock.GetForProduct(productId, movementType, storeId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"stock_movements": movements, "metadata": metadata})
+}
+
+// getProductSerials lists the units of a serialized product (`status`, `store_id`, `page`,
+// `page_size`).
+func (app *Application) getProductSerials(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	storeId := app.readInt(qs, "store_id", 0, v)
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "serial",
+		SortSafelist: []string{"serial"},
+	}
+
+	v.Check(status == "" || validator.In(status, model.SerialInStock, model.SerialSold, model.SerialRemoved), "status", "invalid serial status")
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	serials, metadata, err := app.Models.Stock.GetSerials(productId, status, storeId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"serials": serials, "metadata": metadata})
+}
+
+// lookupSerial shows every unit with a serial number together with its movement history,
+// for instance for a warranty claim.
+func (app *Application) lookupSerial(w http.ResponseWriter, r *http.Request) {
+	serials, err := app.Models.Stock.LookupSerial(mux.Vars(r)["serial"])
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Serial Number Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"serials": serials})
+}
+
+// isSerialError reports whether err is about the serial numbers given with stock.
+func isSerialError(err error) bool {
+	return errors.Is(err, model.ErrNotSerialized) || errors.Is(err, model.ErrSerialRequired) ||
+		errors.Is(err, model.ErrSerialUnavailable) || errors.Is(err, model.ErrSerialInStock)
+}
+
+// getProductLots lists the lots of a product that still hold stock, first to expire
+// first, in all stores or in the one given by store_id.
+func (app *Application) getProductLots(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	v := validator.New()
+	storeId := app.readInt(r.URL.Query(), "store_id", 0, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lots, err := app.Models.Stock.GetLots(productId, storeId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"lots": lots})
+}
+
+// getExpiringLots lists the lots holding stock that expire within the next days (default
+// 30), expired ones included, in all stores or in the one given by store_id.
+func (app *Application) getExpiringLots(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	days := app.readInt(qs, "days", 30, v)
+	storeId := app.readInt(qs, "store_id", 0, v)
+	if v.Check(days >= 0 && days <= 3650, "days", "must be between 0 and 3650"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lots, err := app.Models.Stock.GetExpiring(days, storeId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"lots": lots})
+}
+
+// getLowStock lists the products at or below their reorder point.
+func (app *Application) getLowStock(w http.ResponseWriter, r *http.Request) {
+	products, err := app.Models.Product.GetLowStock()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"products": products})
+}
+
+// checkLowStock asks the low-stock alert worker to run soon. It never blocks; a run that
+// is already pending covers this request too.
+func (app *Application) checkLowStock() {
+	select {
+	case app.lowStock <- struct{}{}:
+	default:
+	}
+}
+
+// alertLowStock sends one alert for every product that dropped to its reorder point since
+// it was last above it: a log entry, a stock.low event and, when configured, a webhook.
+// Webhook deliveries that fail are tried again on every later run until they succeed or
+// the product is no longer low.
+func (app *Application) alertLowStock() error {
+	webhook := app.Config.Alerts.LowStockWebhook
+
+	products, err := app.Models.Product.ClaimLowStockAlerts(webhook != "")
+	if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		alert := lowStockAlert(p)
+
+		app.logger.PrintInfo("product is low on stock", map[string]string{
+			"product_id":    strconv.Itoa(p.Id),
+			"name":          p.Name,
+			"amount":        strconv.Itoa(p.Amount),
+			"reorder_point": strconv.Itoa(*p.ReorderPoint),
+		})
+		app.events.Publish(events.Event{Type: events.StockLow, Data: alert})
+	}
+
+	if webhook == "" {
+		return nil
+	}
+
+	pending, err := app.Models.Product.GetPendingLowStockWebhooks()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		if err := app.postWebhook(webhook, envelope{"type": events.StockLow, "data": lowStockAlert(p)}); err != nil {
+			app.logger.PrintError(err, map[string]string{"webhook": webhook, "product_id": strconv.Itoa(p.Id)})
+			continue
+		}
+		if err := app.Models.Product.LowStockWebhookDelivered(p.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func lowStockAlert(p model.LowStockProduct) envelope {
+	return envelope{
+		"product_id":    p.Id,
+		"name":          p.Name,
+		"amount":        p.Amount,
+		"reorder_point": *p.ReorderPoint,
+		"suggested_qty": p.SuggestedQty,
+	}
+}
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// postWebhook POSTs payload as JSON to url. Any response other than 2xx is an error.
+func (app *Application) postWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// stocktakeError responds to the errors the stocktake endpoints have in common.
+func (app *Application) stocktakeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Stocktake Not Found")
+	case errors.Is(err, model.ErrNotInStocktake), errors.Is(err, model.ErrUnknownStore), errors.Is(err, model.ErrUnknownUnit):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, model.ErrStocktakeClosed):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (app *Application) createStocktake(w http.ResponseWriter, r *http.Request) {
+	var stocktake model.Stocktake
+
+	err := json.NewDecoder(r.Body).Decode(&stocktake)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStocktake(v, &stocktake); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stocktake.EmployeeId = app.contextGetUserID(r)
+	if stocktake.StoreId == 0 {
+		stocktake.StoreId = app.contextGetStoreID(r)
+	}
+	err = app.Models.Stocktake.Create(&stocktake)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, stocktake)
+}
+
+func (app *Application) getStocktakes(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+	storeId := app.readInt(qs, "store_id", 0, v)
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: []string{"-id"},
+	}
+
+	v.Check(status == "" || validator.In(status, model.StocktakeOpen, model.StocktakeFinalized, model.StocktakeCancelled),
+		"status", "must be open, finalized or cancelled")
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stocktakes, metadata, err := app.Models.Stocktake.GetAll(status, storeId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"stocktakes": stocktakes, "metadata": metadata})
+}
+
+func (app *Application) getStocktake(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Stocktake ID")
+		return
+	}
+
+	stocktake, err := app.Models.Stocktake.Get(id)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, stocktake)
+}
+
+// addStocktakeCounts enters counts from a counting device.
+func (app *Application) addStocktakeCounts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Stocktake ID")
+		return
+	}
+
+	var input struct {
+		Counts []model.StocktakeCount `json:"counts"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStocktakeCounts(v, input.Counts); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Stocktake.AddCounts(id, input.Counts, app.contextGetUserID(r), app.contextGetStationID(r))
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	stocktake, err := app.Models.Stocktake.Get(id)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, stocktake)
+}
+
+// getStocktakeVariance reports counted against expected quantities, in units and value.
+func (app *Application) getStocktakeVariance(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Stocktake ID")
+		return
+	}
+
+	report, err := app.Models.Stocktake.Variance(id)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, report)
+}
+
+// getStocktakeUsage reports theoretical against actual usage of the recipe ingredients
+// the stocktake counted.
+func (app *Application) getStocktakeUsage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Stocktake ID")
+		return
+	}
+
+	report, err := app.Models.Stocktake.Usage(id)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, report)
+}
+
+// finalizeStocktake closes the stocktake and adjusts stock by the counted variance.
+func (app *Application) finalizeStocktake(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Stocktake ID")
+		return
+	}
+
+	var input struct {
+		ZeroUncounted bool `json:"zero_uncounted"`
+	}
+
+	// The body is optional.
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	movements, err := app.Models.Stocktake.Finalize(id, input.ZeroUncounted, app.contextGetUserID(r))
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.publishStockMovements(r, movements...)
+
+	stocktake, err := app.Models.Stocktake.Get(id)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"stocktake": stocktake, "stock_movements": movements})
+}
+
+func (app *Application) cancelStocktake(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Stocktake ID")
+		return
+	}
+
+	stocktake, err := app.Models.Stocktake.Cancel(id)
+	if err != nil {
+		app.stocktakeError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, stocktake)
+}
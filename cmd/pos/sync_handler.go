@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/events"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strings"
+)
+
+const maxSyncBatch = 500
+
+// syncOrders applies a batch of orders a station created while offline, in the order they
+// were sent. Every order gets its own result, so one bad order does not fail the batch
+// and the station can safely resend orders whose result it never received.
+func (app *Application) syncOrders(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Orders []model.Order `json:"orders"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Orders) > 0, "orders", "must contain at least one order")
+	v.Check(len(input.Orders) <= maxSyncBatch, "orders", "must not contain more than 500 orders")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	station := app.contextGetStation(r)
+	user := app.contextGetUser(r)
+
+	var shiftId *int
+	shift, err := app.Models.Shift.GetOpen(&station.Id)
+	switch {
+	case err == nil:
+		shiftId = &shift.Id
+	case !errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	results := make([]*model.OrderSyncResult, 0, len(input.Orders))
+	for i := range input.Orders {
+		order := &input.Orders[i]
+
+		v := validator.New()
+		if model.ValidateOfflineOrder(v, order); !v.Valid() {
+			result := &model.OrderSyncResult{Status: model.SyncRejected, Error: validationMessage(v)}
+			if order.ClientUUID != nil {
+				result.ClientUUID = *order.ClientUUID
+			}
+			results = append(results, result)
+			continue
+		}
+
+		if order.EmployeeID == 0 && !user.IsAnonymous() {
+			order.EmployeeID = user.Id
+		}
+		order.ShiftId = shiftId
+		order.StationId = &station.Id
+
+		// As online, a customer group takes a cashier trusted with it.
+		if order.CustomerGroup != "" {
+			permissions, err := app.Models.Permissions.GetAllForUser(order.EmployeeID)
+			if err != nil {
+				app.respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !permissions.Include("pricing:apply") {
+				results = append(results, &model.OrderSyncResult{
+					ClientUUID: *order.ClientUUID,
+					Status:     model.SyncRejected,
+					Error:      "customer_group requires an employee with the pricing:apply permission",
+				})
+				continue
+			}
+		}
+
+		// Lines without a price are priced by the price list that applied when the sale
+		// was made, and the order records that list as for orders made online.
+		order.PriceListId, err = app.Models.PriceList.PriceOrder(order, order.Products, *order.LocalCreatedAt)
+		if err != nil {
+			result := &model.OrderSyncResult{ClientUUID: *order.ClientUUID, Status: model.SyncRejected, Error: err.Error()}
+			if !errors.Is(err, model.ErrUnknownProduct) && !errors.Is(err, model.ErrUnknownUnit) {
+				app.logger.PrintError(err, map[string]string{"client_uuid": *order.ClientUUID})
+				result.Error = "the order could not be priced, retry later"
+			}
+			results = append(results, result)
+			continue
+		}
+		if order.TotalPrice == 0 {
+			order.TotalPrice = calculateTotalPrice(order.Products)
+		}
+
+		result, err := app.Models.Order.ApplyOffline(station.Id, order)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"client_uuid": *order.ClientUUID})
+			results = append(results, &model.OrderSyncResult{
+				ClientUUID: *order.ClientUUID,
+				Status:     model.SyncRejected,
+				Error:      "the order could not be stored, retry later",
+			})
+			continue
+		}
+
+		if result.Status == model.SyncCreated {
+			app.publishEvent(r, events.OrderCreated, order)
+			if order.TotalPrice > 0 && order.TotalPaid >= order.TotalPrice {
+				app.publishEvent(r, events.OrderPaid, order)
+			}
+			app.fireOrderIfPaid(r, order)
+			app.publishStockMovements(r, result.Movements...)
+		}
+		results = append(results, result)
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"results": results})
+}
+
+func (app *Application) getFlaggedProducts(w http.ResponseWriter, r *http.Request) {
+	products, err := app.Models.Product.GetFlagged()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"products": products})
+}
+
+// validationMessage flattens validator errors into a single human readable string.
+func validationMessage(v *validator.Validator) string {
+	messages := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		messages = append(messages, key+" "+message)
+	}
+	return strings.Join(messages, "; ")
+}
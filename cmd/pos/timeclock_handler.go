@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) timeClockErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrAlreadyClockedIn),
+		errors.Is(err, model.ErrNotClockedIn),
+		errors.Is(err, model.ErrBreakInProgress),
+		errors.Is(err, model.ErrNoBreakInProgress):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrBreakOutsideEntry):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Time Entry Not Found")
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (app *Application) clockIn(w http.ResponseWriter, r *http.Request) {
+	entry, err := app.Models.TimeClock.ClockIn(app.contextGetUser(r).Id)
+	if err != nil {
+		app.timeClockErrorResponse(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, entry)
+}
+
+func (app *Application) clockOut(w http.ResponseWriter, r *http.Request) {
+	entry, err := app.Models.TimeClock.ClockOut(app.contextGetUser(r).Id)
+	if err != nil {
+		app.timeClockErrorResponse(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, entry)
+}
+
+func (app *Application) startBreak(w http.ResponseWriter, r *http.Request) {
+	b, err := app.Models.TimeClock.StartBreak(app.contextGetUser(r).Id)
+	if err != nil {
+		app.timeClockErrorResponse(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, b)
+}
+
+func (app *Application) endBreak(w http.ResponseWriter, r *http.Request) {
+	b, err := app.Models.TimeClock.EndBreak(app.contextGetUser(r).Id)
+	if err != nil {
+		app.timeClockErrorResponse(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, b)
+}
+
+func (app *Application) getTimeEntry(w http.ResponseWriter, r *http.Request) {
+	entryId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Time Entry ID")
+		return
+	}
+
+	entry, err := app.Models.TimeClock.Get(entryId)
+	if err != nil {
+		app.timeClockErrorResponse(w, err)
+		return
+	}
+
+	if !app.allowSelfOrPermission(w, r, entry.EmployeeId, "timeclock:write") {
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, entry)
+}
+
+func (app *Application) editTimeEntry(w http.ResponseWriter, r *http.Request) {
+	entryId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Time Entry ID")
+		return
+	}
+
+	var input struct {
+		ClockIn  time.Time  `json:"clock_in"`
+		ClockOut *time.Time `json:"clock_out"`
+		Reason   string     `json:"reason"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(!input.ClockIn.IsZero(), "clock_in", "must be provided")
+	v.Check(input.ClockOut == nil || input.ClockOut.After(input.ClockIn), "clock_out", "must be after clock_in")
+	v.Check(input.Reason != "", "reason", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entry, err := app.Models.TimeClock.Edit(entryId, app.contextGetUser(r).Id, input.ClockIn, input.ClockOut, input.Reason)
+	if err != nil {
+		app.timeClockErrorResponse(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, entry)
+}
+
+func (app *Application) getTimeEntryAudits(w http.ResponseWriter, r *http.Request) {
+	entryId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Time Entry ID")
+		return
+	}
+
+	audits, err := app.Models.TimeClock.GetAudits(entryId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"audits": audits})
+}
+
+// getTimesheet reports an employee's hours for a pay period. The period defaults to the
+// last 14 days and both dates are inclusive. Pass format=csv to download it for payroll.
+func (app *Application) getTimesheet(w http.ResponseWriter, r *http.Request) {
+	employeeId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Employee ID")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	today := time.Now().Truncate(24 * time.Hour)
+	from := app.readDate(qs, "from", today.AddDate(0, 0, -13), v)
+	to := app.readDate(qs, "to", today, v)
+	format := app.readString(qs, "format", "json")
+
+	v.Check(!to.Before(from), "to", "must not be before from")
+	v.Check(validator.In(format, "json", "csv"), "format", "must be json or csv")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sheet, err := app.Models.TimeClock.Timesheet(employeeId, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if format == "json" {
+		app.respondWithJSON(w, http.StatusOK, envelope{"timesheet": sheet})
+		return
+	}
+
+	filename := fmt.Sprintf("timesheet-%d-%s-%s.csv", employeeId, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"employee_id", "entry_id", "date", "clock_in", "clock_out", "break_minutes", "worked_hours"})
+	for _, e := range sheet.Entries {
+		clockOut := ""
+		if e.ClockOut != nil {
+			clockOut = e.ClockOut.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			strconv.Itoa(employeeId),
+			strconv.Itoa(e.Id),
+			e.ClockIn.Format(time.DateOnly),
+			e.ClockIn.Format(time.RFC3339),
+			clockOut,
+			strconv.FormatFloat(e.BreakDuration().Minutes(), 'f', 0, 64),
+			strconv.FormatFloat(e.WorkedDuration().Hours(), 'f', 2, 64),
+		})
+	}
+	cw.Write([]string{strconv.Itoa(employeeId), "", "total", "", "", "", strconv.FormatFloat(sheet.TotalHours, 'f', 2, 64)})
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
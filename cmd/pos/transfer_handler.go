@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// transferError responds to the errors the transfer endpoints have in common.
+func (app *Application) transferError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Transfer Not Found")
+	case errors.Is(err, model.ErrUnknownStore), errors.Is(err, model.ErrUnknownProduct),
+		errors.Is(err, model.ErrTransferSameStore), errors.Is(err, model.ErrUnknownTransferLine),
+		errors.Is(err, model.ErrUnexplainedDiscrepancy):
+		app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, model.ErrTransferNotDraft), errors.Is(err, model.ErrTransferNotInTransit),
+		errors.Is(err, model.ErrTransferClosed):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (app *Application) createTransfer(w http.ResponseWriter, r *http.Request) {
+	var transfer model.Transfer
+
+	err := json.NewDecoder(r.Body).Decode(&transfer)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	if transfer.FromStoreId == 0 {
+		transfer.FromStoreId = app.contextGetStoreID(r)
+	}
+
+	v := validator.New()
+	if model.ValidateTransfer(v, &transfer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfer.EmployeeId = app.contextGetUserID(r)
+	err = app.Models.Transfer.Create(&transfer)
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, transfer)
+}
+
+func (app *Application) getTransfers(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	storeId := app.readInt(qs, "store_id", 0, v)
+	status := app.readString(qs, "status", "")
+	filters := model.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: []string{"-id"},
+	}
+
+	v.Check(status == "" || validator.In(status, model.TransferDraft, model.TransferInTransit,
+		model.TransferReceived, model.TransferCancelled), "status", "invalid transfer status")
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfers, metadata, err := app.Models.Transfer.GetAll(storeId, status, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transfers": transfers, "metadata": metadata})
+}
+
+func (app *Application) getTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+		return
+	}
+
+	transfer, err := app.Models.Transfer.Get(id)
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, transfer)
+}
+
+func (app *Application) updateTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+		return
+	}
+
+	var transfer model.Transfer
+	err = json.NewDecoder(r.Body).Decode(&transfer)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateTransfer(v, &transfer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Transfer.Update(id, &transfer)
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, transfer)
+}
+
+func (app *Application) deleteTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+		return
+	}
+
+	err = app.Models.Transfer.Delete(id)
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// shipTransfer takes the goods of a transfer out of the source store.
+func (app *Application) shipTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+		return
+	}
+
+	transfer, movements, err := app.Models.Transfer.Ship(id, app.contextGetUserID(r))
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.publishStockMovements(r, movements...)
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transfer": transfer, "stock_movements": movements})
+}
+
+// receiveTransfer books what arrived into the destination store.
+func (app *Application) receiveTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+		return
+	}
+
+	var input struct {
+		Lines []model.TransferReceipt `json:"lines"`
+	}
+
+	// The body is optional; without it everything arrived as shipped.
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateTransferReceipts(v, input.Lines); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfer, movements, err := app.Models.Transfer.Receive(id, input.Lines, app.contextGetUserID(r))
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.publishStockMovements(r, movements...)
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transfer": transfer, "stock_movements": movements})
+}
+
+// cancelTransfer abandons a transfer, returning shipped goods to the source store.
+func (app *Application) cancelTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+		return
+	}
+
+	transfer, movements, err := app.Models.Transfer.Cancel(id, app.contextGetUserID(r))
+	if err != nil {
+		app.transferError(w, err)
+		return
+	}
+
+	app.publishStockMovements(r, movements...)
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transfer": transfer, "stock_movements": movements})
+}
+
+// getStockInTransit reports what has been shipped between stores and not yet received.
+func (app *Application) getStockInTransit(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	storeId := app.readInt(r.URL.Query(), "store_id", 0, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stock, err := app.Models.Transfer.InTransit(storeId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"in_transit": stock})
+}
+
+// getTransferHistory reports the transfers shipped in a date range with their
+// discrepancies.
+func (app *Application) getTransferHistory(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	today := time.Now().Truncate(24 * time.Hour)
+	storeId := app.readInt(qs, "store_id", 0, v)
+	from := app.readDate(qs, "from", today.AddDate(0, 0, -29), v)
+	to := app.readDate(qs, "to", today, v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	history, err := app.Models.Transfer.History(storeId, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transfers": history})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Events are pushed to the client as text messages. Messages the client sends are read
+// and discarded; gorilla/websocket reassembles fragmented ones and answers pings.
+
+const (
+	wsMaxMessageSize = 1 << 20
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// Clients authenticate with the Authorization header rather than cookies, so a page on
+// another origin gains nothing it could not do with a plain request.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the connection. When
+// it fails the error response has already been sent.
+func (app *Application) upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	upgrader := wsUpgrader
+	upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		app.respondWithError(w, status, reason.Error())
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(wsMaxMessageSize)
+
+	return &wsConn{conn: conn}, nil
+}
+
+func (c *wsConn) writeMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// readLoop answers pings and returns once the client closes the connection, sends
+// something invalid, or stays silent for longer than idle.
+func (c *wsConn) readLoop(idle time.Duration) {
+	c.conn.SetPingHandler(func(data string) error {
+		c.conn.SetReadDeadline(time.Now().Add(idle))
+		err := c.writeMessage(websocket.PongMessage, []byte(data))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(idle))
+	})
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idle))
+		if _, _, err := c.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// closeMessage tells the client the server is going away.
+func (c *wsConn) closeMessage() error {
+	return c.writeMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// startWorkers launches the application's periodic background jobs.
+func (app *Application) startWorkers() {
+	app.runPeriodic("idempotency-cleanup", time.Hour, func() error {
+		n, err := app.Models.Idempotency.DeleteExpired()
+		if err == nil && n > 0 {
+			app.logger.PrintInfo("deleted expired idempotency keys", map[string]string{"count": strconv.FormatInt(n, 10)})
+		}
+		return err
+	})
+
+	app.runTriggered("low-stock-alerts", app.Config.Alerts.LowStockInterval, app.lowStock, app.alertLowStock)
+
+	app.runPeriodic("scheduled-prices", app.Config.Prices.ScheduleInterval, app.applyScheduledPrices)
+}
+
+// runPeriodic calls fn every interval in a background goroutine until the server shuts
+// down. Errors and panics are logged and do not stop the worker.
+func (app *Application) runPeriodic(name string, interval time.Duration, fn func() error) {
+	app.runTriggered(name, interval, nil, fn)
+}
+
+// runTriggered is runPeriodic that also runs fn whenever something is sent on trigger.
+func (app *Application) runTriggered(name string, interval time.Duration, trigger <-chan struct{}, fn func() error) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				app.runJob(name, fn)
+			case <-trigger:
+				app.runJob(name, fn)
+			}
+		}
+	}()
+}
+
+func (app *Application) runJob(name string, fn func() error) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"worker": name})
+		}
+	}()
+
+	if err := fn(); err != nil {
+		app.logger.PrintError(err, map[string]string{"worker": name})
+	}
+}
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Domain event types published on the bus.
+const (
+	OrderCreated = "order.created"
+	OrderUpdated = "order.updated"
+	OrderPaid    = "order.paid"
+	OrderVoided  = "order.voided"
+	StockChanged = "stock.changed"
+	StockLow     = "stock.low"
+	ShiftOpened  = "shift.opened"
+	ShiftClosed  = "shift.closed"
+
+	KitchenTicketCreated = "kitchen.ticket.created"
+	KitchenTicketUpdated = "kitchen.ticket.updated"
+)
+
+// Permissions maps each event type to the permission a subscriber needs to receive it.
+var Permissions = map[string]string{
+	OrderCreated: "orders:read",
+	OrderUpdated: "orders:read",
+	OrderPaid:    "orders:read",
+	OrderVoided:  "orders:read",
+	StockChanged: "inventory:read",
+	StockLow:     "inventory:read",
+	ShiftOpened:  "shifts:read",
+	ShiftClosed:  "shifts:read",
+
+	KitchenTicketCreated: "kitchen:read",
+	KitchenTicketUpdated: "kitchen:read",
+}
+
+// Event is a single domain event. IDs increase monotonically and are what clients send
+// back as the last event ID when they reconnect.
+type Event struct {
+	ID        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	StoreId   *int        `json:"store_id,omitempty"`
+	StationId *int        `json:"station_id,omitempty"`
+	Data      interface{} `json:"data"`
+	Time      time.Time   `json:"time"`
+}
+
+// Subscription receives events published after it was created. The channel is closed
+// when the subscription is cancelled or when the subscriber falls too far behind; the
+// client is then expected to reconnect with its last event ID.
+type Subscription struct {
+	C <-chan Event
+	// StartID is the ID of the last event published before the subscription started.
+	StartID uint64
+	c       chan Event
+	bus     *Bus
+}
+
+// Cancel stops the subscription. It is safe to call more than once.
+func (s *Subscription) Cancel() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.remove(s)
+}
+
+// Bus is an in-memory publish/subscribe hub that keeps the most recent events in a ring
+// buffer so that reconnecting clients can catch up on what they missed.
+type Bus struct {
+	mu      sync.Mutex
+	firstID uint64
+	nextID  uint64
+	ring    []Event
+	head    int
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+// NewBus returns a bus that remembers the last capacity events. IDs are seeded from the
+// clock so that IDs handed out before a restart are never reused afterwards.
+func NewBus(capacity int) *Bus {
+	seed := uint64(time.Now().UnixMicro())
+	return &Bus{
+		firstID: seed,
+		nextID:  seed,
+		ring:    make([]Event, capacity),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and delivers it to every subscriber.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.ring[(b.head+b.size)%len(b.ring)] = e
+	if b.size < len(b.ring) {
+		b.size++
+	} else {
+		b.head = (b.head + 1) % len(b.ring)
+	}
+
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+			// A subscriber that cannot keep up is dropped rather than blocking publishers.
+			b.remove(s)
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. When lastID is non-zero the buffered events after
+// it are returned as backlog. complete is false if some of those events are no longer
+// buffered or lastID is unknown; no backlog is returned then and the client should reload
+// its state and carry on from sub.StartID.
+func (b *Bus) Subscribe(lastID uint64) (sub *Subscription, backlog []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Event, 64)
+	sub = &Subscription{C: c, StartID: b.nextID, c: c, bus: b}
+	b.subs[sub] = struct{}{}
+
+	if lastID == 0 {
+		return sub, nil, true
+	}
+
+	if lastID < b.firstID || lastID > b.nextID || (b.size > 0 && lastID < b.ring[b.head].ID-1) {
+		return sub, nil, false
+	}
+
+	for i := 0; i < b.size; i++ {
+		e := b.ring[(b.head+i)%len(b.ring)]
+		if e.ID > lastID {
+			backlog = append(backlog, e)
+		}
+	}
+
+	return sub, backlog, true
+}
+
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
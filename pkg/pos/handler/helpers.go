@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type envelope map[string]interface{}
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, err := json.Marshal(payload)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, map[string]string{"error": message})
+}
+
+func failedValidationResponse(w http.ResponseWriter, errors map[string]string) {
+	respondWithJSON(w, http.StatusUnprocessableEntity, envelope{"error": errors})
+}
@@ -1 +1,183 @@
 package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// StationHandler serves the register (station) registry.
+type StationHandler struct {
+	Models model.Models
+}
+
+func NewStationHandler(models model.Models) *StationHandler {
+	return &StationHandler{Models: models}
+}
+
+func (h *StationHandler) CreateStation(w http.ResponseWriter, r *http.Request) {
+	station := model.Station{Status: model.StationActive}
+
+	err := json.NewDecoder(r.Body).Decode(&station)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStation(v, &station); !v.Valid() {
+		failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	err = h.Models.Station.Create(&station)
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownStore) {
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, station)
+}
+
+func (h *StationHandler) GetStation(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	station, err := h.Models.Station.Get(stationId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, station)
+}
+
+func (h *StationHandler) GetAllStations(w http.ResponseWriter, r *http.Request) {
+	stations, err := h.Models.Station.GetAll()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, envelope{"stations": stations})
+}
+
+func (h *StationHandler) UpdateStation(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	var station model.Station
+	err = json.NewDecoder(r.Body).Decode(&station)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStation(v, &station); !v.Valid() {
+		failedValidationResponse(w, v.Errors)
+		return
+	}
+
+	err = h.Models.Station.Update(stationId, &station)
+	if err != nil {
+		if errors.Is(err, model.ErrUnknownStore) {
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if errors.Is(err, model.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, station)
+}
+
+func (h *StationHandler) DeleteStation(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	err = h.Models.Station.Delete(stationId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// RegisterStation pairs a device with the station and returns its credential. The device
+// sends it in the X-Station-Token header; registering again revokes the old credential.
+func (h *StationHandler) RegisterStation(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	credential, station, err := h.Models.Station.Register(stationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, "Not Found")
+		case errors.Is(err, model.ErrStationNotActive):
+			respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, envelope{"station": station, "station_token": credential})
+}
+
+// IssueDisplayCredential returns a new credential for the station's customer-facing
+// display. It is sent in the X-Display-Token header and only opens the display feed;
+// issuing a new one revokes the old one.
+func (h *StationHandler) IssueDisplayCredential(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	credential, station, err := h.Models.Station.IssueDisplayCredential(stationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, "Not Found")
+		case errors.Is(err, model.ErrStationNotActive):
+			respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, envelope{"station": station, "display_token": credential})
+}
@@ -0,0 +1,135 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"net/http"
+
+	// Register the decoders of the other supported formats.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// MaxPixels bounds the size of the images accepted, so that a small file cannot decode to
+// a huge bitmap.
+const MaxPixels = 40_000_000
+
+var (
+	// ErrUnsupportedType is returned for content that is not a JPEG, PNG or GIF image.
+	ErrUnsupportedType = errors.New("unsupported image type")
+	// ErrTooManyPixels is returned for images larger than MaxPixels.
+	ErrTooManyPixels = errors.New("image has too many pixels")
+)
+
+// ContentTypes maps the supported content types to the file extension they are stored with.
+var ContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Thumbnail is a fixed size every uploaded image is scaled down to: its longer side fits
+// in Size pixels.
+type Thumbnail struct {
+	Name string
+	Size int
+}
+
+// Thumbnails are the sizes made of every uploaded image, smallest first.
+var Thumbnails = []Thumbnail{
+	{Name: "small", Size: 128},
+	{Name: "medium", Size: 320},
+	{Name: "large", Size: 640},
+}
+
+// DetectContentType returns the content type of data judged by its contents, or
+// ErrUnsupportedType when it is not a supported image.
+func DetectContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := ContentTypes[contentType]; !ok {
+		return "", ErrUnsupportedType
+	}
+	return contentType, nil
+}
+
+// Decode decodes a supported image, checking its dimensions before decoding it.
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	return img, nil
+}
+
+// Flatten draws img onto a white background, which is what transparent areas become in
+// thumbnails.
+func Flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// Scale returns src scaled down so that its longer side fits in size pixels, averaging
+// the source pixels each destination pixel covers. Images that already fit are returned
+// as they are.
+func Scale(src *image.RGBA, size int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, size
+	if sw > sh {
+		dh = max(1, sh*size/sw)
+	} else {
+		dw = max(1, sw*size/sh)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// EncodeJPEG writes img as a JPEG of good quality.
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS Cash_Movements CASCADE;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Shift_Id;
+DROP TABLE IF EXISTS Shifts CASCADE;
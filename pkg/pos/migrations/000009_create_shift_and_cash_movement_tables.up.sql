@@ -0,0 +1,26 @@
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Products JSONB;
+
+CREATE TABLE IF NOT EXISTS Shifts (
+    Id SERIAL PRIMARY KEY,
+    Employee_Id INT NOT NULL REFERENCES Employee(Id),
+    Opening_Cash FLOAT NOT NULL DEFAULT 0,
+    Counted_Cash FLOAT,
+    Opened_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Closed_At TIMESTAMP
+);
+
+-- Only one drawer shift may be open at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS shifts_single_open_idx ON Shifts ((Closed_At IS NULL)) WHERE Closed_At IS NULL;
+
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Shift_Id INT REFERENCES Shifts(Id);
+
+CREATE TABLE IF NOT EXISTS Cash_Movements (
+    Id SERIAL PRIMARY KEY,
+    Shift_Id INT NOT NULL REFERENCES Shifts(Id) ON DELETE CASCADE,
+    Employee_Id INT NOT NULL REFERENCES Employee(Id),
+    Type VARCHAR(16) NOT NULL,
+    Reason_Code VARCHAR(64) NOT NULL,
+    Amount FLOAT NOT NULL,
+    Note TEXT NOT NULL DEFAULT '',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'timeclock:write';
+DROP TABLE IF EXISTS Time_Entry_Audits CASCADE;
+DROP TABLE IF EXISTS Time_Breaks CASCADE;
+DROP TABLE IF EXISTS Time_Entries CASCADE;
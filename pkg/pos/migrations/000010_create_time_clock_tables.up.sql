@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS Time_Entries (
+    Id SERIAL PRIMARY KEY,
+    Employee_Id INT NOT NULL REFERENCES Employee(Id) ON DELETE CASCADE,
+    Clock_In TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Clock_Out TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- An employee may only be clocked in once at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS time_entries_single_open_idx ON Time_Entries (Employee_Id) WHERE Clock_Out IS NULL;
+
+CREATE TABLE IF NOT EXISTS Time_Breaks (
+    Id SERIAL PRIMARY KEY,
+    Time_Entry_Id INT NOT NULL REFERENCES Time_Entries(Id) ON DELETE CASCADE,
+    Started_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Ended_At TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS time_breaks_single_open_idx ON Time_Breaks (Time_Entry_Id) WHERE Ended_At IS NULL;
+
+CREATE TABLE IF NOT EXISTS Time_Entry_Audits (
+    Id SERIAL PRIMARY KEY,
+    Time_Entry_Id INT NOT NULL REFERENCES Time_Entries(Id) ON DELETE CASCADE,
+    Editor_Id INT NOT NULL REFERENCES Employee(Id),
+    Field VARCHAR(32) NOT NULL,
+    Old_Value TEXT NOT NULL,
+    New_Value TEXT NOT NULL,
+    Reason TEXT NOT NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO permissions (code)
+VALUES ('timeclock:write');
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'commissions:write';
+DROP TABLE IF EXISTS Commission_Entries CASCADE;
+DROP TABLE IF EXISTS Commission_Rules CASCADE;
+DROP TABLE IF EXISTS Refunds CASCADE;
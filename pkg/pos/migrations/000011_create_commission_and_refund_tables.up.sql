@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS Refunds (
+    Id SERIAL PRIMARY KEY,
+    Order_Id INT NOT NULL REFERENCES Orders(Id) ON DELETE CASCADE,
+    Employee_Id INT NOT NULL REFERENCES Employee(Id),
+    Shift_Id INT REFERENCES Shifts(Id),
+    Amount FLOAT NOT NULL,
+    Products JSONB NOT NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Commission_Rules (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Type VARCHAR(16) NOT NULL,
+    Category_Id INT REFERENCES Categories(Id) ON DELETE CASCADE,
+    Product_Id INT REFERENCES Products(Id) ON DELETE CASCADE,
+    Rate FLOAT NOT NULL DEFAULT 0,
+    Amount FLOAT NOT NULL DEFAULT 0,
+    Tiers JSONB NOT NULL DEFAULT '[]',
+    Active BOOLEAN NOT NULL DEFAULT TRUE,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Entries outlive the orders they were earned on, so order_id is not a foreign key.
+CREATE TABLE IF NOT EXISTS Commission_Entries (
+    Id SERIAL PRIMARY KEY,
+    Order_Id INT NOT NULL,
+    Employee_Id INT NOT NULL REFERENCES Employee(Id) ON DELETE CASCADE,
+    Product_Id VARCHAR(255) NOT NULL,
+    Rule_Id INT REFERENCES Commission_Rules(Id) ON DELETE SET NULL,
+    Qty INT NOT NULL,
+    Sale_Amount FLOAT NOT NULL,
+    Commission FLOAT NOT NULL,
+    Is_Reversal BOOLEAN NOT NULL DEFAULT FALSE,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS commission_entries_employee_idx ON Commission_Entries (Employee_Id, Created_At);
+CREATE INDEX IF NOT EXISTS commission_entries_order_idx ON Commission_Entries (Order_Id);
+
+INSERT INTO permissions (code)
+VALUES ('commissions:write');
@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'stations:write';
+DROP INDEX IF EXISTS shifts_single_open_idx;
+ALTER TABLE Refunds DROP COLUMN IF EXISTS Station_Id;
+ALTER TABLE Shifts DROP COLUMN IF EXISTS Station_Id;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Station_Id;
+DROP TABLE IF EXISTS Stations CASCADE;
+CREATE UNIQUE INDEX IF NOT EXISTS shifts_single_open_idx ON Shifts ((Closed_At IS NULL)) WHERE Closed_At IS NULL;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS Stations (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Store VARCHAR(255) NOT NULL DEFAULT '',
+    Printer_Config JSONB NOT NULL DEFAULT '{}',
+    Receipt_Prefix VARCHAR(16) NOT NULL DEFAULT '',
+    Receipt_Seq INT NOT NULL DEFAULT 0,
+    Status VARCHAR(16) NOT NULL DEFAULT 'active',
+    Credential_Hash BYTEA UNIQUE,
+    Registered_At TIMESTAMP,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Station_Id INT REFERENCES Stations(Id) ON DELETE SET NULL;
+ALTER TABLE Shifts ADD COLUMN IF NOT EXISTS Station_Id INT REFERENCES Stations(Id) ON DELETE SET NULL;
+ALTER TABLE Refunds ADD COLUMN IF NOT EXISTS Station_Id INT REFERENCES Stations(Id) ON DELETE SET NULL;
+
+-- Each register has its own drawer, so allow one open shift per station.
+DROP INDEX IF EXISTS shifts_single_open_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shifts_single_open_idx ON Shifts (COALESCE(Station_Id, 0)) WHERE Closed_At IS NULL;
+
+INSERT INTO permissions (code)
+VALUES ('stations:write');
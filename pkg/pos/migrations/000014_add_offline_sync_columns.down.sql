@@ -0,0 +1,4 @@
+ALTER TABLE Products DROP COLUMN IF EXISTS Stock_Flagged_At;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Synced_At;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Local_Created_At;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Client_UUID;
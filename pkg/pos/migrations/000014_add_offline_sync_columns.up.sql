@@ -0,0 +1,6 @@
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Client_UUID UUID UNIQUE;
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Local_Created_At TIMESTAMP;
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Synced_At TIMESTAMP;
+
+-- Set when offline sales drive a product's stock below zero so it can be recounted.
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Stock_Flagged_At TIMESTAMP;
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS categories_tombstone ON Categories;
+DROP TRIGGER IF EXISTS products_tombstone ON Products;
+DROP TRIGGER IF EXISTS categories_sync_version ON Categories;
+DROP TRIGGER IF EXISTS products_sync_version ON Products;
+DROP FUNCTION IF EXISTS catalog_record_tombstone();
+DROP FUNCTION IF EXISTS catalog_bump_sync_version();
+DROP TABLE IF EXISTS Catalog_Tombstones CASCADE;
+ALTER TABLE Categories DROP COLUMN IF EXISTS Sync_Version;
+ALTER TABLE Products DROP COLUMN IF EXISTS Sync_Version;
+DROP SEQUENCE IF EXISTS catalog_sync_seq;
@@ -0,0 +1,52 @@
+-- Every catalog write takes the next value of this sequence, so registers can ask for
+-- everything that changed after the last version they have seen.
+CREATE SEQUENCE IF NOT EXISTS catalog_sync_seq;
+
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Sync_Version BIGINT NOT NULL DEFAULT nextval('catalog_sync_seq');
+ALTER TABLE Categories ADD COLUMN IF NOT EXISTS Sync_Version BIGINT NOT NULL DEFAULT nextval('catalog_sync_seq');
+
+CREATE INDEX IF NOT EXISTS products_sync_version_idx ON Products (Sync_Version);
+CREATE INDEX IF NOT EXISTS categories_sync_version_idx ON Categories (Sync_Version);
+
+CREATE TABLE IF NOT EXISTS Catalog_Tombstones (
+    Entity VARCHAR(32) NOT NULL,
+    Entity_Id INT NOT NULL,
+    Sync_Version BIGINT NOT NULL DEFAULT nextval('catalog_sync_seq'),
+    Deleted_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (Entity, Entity_Id)
+);
+
+CREATE INDEX IF NOT EXISTS catalog_tombstones_sync_version_idx ON Catalog_Tombstones (Sync_Version);
+
+CREATE OR REPLACE FUNCTION catalog_bump_sync_version() RETURNS trigger AS $$
+BEGIN
+    NEW.sync_version := nextval('catalog_sync_seq');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION catalog_record_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO catalog_tombstones (entity, entity_id)
+    VALUES (TG_ARGV[0], OLD.id)
+    ON CONFLICT (entity, entity_id) DO UPDATE
+    SET sync_version = nextval('catalog_sync_seq'), deleted_at = CURRENT_TIMESTAMP;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_sync_version ON Products;
+CREATE TRIGGER products_sync_version BEFORE INSERT OR UPDATE ON Products
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_sync_version();
+
+DROP TRIGGER IF EXISTS categories_sync_version ON Categories;
+CREATE TRIGGER categories_sync_version BEFORE INSERT OR UPDATE ON Categories
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_sync_version();
+
+DROP TRIGGER IF EXISTS products_tombstone ON Products;
+CREATE TRIGGER products_tombstone AFTER DELETE ON Products
+    FOR EACH ROW EXECUTE FUNCTION catalog_record_tombstone('product');
+
+DROP TRIGGER IF EXISTS categories_tombstone ON Categories;
+CREATE TRIGGER categories_tombstone AFTER DELETE ON Categories
+    FOR EACH ROW EXECUTE FUNCTION catalog_record_tombstone('category');
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code IN ('orders:read', 'inventory:read', 'shifts:read');
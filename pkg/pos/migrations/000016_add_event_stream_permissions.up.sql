@@ -0,0 +1,4 @@
+INSERT INTO permissions (code)
+VALUES ('orders:read'),
+       ('inventory:read'),
+       ('shifts:read');
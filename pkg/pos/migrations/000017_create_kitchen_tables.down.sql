@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('kitchen:read', 'kitchen:write');
+DROP TABLE IF EXISTS Kitchen_Tickets;
+DROP TABLE IF EXISTS Prep_Station_Categories;
+DROP TABLE IF EXISTS Prep_Stations;
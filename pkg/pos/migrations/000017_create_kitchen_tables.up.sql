@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS Prep_Stations (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every category is prepared at no more than one prep station.
+CREATE TABLE IF NOT EXISTS Prep_Station_Categories (
+    Category_Id INT PRIMARY KEY REFERENCES Categories(Id) ON DELETE CASCADE,
+    Prep_Station_Id INT NOT NULL REFERENCES Prep_Stations(Id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS Kitchen_Tickets (
+    Id SERIAL PRIMARY KEY,
+    Order_Id INT NOT NULL REFERENCES Orders(Id) ON DELETE CASCADE,
+    Prep_Station_Id INT NOT NULL REFERENCES Prep_Stations(Id) ON DELETE CASCADE,
+    Status VARCHAR(16) NOT NULL DEFAULT 'new',
+    Items JSONB NOT NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Started_At TIMESTAMP,
+    Ready_At TIMESTAMP,
+    Served_At TIMESTAMP,
+    Recalled_At TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS kitchen_tickets_station_status_idx ON Kitchen_Tickets (Prep_Station_Id, Status);
+CREATE INDEX IF NOT EXISTS kitchen_tickets_order_idx ON Kitchen_Tickets (Order_Id);
+
+INSERT INTO permissions (code)
+VALUES ('kitchen:read'),
+       ('kitchen:write');
@@ -0,0 +1,2 @@
+ALTER TABLE Stations DROP COLUMN IF EXISTS Display_Content;
+ALTER TABLE Stations DROP COLUMN IF EXISTS Display_Credential_Hash;
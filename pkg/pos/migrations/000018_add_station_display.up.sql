@@ -0,0 +1,2 @@
+ALTER TABLE Stations ADD COLUMN IF NOT EXISTS Display_Credential_Hash BYTEA UNIQUE;
+ALTER TABLE Stations ADD COLUMN IF NOT EXISTS Display_Content JSONB NOT NULL DEFAULT '{}';
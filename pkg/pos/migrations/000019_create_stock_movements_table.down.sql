@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'inventory:write';
+ALTER TABLE Products ALTER COLUMN Amount DROP NOT NULL;
+ALTER TABLE Products ALTER COLUMN Amount DROP DEFAULT;
+DROP TABLE IF EXISTS Stock_Movements;
+DROP FUNCTION IF EXISTS stock_movements_immutable();
@@ -0,0 +1,40 @@
+-- The ledger outlives the products it describes, so product_id is not a foreign key.
+CREATE TABLE IF NOT EXISTS Stock_Movements (
+    Id BIGSERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL,
+    Type VARCHAR(16) NOT NULL,
+    Qty_Delta INT NOT NULL,
+    Balance_After INT NOT NULL,
+    Reason TEXT NOT NULL DEFAULT '',
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Source_Type VARCHAR(32) NOT NULL DEFAULT '',
+    Source_Id VARCHAR(64) NOT NULL DEFAULT '',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_product_idx ON Stock_Movements (Product_Id, Id);
+CREATE INDEX IF NOT EXISTS stock_movements_source_idx ON Stock_Movements (Source_Type, Source_Id);
+
+-- Movements are immutable; mistakes are corrected with a new movement.
+CREATE OR REPLACE FUNCTION stock_movements_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'stock movements cannot be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stock_movements_immutable
+    BEFORE UPDATE OR DELETE ON Stock_Movements
+    FOR EACH ROW EXECUTE FUNCTION stock_movements_immutable();
+
+-- Open the ledger with the stock every product has today.
+INSERT INTO Stock_Movements (Product_Id, Type, Qty_Delta, Balance_After, Reason)
+SELECT Id, 'adjustment', Amount, Amount, 'opening balance'
+FROM Products
+WHERE COALESCE(Amount, 0) <> 0;
+
+UPDATE Products SET Amount = 0 WHERE Amount IS NULL;
+ALTER TABLE Products ALTER COLUMN Amount SET DEFAULT 0;
+ALTER TABLE Products ALTER COLUMN Amount SET NOT NULL;
+
+INSERT INTO permissions (code)
+VALUES ('inventory:write');
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS products_low_stock_idx;
+ALTER TABLE Products DROP COLUMN IF EXISTS Low_Stock_Alerted_At;
+ALTER TABLE Products DROP COLUMN IF EXISTS Reorder_Qty;
+ALTER TABLE Products DROP COLUMN IF EXISTS Reorder_Point;
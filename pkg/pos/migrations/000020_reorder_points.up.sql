@@ -0,0 +1,8 @@
+-- Reorder_Point is the amount at or below which a product is low on stock; NULL disables
+-- the alert. Low_Stock_Alerted_At is set once an alert went out and cleared when the
+-- amount recovers, so each dip below the threshold alerts only once.
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Reorder_Point INT CHECK (Reorder_Point >= 0);
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Reorder_Qty INT CHECK (Reorder_Qty > 0);
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Low_Stock_Alerted_At TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS products_low_stock_idx ON Products (Id) WHERE Reorder_Point IS NOT NULL;
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('purchasing:read', 'purchasing:write');
+DROP TABLE IF EXISTS Purchase_Order_Lines;
+DROP TABLE IF EXISTS Purchase_Orders;
+DROP TABLE IF EXISTS Suppliers;
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS Suppliers (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Contact_Name VARCHAR(255) NOT NULL DEFAULT '',
+    Email VARCHAR(255) NOT NULL DEFAULT '',
+    Phone VARCHAR(64) NOT NULL DEFAULT '',
+    Address TEXT NOT NULL DEFAULT '',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Purchase_Orders (
+    Id SERIAL PRIMARY KEY,
+    Supplier_Id INT NOT NULL REFERENCES Suppliers(Id),
+    Status VARCHAR(24) NOT NULL DEFAULT 'draft',
+    Notes TEXT NOT NULL DEFAULT '',
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Sent_At TIMESTAMP,
+    Received_At TIMESTAMP,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS purchase_orders_supplier_idx ON Purchase_Orders (Supplier_Id, Id);
+CREATE INDEX IF NOT EXISTS purchase_orders_status_idx ON Purchase_Orders (Status);
+
+-- Like the stock ledger, purchase orders outlive the products they list, so product_id
+-- is not a foreign key.
+CREATE TABLE IF NOT EXISTS Purchase_Order_Lines (
+    Id SERIAL PRIMARY KEY,
+    Purchase_Order_Id INT NOT NULL REFERENCES Purchase_Orders(Id) ON DELETE CASCADE,
+    Product_Id INT NOT NULL,
+    Supplier_Sku VARCHAR(64) NOT NULL DEFAULT '',
+    Unit_Cost INT NOT NULL CHECK (Unit_Cost >= 0),
+    Qty_Ordered INT NOT NULL CHECK (Qty_Ordered > 0),
+    Qty_Received INT NOT NULL DEFAULT 0 CHECK (Qty_Received >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS purchase_order_lines_order_idx ON Purchase_Order_Lines (Purchase_Order_Id, Id);
+
+INSERT INTO permissions (code)
+VALUES ('purchasing:read'),
+       ('purchasing:write');
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS Stocktake_Counts;
+DROP TABLE IF EXISTS Stocktake_Lines;
+DROP TABLE IF EXISTS Stocktakes;
@@ -0,0 +1,38 @@
+-- A stocktake counts every product, or only those of Category_Id. The expected quantity
+-- of each product is frozen in Stocktake_Lines when the session starts.
+CREATE TABLE IF NOT EXISTS Stocktakes (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Category_Id INT REFERENCES Categories(Id) ON DELETE SET NULL,
+    Status VARCHAR(16) NOT NULL DEFAULT 'open',
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Finalized_At TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Stocktake_Lines (
+    Stocktake_Id INT NOT NULL REFERENCES Stocktakes(Id) ON DELETE CASCADE,
+    Product_Id INT NOT NULL,
+    Name VARCHAR(255) NOT NULL DEFAULT '',
+    Unit_Value INT NOT NULL DEFAULT 0,
+    Expected_Qty INT NOT NULL,
+    Counted_Qty INT,
+    Recounts INT NOT NULL DEFAULT 0,
+    Counted_At TIMESTAMP,
+    PRIMARY KEY (Stocktake_Id, Product_Id)
+);
+
+-- Every count entered, from whichever device, for auditing.
+CREATE TABLE IF NOT EXISTS Stocktake_Counts (
+    Id BIGSERIAL PRIMARY KEY,
+    Stocktake_Id INT NOT NULL REFERENCES Stocktakes(Id) ON DELETE CASCADE,
+    Product_Id INT NOT NULL,
+    Qty INT NOT NULL,
+    Recount BOOLEAN NOT NULL DEFAULT FALSE,
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Station_Id INT REFERENCES Stations(Id) ON DELETE SET NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS stocktake_counts_stocktake_idx ON Stocktake_Counts (Stocktake_Id, Product_Id);
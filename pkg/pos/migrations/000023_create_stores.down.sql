@@ -0,0 +1,14 @@
+DELETE FROM permissions WHERE code = 'stores:write';
+
+ALTER TABLE Purchase_Orders DROP COLUMN IF EXISTS Store_Id;
+ALTER TABLE Stocktakes DROP COLUMN IF EXISTS Store_Id;
+ALTER TABLE Stock_Movements DROP COLUMN IF EXISTS Store_Id;
+DROP TABLE IF EXISTS Product_Stock;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Store_Id;
+
+ALTER TABLE Stations ADD COLUMN IF NOT EXISTS Store VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE Stations SET Store = (SELECT Name FROM Stores WHERE Stores.Id = Stations.Store_Id AND NOT Stores.Is_Default)
+WHERE EXISTS (SELECT 1 FROM Stores WHERE Stores.Id = Stations.Store_Id AND NOT Stores.Is_Default);
+ALTER TABLE Stations DROP COLUMN IF EXISTS Store_Id;
+
+DROP TABLE IF EXISTS Stores;
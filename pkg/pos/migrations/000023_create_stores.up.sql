@@ -0,0 +1,67 @@
+CREATE TABLE IF NOT EXISTS Stores (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Address TEXT NOT NULL DEFAULT '',
+    Is_Default BOOLEAN NOT NULL DEFAULT FALSE,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Anything recorded without a store belongs to the default store.
+CREATE UNIQUE INDEX IF NOT EXISTS stores_single_default_idx ON Stores (Is_Default) WHERE Is_Default;
+
+INSERT INTO Stores (Name, Is_Default) VALUES ('Main store', TRUE);
+
+-- Stations named their store in free text; turn every distinct name into a store.
+INSERT INTO Stores (Name)
+SELECT DISTINCT Store FROM Stations WHERE Store <> '';
+
+ALTER TABLE Stations ADD COLUMN IF NOT EXISTS Store_Id INT REFERENCES Stores(Id);
+UPDATE Stations
+SET Store_Id = COALESCE(
+    (SELECT Id FROM Stores WHERE Stores.Name = Stations.Store AND NOT Stores.Is_Default ORDER BY Id LIMIT 1),
+    (SELECT Id FROM Stores WHERE Is_Default));
+ALTER TABLE Stations ALTER COLUMN Store_Id SET NOT NULL;
+ALTER TABLE Stations DROP COLUMN IF EXISTS Store;
+
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Store_Id INT REFERENCES Stores(Id);
+UPDATE Orders
+SET Store_Id = COALESCE(
+    (SELECT Store_Id FROM Stations WHERE Stations.Id = Orders.Station_Id),
+    (SELECT Id FROM Stores WHERE Is_Default));
+ALTER TABLE Orders ALTER COLUMN Store_Id SET NOT NULL;
+
+-- Stock per product per store. Products.Amount stays the total over all stores and
+-- Products.Stock_Flagged_At is set while any store is below zero.
+CREATE TABLE IF NOT EXISTS Product_Stock (
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Store_Id INT NOT NULL REFERENCES Stores(Id),
+    Amount INT NOT NULL DEFAULT 0,
+    Stock_Flagged_At TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (Product_Id, Store_Id)
+);
+
+CREATE INDEX IF NOT EXISTS product_stock_store_idx ON Product_Stock (Store_Id);
+
+INSERT INTO Product_Stock (Product_Id, Store_Id, Amount, Stock_Flagged_At)
+SELECT Id, (SELECT Id FROM Stores WHERE Is_Default), Amount, Stock_Flagged_At FROM Products;
+
+-- Movements are immutable, so the trigger is lifted while the store is filled in.
+ALTER TABLE Stock_Movements ADD COLUMN IF NOT EXISTS Store_Id INT REFERENCES Stores(Id);
+ALTER TABLE Stock_Movements DISABLE TRIGGER stock_movements_immutable;
+UPDATE Stock_Movements SET Store_Id = (SELECT Id FROM Stores WHERE Is_Default);
+ALTER TABLE Stock_Movements ENABLE TRIGGER stock_movements_immutable;
+ALTER TABLE Stock_Movements ALTER COLUMN Store_Id SET NOT NULL;
+
+-- Stocktakes count one store, and purchase orders are delivered to one store.
+ALTER TABLE Stocktakes ADD COLUMN IF NOT EXISTS Store_Id INT REFERENCES Stores(Id);
+UPDATE Stocktakes SET Store_Id = (SELECT Id FROM Stores WHERE Is_Default);
+ALTER TABLE Stocktakes ALTER COLUMN Store_Id SET NOT NULL;
+
+ALTER TABLE Purchase_Orders ADD COLUMN IF NOT EXISTS Store_Id INT REFERENCES Stores(Id);
+UPDATE Purchase_Orders SET Store_Id = (SELECT Id FROM Stores WHERE Is_Default);
+ALTER TABLE Purchase_Orders ALTER COLUMN Store_Id SET NOT NULL;
+
+INSERT INTO permissions (code)
+VALUES ('stores:write');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS Transfer_Lines;
+DROP TABLE IF EXISTS Transfers;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS Transfers (
+    Id SERIAL PRIMARY KEY,
+    From_Store_Id INT NOT NULL REFERENCES Stores(Id),
+    To_Store_Id INT NOT NULL REFERENCES Stores(Id),
+    Status VARCHAR(24) NOT NULL DEFAULT 'draft',
+    Notes TEXT NOT NULL DEFAULT '',
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Shipped_At TIMESTAMP,
+    Received_At TIMESTAMP,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (From_Store_Id <> To_Store_Id)
+);
+
+CREATE INDEX IF NOT EXISTS transfers_from_store_idx ON Transfers (From_Store_Id, Id);
+CREATE INDEX IF NOT EXISTS transfers_to_store_idx ON Transfers (To_Store_Id, Id);
+CREATE INDEX IF NOT EXISTS transfers_status_idx ON Transfers (Status);
+
+-- Qty is what was shipped; Qty_Received stays NULL until the transfer is received.
+CREATE TABLE IF NOT EXISTS Transfer_Lines (
+    Id SERIAL PRIMARY KEY,
+    Transfer_Id INT NOT NULL REFERENCES Transfers(Id) ON DELETE CASCADE,
+    Product_Id INT NOT NULL,
+    Qty INT NOT NULL CHECK (Qty > 0),
+    Qty_Received INT CHECK (Qty_Received >= 0),
+    Discrepancy_Reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS transfer_lines_transfer_idx ON Transfer_Lines (Transfer_Id, Id);
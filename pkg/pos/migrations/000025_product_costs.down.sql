@@ -0,0 +1,8 @@
+DROP FUNCTION IF EXISTS lines_cogs(JSONB);
+
+DROP TABLE IF EXISTS Cost_Layers;
+
+ALTER TABLE Stock_Movements DROP COLUMN IF EXISTS Unit_Cost;
+
+ALTER TABLE Products DROP COLUMN IF EXISTS Cost_Method;
+ALTER TABLE Products DROP COLUMN IF EXISTS Cost;
@@ -0,0 +1,26 @@
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Cost INT NOT NULL DEFAULT 0 CHECK (Cost >= 0);
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Cost_Method VARCHAR(16) NOT NULL DEFAULT 'average';
+
+ALTER TABLE Stock_Movements ADD COLUMN IF NOT EXISTS Unit_Cost INT;
+
+-- Stock on hand as FIFO layers: what is left of each intake, at what it cost. Layers are
+-- consumed oldest first whatever a product's cost method, so switching method works.
+CREATE TABLE IF NOT EXISTS Cost_Layers (
+    Id SERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Unit_Cost INT NOT NULL CHECK (Unit_Cost >= 0),
+    Qty_Remaining INT NOT NULL CHECK (Qty_Remaining >= 0),
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS cost_layers_open_idx ON Cost_Layers (Product_Id, Id) WHERE Qty_Remaining > 0;
+
+-- Stock from before cost tracking has no known cost.
+INSERT INTO Cost_Layers (Product_Id, Unit_Cost, Qty_Remaining)
+SELECT Id, 0, Amount FROM Products WHERE Amount > 0;
+
+-- The cost of goods sold of the order or refund lines in a products column.
+CREATE OR REPLACE FUNCTION lines_cogs(lines JSONB) RETURNS BIGINT AS $$
+    SELECT COALESCE(SUM((line->>'cogs')::int), 0)
+    FROM jsonb_array_elements(COALESCE(lines, '[]'::jsonb)) AS line
+$$ LANGUAGE SQL IMMUTABLE;
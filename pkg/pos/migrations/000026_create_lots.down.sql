@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS Stock_Movement_Lots;
+DROP TABLE IF EXISTS Lots;
+
+ALTER TABLE Products DROP COLUMN IF EXISTS Track_Lots;
@@ -0,0 +1,25 @@
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Track_Lots BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A lot is one batch of a product in one store. Lot_Number is empty for stock of unknown
+-- batch.
+CREATE TABLE IF NOT EXISTS Lots (
+    Id SERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Store_Id INT NOT NULL REFERENCES Stores(Id),
+    Lot_Number VARCHAR(64) NOT NULL DEFAULT '',
+    Expires_On DATE,
+    Qty INT NOT NULL DEFAULT 0 CHECK (Qty >= 0),
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (Product_Id, Store_Id, Lot_Number)
+);
+
+CREATE INDEX IF NOT EXISTS lots_fefo_idx ON Lots (Product_Id, Store_Id, Expires_On) WHERE Qty > 0;
+CREATE INDEX IF NOT EXISTS lots_expires_on_idx ON Lots (Expires_On) WHERE Qty > 0;
+
+-- Which lots a stock movement went into or came out of; Qty has the movement's sign.
+CREATE TABLE IF NOT EXISTS Stock_Movement_Lots (
+    Movement_Id BIGINT NOT NULL REFERENCES Stock_Movements(Id),
+    Lot_Id INT NOT NULL REFERENCES Lots(Id) ON DELETE CASCADE,
+    Qty INT NOT NULL,
+    PRIMARY KEY (Movement_Id, Lot_Id)
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS Stock_Movement_Serials;
+DROP TABLE IF EXISTS Serial_Numbers;
+
+ALTER TABLE Products DROP COLUMN IF EXISTS Serialized;
@@ -0,0 +1,25 @@
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Serialized BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- One unit of a serialized product, registered when it is first received. Store_Id is
+-- where it was last moved.
+CREATE TABLE IF NOT EXISTS Serial_Numbers (
+    Id SERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Store_Id INT NOT NULL REFERENCES Stores(Id),
+    Serial VARCHAR(64) NOT NULL,
+    Status VARCHAR(16) NOT NULL DEFAULT 'in_stock',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (Product_Id, Serial)
+);
+
+CREATE INDEX IF NOT EXISTS serial_numbers_serial_idx ON Serial_Numbers (Serial);
+
+-- Which units a stock movement moved.
+CREATE TABLE IF NOT EXISTS Stock_Movement_Serials (
+    Movement_Id BIGINT NOT NULL REFERENCES Stock_Movements(Id),
+    Serial_Id INT NOT NULL REFERENCES Serial_Numbers(Id) ON DELETE CASCADE,
+    PRIMARY KEY (Movement_Id, Serial_Id)
+);
+
+CREATE INDEX IF NOT EXISTS stock_movement_serials_serial_idx ON Stock_Movement_Serials (Serial_Id);
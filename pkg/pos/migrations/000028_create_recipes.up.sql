@@ -0,0 +1,12 @@
+-- A recipe (bill of materials) lists the ingredient products one unit of a product is made
+-- of. Qty is in the ingredient's stock units; Unit names them for display.
+CREATE TABLE IF NOT EXISTS Recipe_Lines (
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Ingredient_Id INT NOT NULL REFERENCES Products(Id) ON DELETE RESTRICT,
+    Qty INT NOT NULL CHECK (Qty > 0),
+    Unit VARCHAR(16) NOT NULL DEFAULT '',
+    PRIMARY KEY (Product_Id, Ingredient_Id),
+    CHECK (Product_Id <> Ingredient_Id)
+);
+
+CREATE INDEX IF NOT EXISTS recipe_lines_ingredient_idx ON Recipe_Lines (Ingredient_Id);
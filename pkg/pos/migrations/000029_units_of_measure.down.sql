@@ -0,0 +1,8 @@
+ALTER TABLE Stock_Movements DROP COLUMN IF EXISTS Unit_Qty;
+ALTER TABLE Stock_Movements DROP COLUMN IF EXISTS Unit;
+
+ALTER TABLE Purchase_Order_Lines DROP COLUMN IF EXISTS Unit;
+
+DROP TABLE IF EXISTS Product_Units;
+
+ALTER TABLE Products DROP COLUMN IF EXISTS Base_Unit;
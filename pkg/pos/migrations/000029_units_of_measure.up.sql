@@ -0,0 +1,25 @@
+-- Stock is always held in a product's base unit. Product_Units are the other units it is
+-- bought, sold or counted in, each worth Factor base units.
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Base_Unit VARCHAR(16) NOT NULL DEFAULT 'unit';
+
+CREATE TABLE IF NOT EXISTS Product_Units (
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Name VARCHAR(16) NOT NULL,
+    Factor INT NOT NULL CHECK (Factor > 0),
+    PRIMARY KEY (Product_Id, Name)
+);
+
+ALTER TABLE Purchase_Order_Lines ADD COLUMN IF NOT EXISTS Unit VARCHAR(16) NOT NULL DEFAULT '';
+
+ALTER TABLE Stock_Movements ADD COLUMN IF NOT EXISTS Unit VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE Stock_Movements ADD COLUMN IF NOT EXISTS Unit_Qty INT;
+
+-- Recipe units were labels for the ingredient's stock units until now: they become its
+-- base unit.
+UPDATE Products p SET Base_Unit = r.Unit
+FROM Recipe_Lines r
+WHERE r.Ingredient_Id = p.Id AND r.Unit <> '';
+
+UPDATE Recipe_Lines r SET Unit = ''
+FROM Products p
+WHERE p.Id = r.Ingredient_Id AND r.Unit <> p.Base_Unit;
@@ -0,0 +1,17 @@
+-- Images of a product, shown in the order of Position. Keys are where the original and
+-- its thumbnails are kept in the media storage; the URLs are where clients fetch them.
+CREATE TABLE IF NOT EXISTS Product_Images (
+    Id SERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Position INT NOT NULL DEFAULT 0,
+    Url TEXT NOT NULL,
+    Thumbnails JSONB NOT NULL DEFAULT '{}',
+    Keys TEXT[] NOT NULL DEFAULT '{}',
+    Content_Type VARCHAR(32) NOT NULL,
+    Width INT NOT NULL,
+    Height INT NOT NULL,
+    Size BIGINT NOT NULL,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS product_images_product_idx ON Product_Images (Product_Id, Position);
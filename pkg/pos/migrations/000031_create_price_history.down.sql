@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS Price_Changes;
+
+DROP TABLE IF EXISTS Scheduled_Prices;
@@ -0,0 +1,34 @@
+-- A price change that is due at Effective_At. The scheduled-prices worker applies pending
+-- changes once they are due.
+CREATE TABLE IF NOT EXISTS Scheduled_Prices (
+    Id SERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Price INT NOT NULL CHECK (Price >= 0),
+    Effective_At TIMESTAMP NOT NULL,
+    Status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Applied_At TIMESTAMP,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_prices_due_idx ON Scheduled_Prices (Effective_At) WHERE Status = 'pending';
+CREATE INDEX IF NOT EXISTS scheduled_prices_product_idx ON Scheduled_Prices (Product_Id, Effective_At);
+
+-- Every price a product has had. Old_Price is NULL for the price a product was created
+-- with. Changes made by applying a scheduled price take effect at its Effective_At.
+CREATE TABLE IF NOT EXISTS Price_Changes (
+    Id BIGSERIAL PRIMARY KEY,
+    Product_Id INT NOT NULL REFERENCES Products(Id) ON DELETE CASCADE,
+    Old_Price INT,
+    New_Price INT NOT NULL,
+    Employee_Id INT REFERENCES Employee(Id) ON DELETE SET NULL,
+    Scheduled_Price_Id INT REFERENCES Scheduled_Prices(Id) ON DELETE SET NULL,
+    Changed_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS price_changes_product_idx ON Price_Changes (Product_Id, Changed_At);
+
+-- The history of existing products starts with their current price.
+INSERT INTO Price_Changes (Product_Id, New_Price, Changed_At)
+SELECT Id, COALESCE(Price, 0), COALESCE(Created_At, CURRENT_TIMESTAMP) FROM Products
+WHERE NOT EXISTS (SELECT 1 FROM Price_Changes WHERE Price_Changes.Product_Id = Products.Id);
@@ -0,0 +1,8 @@
+DELETE FROM permissions WHERE code = 'pricing:write';
+
+ALTER TABLE Orders DROP COLUMN IF EXISTS Price_List_Id;
+ALTER TABLE Orders DROP COLUMN IF EXISTS Customer_Group;
+
+DROP TABLE IF EXISTS Price_List_Items;
+DROP TABLE IF EXISTS Price_List_Stations;
+DROP TABLE IF EXISTS Price_Lists;
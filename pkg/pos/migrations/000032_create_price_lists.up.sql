@@ -0,0 +1,43 @@
+-- A named set of prices, such as wholesale, staff or happy hour. A list applies to orders
+-- of its Customer_Groups and at its stations (any when none are listed), during its
+-- Schedule windows (always when there are none). The applicable list with the highest
+-- Priority prices an order, the oldest one on ties.
+CREATE TABLE IF NOT EXISTS Price_Lists (
+    Id SERIAL PRIMARY KEY,
+    Name VARCHAR(64) NOT NULL UNIQUE,
+    Priority INT NOT NULL DEFAULT 0,
+    Active BOOLEAN NOT NULL DEFAULT TRUE,
+    Customer_Groups TEXT[] NOT NULL DEFAULT '{}',
+    Schedule JSONB NOT NULL DEFAULT '[]',
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Updated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Price_List_Stations (
+    Price_List_Id INT NOT NULL REFERENCES Price_Lists(Id) ON DELETE CASCADE,
+    Station_Id INT NOT NULL REFERENCES Stations(Id) ON DELETE CASCADE,
+    PRIMARY KEY (Price_List_Id, Station_Id)
+);
+
+-- An override of the price of a product, or of every product of a category, either as a
+-- fixed Price or as a Discount_Percent off the product's own price.
+CREATE TABLE IF NOT EXISTS Price_List_Items (
+    Id SERIAL PRIMARY KEY,
+    Price_List_Id INT NOT NULL REFERENCES Price_Lists(Id) ON DELETE CASCADE,
+    Product_Id INT REFERENCES Products(Id) ON DELETE CASCADE,
+    Category_Id INT REFERENCES Categories(Id) ON DELETE CASCADE,
+    Price INT CHECK (Price >= 0),
+    Discount_Percent NUMERIC(5, 2) CHECK (Discount_Percent > 0 AND Discount_Percent <= 100),
+    CHECK ((Product_Id IS NULL) <> (Category_Id IS NULL)),
+    CHECK ((Price IS NULL) <> (Discount_Percent IS NULL)),
+    UNIQUE (Price_List_Id, Product_Id),
+    UNIQUE (Price_List_Id, Category_Id)
+);
+
+-- Orders remember the customer group they were rung up for and the price list that
+-- priced them.
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Customer_Group VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Price_List_Id INT REFERENCES Price_Lists(Id) ON DELETE SET NULL;
+
+INSERT INTO permissions (code)
+VALUES ('pricing:write');
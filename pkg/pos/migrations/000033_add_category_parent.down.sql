@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS categories_parent_id_idx;
+
+ALTER TABLE Categories DROP COLUMN IF EXISTS Parent_Id;
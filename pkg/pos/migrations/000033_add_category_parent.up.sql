@@ -0,0 +1,6 @@
+-- Categories form a tree: a category without a parent is a top-level one. Cycles are
+-- prevented by the application; a category cannot be its own parent.
+ALTER TABLE Categories ADD COLUMN IF NOT EXISTS Parent_Id INT REFERENCES Categories(Id);
+ALTER TABLE Categories ADD CONSTRAINT categories_parent_id_check CHECK (Parent_Id <> Id);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON Categories (Parent_Id);
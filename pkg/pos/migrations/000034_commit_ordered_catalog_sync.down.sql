@@ -0,0 +1,40 @@
+CREATE SEQUENCE IF NOT EXISTS catalog_sync_seq;
+
+CREATE OR REPLACE FUNCTION catalog_bump_sync_version() RETURNS trigger AS $$
+BEGIN
+    NEW.sync_version := nextval('catalog_sync_seq');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION catalog_record_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO catalog_tombstones (entity, entity_id)
+    VALUES (TG_ARGV[0], OLD.id)
+    ON CONFLICT (entity, entity_id) DO UPDATE
+    SET sync_version = nextval('catalog_sync_seq'), deleted_at = CURRENT_TIMESTAMP;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS categories_sync_version_update ON Categories;
+DROP TRIGGER IF EXISTS categories_sync_version ON Categories;
+CREATE TRIGGER categories_sync_version BEFORE INSERT OR UPDATE ON Categories
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_sync_version();
+
+DROP TRIGGER IF EXISTS products_sync_version_update ON Products;
+DROP TRIGGER IF EXISTS products_sync_version ON Products;
+CREATE TRIGGER products_sync_version BEFORE INSERT OR UPDATE ON Products
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_sync_version();
+
+ALTER TABLE Catalog_Tombstones ALTER COLUMN Sync_Version SET DEFAULT nextval('catalog_sync_seq');
+ALTER TABLE Categories ALTER COLUMN Sync_Version SET DEFAULT nextval('catalog_sync_seq');
+ALTER TABLE Products ALTER COLUMN Sync_Version SET DEFAULT nextval('catalog_sync_seq');
+
+-- Continue the sequence past the transaction ids handed out so far.
+SELECT setval('catalog_sync_seq', GREATEST(
+    (SELECT COALESCE(MAX(Sync_Version), 1) FROM Products),
+    (SELECT COALESCE(MAX(Sync_Version), 1) FROM Categories),
+    (SELECT COALESCE(MAX(Sync_Version), 1) FROM Catalog_Tombstones)));
+
+DROP FUNCTION IF EXISTS catalog_sync_version();
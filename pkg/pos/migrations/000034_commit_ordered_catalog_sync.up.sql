@@ -0,0 +1,55 @@
+-- Catalog sync versions are the id of the transaction that made the change instead of a
+-- sequence value. Sequence values follow write order, so a transaction could commit a
+-- lower version after a register had already synced past it. With transaction ids the
+-- feed only hands out versions below the oldest transaction still running, which are final.
+CREATE OR REPLACE FUNCTION catalog_sync_version() RETURNS BIGINT AS $$
+    SELECT pg_current_xact_id()::text::bigint;
+$$ LANGUAGE sql VOLATILE;
+
+CREATE OR REPLACE FUNCTION catalog_bump_sync_version() RETURNS trigger AS $$
+BEGIN
+    NEW.sync_version := catalog_sync_version();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION catalog_record_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO catalog_tombstones (entity, entity_id)
+    VALUES (TG_ARGV[0], OLD.id)
+    ON CONFLICT (entity, entity_id) DO UPDATE
+    SET sync_version = catalog_sync_version(), deleted_at = CURRENT_TIMESTAMP;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE Products ALTER COLUMN Sync_Version SET DEFAULT catalog_sync_version();
+ALTER TABLE Categories ALTER COLUMN Sync_Version SET DEFAULT catalog_sync_version();
+ALTER TABLE Catalog_Tombstones ALTER COLUMN Sync_Version SET DEFAULT catalog_sync_version();
+
+-- Only changes to what registers show and sell by bump the version; stock, cost and
+-- alert bookkeeping on every sale do not.
+DROP TRIGGER IF EXISTS products_sync_version ON Products;
+CREATE TRIGGER products_sync_version BEFORE INSERT ON Products
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_sync_version();
+CREATE TRIGGER products_sync_version_update BEFORE UPDATE ON Products
+    FOR EACH ROW
+    WHEN ((OLD.name, OLD.category_id, OLD.price, OLD.description, OLD.base_unit, OLD.track_lots, OLD.serialized)
+        IS DISTINCT FROM (NEW.name, NEW.category_id, NEW.price, NEW.description, NEW.base_unit, NEW.track_lots, NEW.serialized))
+    EXECUTE FUNCTION catalog_bump_sync_version();
+
+DROP TRIGGER IF EXISTS categories_sync_version ON Categories;
+CREATE TRIGGER categories_sync_version BEFORE INSERT ON Categories
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_sync_version();
+CREATE TRIGGER categories_sync_version_update BEFORE UPDATE ON Categories
+    FOR EACH ROW
+    WHEN ((OLD.name, OLD.parent_id) IS DISTINCT FROM (NEW.name, NEW.parent_id))
+    EXECUTE FUNCTION catalog_bump_sync_version();
+
+-- Sequence values and transaction ids are not comparable: move everything to this
+-- transaction's id. Registers then download the catalog again.
+UPDATE Products SET Sync_Version = catalog_sync_version();
+UPDATE Categories SET Sync_Version = catalog_sync_version();
+UPDATE Catalog_Tombstones SET Sync_Version = catalog_sync_version();
+
+DROP SEQUENCE IF EXISTS catalog_sync_seq;
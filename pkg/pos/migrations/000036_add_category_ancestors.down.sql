@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS category_ancestors(INT);
@@ -0,0 +1,15 @@
+-- category_ancestors lists a category and the categories above it, nearest first: the
+-- category itself has depth 0, its parent 1, and so on up to a top-level category.
+CREATE OR REPLACE FUNCTION category_ancestors(category INT)
+RETURNS TABLE (ancestor_id INT, depth INT) AS $$
+    WITH RECURSIVE ancestors (id, parent_id, depth) AS (
+        SELECT categories.id, categories.parent_id, 0
+        FROM categories
+        WHERE categories.id = category
+        UNION ALL
+        SELECT categories.id, categories.parent_id, ancestors.depth + 1
+        FROM categories
+        INNER JOIN ancestors ON categories.id = ancestors.parent_id
+    ) CYCLE id SET is_cycle USING path
+    SELECT id, depth FROM ancestors WHERE NOT is_cycle;
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,4 @@
+-- Lets cashiers put orders in a customer group, such as staff or wholesale, and so get
+-- the group's price lists.
+INSERT INTO permissions (code)
+VALUES ('pricing:apply');
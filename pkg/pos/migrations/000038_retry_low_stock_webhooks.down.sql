@@ -0,0 +1 @@
+ALTER TABLE Products DROP COLUMN IF EXISTS Low_Stock_Webhook_Pending;
@@ -0,0 +1,3 @@
+-- Low_Stock_Webhook_Pending is set when a low-stock alert still has to be POSTed to the
+-- webhook, and cleared once it was delivered or the amount recovered.
+ALTER TABLE Products ADD COLUMN IF NOT EXISTS Low_Stock_Webhook_Pending BOOLEAN NOT NULL DEFAULT FALSE;
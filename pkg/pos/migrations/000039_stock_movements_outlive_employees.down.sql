@@ -0,0 +1,2 @@
+ALTER TABLE Stock_Movements ADD CONSTRAINT stock_movements_employee_id_fkey
+    FOREIGN KEY (Employee_Id) REFERENCES Employee(Id) ON DELETE SET NULL NOT VALID;
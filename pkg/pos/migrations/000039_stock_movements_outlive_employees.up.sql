@@ -0,0 +1,3 @@
+-- Like product_id, employee_id is kept after the employee is deleted: ON DELETE SET NULL
+-- would update movements, which the ledger does not allow.
+ALTER TABLE Stock_Movements DROP CONSTRAINT IF EXISTS stock_movements_employee_id_fkey;
@@ -0,0 +1,3 @@
+ALTER TABLE Refunds DROP CONSTRAINT IF EXISTS refunds_order_id_fkey;
+ALTER TABLE Refunds ADD CONSTRAINT refunds_order_id_fkey
+    FOREIGN KEY (Order_Id) REFERENCES Orders(Id) ON DELETE CASCADE;
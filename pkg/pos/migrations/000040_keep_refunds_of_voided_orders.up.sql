@@ -0,0 +1,5 @@
+-- Refunds were paid out, so they must not vanish with their order: an order that has
+-- refunds cannot be deleted.
+ALTER TABLE Refunds DROP CONSTRAINT IF EXISTS refunds_order_id_fkey;
+ALTER TABLE Refunds ADD CONSTRAINT refunds_order_id_fkey
+    FOREIGN KEY (Order_Id) REFERENCES Orders(Id) ON DELETE RESTRICT;
@@ -0,0 +1 @@
+ALTER TABLE Commission_Entries DROP COLUMN IF EXISTS Unit;
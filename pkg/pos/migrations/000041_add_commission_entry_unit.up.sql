@@ -0,0 +1,15 @@
+-- Unit is the unit the line was sold in, so that a refund of a case reverses the
+-- commission on a case rather than on a single unit.
+ALTER TABLE Commission_Entries ADD COLUMN IF NOT EXISTS Unit VARCHAR(16) NOT NULL DEFAULT '';
+
+-- Earlier entries take the unit of their order's lines where the product was sold in one
+-- unit only.
+UPDATE Commission_Entries e
+SET Unit = l.Unit
+FROM (
+    SELECT o.Id AS Order_Id, p->>'product_id' AS Product_Id, MIN(COALESCE(p->>'unit', '')) AS Unit
+    FROM Orders o, jsonb_array_elements(o.Products) p
+    GROUP BY o.Id, p->>'product_id'
+    HAVING COUNT(DISTINCT COALESCE(p->>'unit', '')) = 1
+) l
+WHERE e.Order_Id = l.Order_Id AND e.Product_Id = l.Product_Id;
@@ -0,0 +1,4 @@
+-- Keys are chosen by clients, so they are only unique per employee and station.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS scope VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, key);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS product_images_sync_version ON Product_Images;
+DROP TRIGGER IF EXISTS product_units_sync_version ON Product_Units;
+DROP FUNCTION IF EXISTS catalog_bump_product_sync_version();
@@ -0,0 +1,20 @@
+-- Units and images are part of the catalog entry of their product, so changing them
+-- bumps the product's sync version.
+CREATE OR REPLACE FUNCTION catalog_bump_product_sync_version() RETURNS trigger AS $$
+BEGIN
+    UPDATE products SET sync_version = catalog_sync_version()
+    WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.product_id ELSE NEW.product_id END;
+    IF TG_OP = 'UPDATE' AND OLD.product_id <> NEW.product_id THEN
+        UPDATE products SET sync_version = catalog_sync_version() WHERE id = OLD.product_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS product_units_sync_version ON Product_Units;
+CREATE TRIGGER product_units_sync_version AFTER INSERT OR UPDATE OR DELETE ON Product_Units
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_product_sync_version();
+
+DROP TRIGGER IF EXISTS product_images_sync_version ON Product_Images;
+CREATE TRIGGER product_images_sync_version AFTER INSERT OR UPDATE OR DELETE ON Product_Images
+    FOR EACH ROW EXECUTE FUNCTION catalog_bump_product_sync_version();
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+const (
+	CashPayIn  = "pay_in"
+	CashPayOut = "pay_out"
+	CashDrop   = "drop"
+)
+
+// CashReasonCodes lists the reason codes accepted for each type of cash movement.
+var CashReasonCodes = map[string][]string{
+	CashPayIn:  {"float_top_up", "change_return", "other"},
+	CashPayOut: {"supplies", "petty_cash", "refund", "tip_out", "other"},
+	CashDrop:   {"safe_drop", "bank_deposit"},
+}
+
+type CashMovement struct {
+	Id         int       `json:"id"`
+	ShiftId    int       `json:"shift_id"`
+	EmployeeId int       `json:"employee_id"`
+	Type       string    `json:"type"`
+	ReasonCode string    `json:"reason_code"`
+	Amount     float64   `json:"amount"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CashMovementModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateCashMovement(v *validator.Validator, m *CashMovement) {
+	codes, ok := CashReasonCodes[m.Type]
+	v.Check(ok, "type", "must be one of pay_in, pay_out or drop")
+	if ok {
+		v.Check(validator.In(m.ReasonCode, codes...), "reason_code", "is not valid for this movement type")
+	}
+	v.Check(m.Amount > 0, "amount", "must be greater than zero")
+	v.Check(len(m.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+func (c CashMovementModule) Create(m *CashMovement) error {
+	query := `
+			INSERT INTO cash_movements (shift_id, employee_id, type, reason_code, amount, note)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at
+			`
+	args := []interface{}{m.ShiftId, m.EmployeeId, m.Type, m.ReasonCode, m.Amount, m.Note}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return c.DB.QueryRowContext(ctx, query, args...).Scan(&m.Id, &m.CreatedAt)
+}
+
+func (c CashMovementModule) GetAllForShift(shiftId int) ([]CashMovement, error) {
+	query := `
+			SELECT id, shift_id, employee_id, type, reason_code, amount, note, created_at
+			FROM cash_movements
+			WHERE shift_id = $1
+			ORDER BY created_at, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, shiftId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []CashMovement{}
+	for rows.Next() {
+		var m CashMovement
+		err := rows.Scan(&m.Id, &m.ShiftId, &m.EmployeeId, &m.Type, &m.ReasonCode, &m.Amount, &m.Note, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
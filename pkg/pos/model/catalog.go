@@ -0,0 +1,165 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	CatalogProduct  = "product"
+	CatalogCategory = "category"
+)
+
+// CatalogTombstone records that a catalog entity was deleted.
+type CatalogTombstone struct {
+	Entity    string    `json:"entity"`
+	Id        int       `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// CatalogChanges is one page of the catalog change feed. SyncToken is the version to pass
+// as "since" on the next request; when HasMore is set the caller should ask again at once.
+type CatalogChanges struct {
+	Products   []Product          `json:"products"`
+	Categories []Category         `json:"categories"`
+	Deleted    []CatalogTombstone `json:"deleted"`
+	SyncToken  int64              `json:"sync_token"`
+	HasMore    bool               `json:"has_more"`
+}
+
+type CatalogModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Changes returns products, categories and deletions whose sync version is greater than
+// since, in version order. Prices live on the product, so a price change shows up as a
+// changed product.
+//
+// A version is the id of the transaction that made the change, and only versions below the
+// oldest transaction still running are returned: those transactions have ended, so no
+// change can show up later with a version the caller has already passed. A page holds
+// about limit changes but never splits the changes of one transaction. A since ahead of
+// the feed, such as a token from before versions were transaction ids, starts over at 0.
+func (c CatalogModule) Changes(since int64, limit int) (*CatalogChanges, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var bound int64
+	query := `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`
+	if err := c.DB.QueryRowContext(ctx, query).Scan(&bound); err != nil {
+		return nil, err
+	}
+	if since >= bound {
+		since = 0
+	}
+
+	query = `
+			SELECT entity, id, sync_version, deleted_at FROM (
+				SELECT 'product' AS entity, id, sync_version, NULL::timestamp AS deleted_at
+				FROM products WHERE sync_version > $1 AND sync_version < $2
+				UNION ALL
+				SELECT 'category', id, sync_version, NULL
+				FROM categories WHERE sync_version > $1 AND sync_version < $2
+				UNION ALL
+				SELECT entity || ':deleted', entity_id, sync_version, deleted_at
+				FROM catalog_tombstones WHERE sync_version > $1 AND sync_version < $2
+			) changes
+			ORDER BY sync_version
+			`
+	rows, err := c.DB.QueryContext(ctx, query, since, bound)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := &CatalogChanges{
+		Products:   []Product{},
+		Categories: []Category{},
+		Deleted:    []CatalogTombstone{},
+		SyncToken:  bound - 1,
+	}
+	var productIds, categoryIds []int
+	var last int64
+	count := 0
+
+	for rows.Next() {
+		var entity string
+		var id int
+		var version int64
+		var deletedAt *time.Time
+		if err := rows.Scan(&entity, &id, &version, &deletedAt); err != nil {
+			return nil, err
+		}
+
+		if count >= limit && version != last {
+			changes.HasMore = true
+			changes.SyncToken = last
+			break
+		}
+		count++
+		last = version
+
+		switch entity {
+		case CatalogProduct:
+			productIds = append(productIds, id)
+		case CatalogCategory:
+			categoryIds = append(categoryIds, id)
+		default:
+			changes.Deleted = append(changes.Deleted, CatalogTombstone{Entity: strings.TrimSuffix(entity, ":deleted"), Id: id, DeletedAt: *deletedAt})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(productIds) > 0 {
+		query := `SELECT ` + productColumns + ` FROM products WHERE id = ANY($1) ORDER BY sync_version`
+		rows, err := c.DB.QueryContext(ctx, query, pq.Array(productIds))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var prd Product
+			if err := rows.Scan(productFields(&prd)...); err != nil {
+				return nil, err
+			}
+			changes.Products = append(changes.Products, prd)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(categoryIds) > 0 {
+		query := `SELECT ` + categoryColumns + ` FROM categories WHERE id = ANY($1) ORDER BY sync_version`
+		rows, err := c.DB.QueryContext(ctx, query, pq.Array(categoryIds))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var ctg Category
+			if err := scanCategory(rows, &ctg); err != nil {
+				return nil, err
+			}
+			changes.Categories = append(changes.Categories, ctg)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
@@ -3,17 +3,50 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"time"
 )
 
+var (
+	// ErrCategoryCycle is returned when a category would be moved or merged into itself or
+	// one of its descendants.
+	ErrCategoryCycle = errors.New("category cannot be placed under itself or one of its descendants")
+)
+
 type Category struct {
 	Id        int       `json:"id"`
 	Name      string    `json:"name"`
+	ParentId  *int      `json:"parent_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// CategoryNode is a category with the categories directly under it, and so on down.
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+const categoryColumns = `id, name, parent_id, created_at, updated_at`
+
+func scanCategory(row interface{ Scan(...interface{}) error }, category *Category) error {
+	return row.Scan(&category.Id, &category.Name, &category.ParentId, &category.CreatedAt, &category.UpdatedAt)
+}
+
+// categorySubtree is a recursive CTE named subtree holding the id of the category given
+// by param and those of all of its descendants. UNION rather than UNION ALL keeps it
+// finite even if the tree were ever to contain a cycle.
+func categorySubtree(param string) string {
+	return `subtree AS (
+				SELECT id FROM categories WHERE id = ` + param + `
+				UNION
+				SELECT categories.id FROM categories INNER JOIN subtree ON categories.parent_id = subtree.id
+			)`
+}
+
 type CategoryModule struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
@@ -22,19 +55,23 @@ type CategoryModule struct {
 
 func (c CategoryModule) Create(category *Category) error {
 	query := `
-			INSERT INTO categories (name)
-			VALUES ($1)
+			INSERT INTO categories (name, parent_id)
+			VALUES ($1, $2)
 			RETURNING id
 			`
-	args := []interface{}{category.Name}
+	args := []interface{}{category.Name, category.ParentId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(&category.Id)
+	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&category.Id)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: %d", ErrUnknownCategory, *category.ParentId)
+	}
+	return err
 }
 
 func (c CategoryModule) GetAll() (*[]Category, error) {
-	query := `SELECT * from categories`
+	query := `SELECT ` + categoryColumns + ` from categories`
 
 	var categories []Category
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -48,7 +85,7 @@ func (c CategoryModule) GetAll() (*[]Category, error) {
 
 	for rows.Next() {
 		var ctg Category
-		err := rows.Scan(&ctg.Id, &ctg.Name, &ctg.CreatedAt, &ctg.UpdatedAt)
+		err := scanCategory(rows, &ctg)
 		if err != nil {
 			return nil, err
 		}
@@ -62,9 +99,51 @@ func (c CategoryModule) GetAll() (*[]Category, error) {
 	return &categories, nil
 }
 
+// Tree returns the top-level categories with their descendants nested under them, each
+// level ordered by name.
+func (c CategoryModule) Tree() ([]*CategoryNode, error) {
+	categories, err := c.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int]*CategoryNode, len(*categories))
+	for _, ctg := range *categories {
+		nodes[ctg.Id] = &CategoryNode{Category: ctg, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, ctg := range *categories {
+		node := nodes[ctg.Id]
+		if ctg.ParentId != nil {
+			if parent, ok := nodes[*ctg.ParentId]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	var sortNodes func([]*CategoryNode)
+	sortNodes = func(nodes []*CategoryNode) {
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].Name != nodes[j].Name {
+				return nodes[i].Name < nodes[j].Name
+			}
+			return nodes[i].Id < nodes[j].Id
+		})
+		for _, node := range nodes {
+			sortNodes(node.Children)
+		}
+	}
+	sortNodes(roots)
+
+	return roots, nil
+}
+
 func (c CategoryModule) Get(id int) (*Category, error) {
 	query := `
-			SELECT * FROM categories
+			SELECT ` + categoryColumns + ` FROM categories
 			WHERE id = $1
 			`
 
@@ -73,7 +152,7 @@ func (c CategoryModule) Get(id int) (*Category, error) {
 	defer cancel()
 
 	row := c.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&category.Id, &category.Name, &category.CreatedAt, &category.UpdatedAt)
+	err := scanCategory(row, &category)
 
 	if err != nil {
 		return nil, err
@@ -96,14 +175,168 @@ func (c CategoryModule) Update(id int, category *Category) error {
 	return err
 }
 
+// Delete removes a category. The categories directly under it move up to its parent.
 func (c CategoryModule) Delete(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
+			UPDATE categories
+			SET parent_id = (SELECT parent_id FROM categories WHERE id = $1), updated_at = CURRENT_TIMESTAMP
+			WHERE parent_id = $1
+			`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	query = `
 			DELETE FROM categories
 			WHERE id = $1
 			`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Move puts a category, along with everything under it, under another category, or at the
+// top level when parentId is nil.
+func (c CategoryModule) Move(id int, parentId *int) (*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCategoryTree(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := checkCategoryPlacement(ctx, tx, id, parentId); err != nil {
+		return nil, err
+	}
+
+	query := `
+			UPDATE categories
+			SET parent_id = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2
+			RETURNING ` + categoryColumns
+
+	var category Category
+	if err := scanCategory(tx.QueryRowContext(ctx, query, parentId, id), &category); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// Merge folds a category into another one: its products, the categories directly under
+// it, and whatever refers to it move to the target, and the category itself is deleted.
+// Where the target already has a prep station or a price list item of its own, the
+// target's is kept. The target cannot be under the merged category.
+func (c CategoryModule) Merge(id, targetId int) (*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := c.DB.ExecContext(ctx, query, id)
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCategoryTree(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := checkCategoryPlacement(ctx, tx, id, &targetId); err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		`UPDATE categories SET parent_id = $2, updated_at = CURRENT_TIMESTAMP WHERE parent_id = $1`,
+		`UPDATE products SET category_id = $2, updated_at = CURRENT_TIMESTAMP WHERE category_id = $1`,
+		`UPDATE commission_rules SET category_id = $2, updated_at = CURRENT_TIMESTAMP WHERE category_id = $1`,
+		`UPDATE stocktakes SET category_id = $2 WHERE category_id = $1`,
+		`UPDATE prep_station_categories SET category_id = $2
+			WHERE category_id = $1 AND NOT EXISTS (SELECT 1 FROM prep_station_categories WHERE category_id = $2)`,
+		`UPDATE price_list_items SET category_id = $2
+			WHERE category_id = $1 AND NOT EXISTS (
+				SELECT 1 FROM price_list_items target
+				WHERE target.price_list_id = price_list_items.price_list_id AND target.category_id = $2)`,
+		`DELETE FROM categories WHERE id = $1`,
+	}
+	for _, query := range statements {
+		if _, err := tx.ExecContext(ctx, query, id, targetId); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE id = $1`
+
+	var category Category
+	if err := scanCategory(tx.QueryRowContext(ctx, query, targetId), &category); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// lockCategoryTree keeps concurrent moves and merges from each passing the cycle check
+// and together creating a cycle. Reads of categories are not blocked.
+func lockCategoryTree(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
 	return err
 }
+
+// checkCategoryPlacement checks that category id exists, and that it can be placed under
+// parentId: that category must exist and must be neither id itself nor under it.
+func checkCategoryPlacement(ctx context.Context, tx *sql.Tx, id int, parentId *int) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	if parentId == nil {
+		return nil
+	}
+
+	query := `
+			WITH RECURSIVE ` + categorySubtree("$1") + `
+			SELECT EXISTS (SELECT 1 FROM categories WHERE id = $2),
+			       EXISTS (SELECT 1 FROM subtree WHERE id = $2)
+			`
+	var parentExists, underItself bool
+	err = tx.QueryRowContext(ctx, query, id, *parentId).Scan(&parentExists, &underItself)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !parentExists:
+		return fmt.Errorf("%w: %d", ErrUnknownCategory, *parentId)
+	case underItself:
+		return ErrCategoryCycle
+	}
+
+	return nil
+}
@@ -0,0 +1,461 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+const (
+	CommissionPercent = "percent"
+	CommissionFlat    = "flat"
+	CommissionTiered  = "tiered"
+)
+
+// CommissionTier applies Rate percent once the seller's sales in the month reach MinVolume.
+type CommissionTier struct {
+	MinVolume float64 `json:"min_volume"`
+	Rate      float64 `json:"rate"`
+}
+
+// CommissionRule decides what a seller earns on an order line. A rule bound to a product
+// wins over a rule bound to the product's category or, failing that, to the nearest
+// category above it, which wins over a catch-all rule.
+type CommissionRule struct {
+	Id         int              `json:"id"`
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	CategoryId *int             `json:"category_id"`
+	ProductId  *int             `json:"product_id"`
+	Rate       float64          `json:"rate"`
+	Amount     float64          `json:"amount"`
+	Tiers      []CommissionTier `json:"tiers"`
+	Active     bool             `json:"active"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+type CommissionEntry struct {
+	Id         int       `json:"id"`
+	OrderId    int       `json:"order_id"`
+	EmployeeId int       `json:"employee_id"`
+	ProductId  string    `json:"product_id"`
+	Unit       string    `json:"unit,omitempty"`
+	RuleId     *int      `json:"rule_id"`
+	Qty        int       `json:"qty"`
+	SaleAmount float64   `json:"sale_amount"`
+	Commission float64   `json:"commission"`
+	IsReversal bool      `json:"is_reversal"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CommissionStatement struct {
+	EmployeeId int               `json:"employee_id"`
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Entries    []CommissionEntry `json:"entries"`
+	Sales      float64           `json:"sales"`
+	Earned     float64           `json:"earned"`
+	Reversed   float64           `json:"reversed"`
+	Net        float64           `json:"net"`
+}
+
+type CommissionModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateCommissionRule(v *validator.Validator, rule *CommissionRule) {
+	v.Check(rule.Name != "", "name", "must be provided")
+	v.Check(validator.In(rule.Type, CommissionPercent, CommissionFlat, CommissionTiered), "type", "must be percent, flat or tiered")
+	v.Check(rule.CategoryId == nil || rule.ProductId == nil, "product_id", "cannot be combined with category_id")
+
+	switch rule.Type {
+	case CommissionPercent:
+		v.Check(rule.Rate > 0 && rule.Rate <= 100, "rate", "must be between 0 and 100")
+	case CommissionFlat:
+		v.Check(rule.Amount > 0, "amount", "must be greater than zero")
+	case CommissionTiered:
+		v.Check(len(rule.Tiers) > 0, "tiers", "must contain at least one tier")
+		for _, tier := range rule.Tiers {
+			v.Check(tier.MinVolume >= 0, "tiers", "min_volume must not be negative")
+			v.Check(tier.Rate > 0 && tier.Rate <= 100, "tiers", "rate must be between 0 and 100")
+		}
+	}
+}
+
+const commissionRuleColumns = `id, name, type, category_id, product_id, rate, amount, tiers, active, created_at, updated_at`
+
+func scanCommissionRule(row interface{ Scan(...interface{}) error }, rule *CommissionRule) error {
+	var tiersJSON []byte
+	err := row.Scan(&rule.Id, &rule.Name, &rule.Type, &rule.CategoryId, &rule.ProductId,
+		&rule.Rate, &rule.Amount, &tiersJSON, &rule.Active, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(tiersJSON, &rule.Tiers)
+}
+
+func (c CommissionModule) CreateRule(rule *CommissionRule) error {
+	query := `
+			INSERT INTO commission_rules (name, type, category_id, product_id, rate, amount, tiers, active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id, created_at, updated_at
+			`
+	if rule.Tiers == nil {
+		rule.Tiers = []CommissionTier{}
+	}
+	tiersJSON, err := json.Marshal(rule.Tiers)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{rule.Name, rule.Type, rule.CategoryId, rule.ProductId, rule.Rate, rule.Amount, tiersJSON, rule.Active}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return c.DB.QueryRowContext(ctx, query, args...).Scan(&rule.Id, &rule.CreatedAt, &rule.UpdatedAt)
+}
+
+func (c CommissionModule) GetRule(id int) (*CommissionRule, error) {
+	query := `SELECT ` + commissionRuleColumns + ` FROM commission_rules WHERE id = $1`
+
+	var rule CommissionRule
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCommissionRule(c.DB.QueryRowContext(ctx, query, id), &rule)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (c CommissionModule) GetAllRules() ([]CommissionRule, error) {
+	query := `SELECT ` + commissionRuleColumns + ` FROM commission_rules ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []CommissionRule{}
+	for rows.Next() {
+		var rule CommissionRule
+		if err := scanCommissionRule(rows, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (c CommissionModule) UpdateRule(id int, rule *CommissionRule) error {
+	query := `
+			UPDATE commission_rules
+			SET name = $1, type = $2, category_id = $3, product_id = $4, rate = $5, amount = $6, tiers = $7, active = $8,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $9
+			RETURNING id, created_at, updated_at
+			`
+	if rule.Tiers == nil {
+		rule.Tiers = []CommissionTier{}
+	}
+	tiersJSON, err := json.Marshal(rule.Tiers)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{rule.Name, rule.Type, rule.CategoryId, rule.ProductId, rule.Rate, rule.Amount, tiersJSON, rule.Active, id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = c.DB.QueryRowContext(ctx, query, args...).Scan(&rule.Id, &rule.CreatedAt, &rule.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+func (c CommissionModule) DeleteRule(id int) error {
+	query := `DELETE FROM commission_rules WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := c.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// accrueCommissions records within tx the commission earned on each of the given order
+// lines, so that it is booked together with the sale. Lines are attributed to their
+// SellerId, falling back to the cashier who rang up the order.
+func accrueCommissions(ctx context.Context, tx *sql.Tx, order *Order, lines []OrderProduct) error {
+	for _, line := range lines {
+		sellerId := order.EmployeeID
+		if line.SellerId != nil {
+			sellerId = *line.SellerId
+		}
+
+		productId, err := strconv.Atoi(line.ProductId)
+		if err != nil {
+			continue
+		}
+
+		rule, err := commissionRuleFor(ctx, tx, productId)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				continue
+			}
+			return err
+		}
+
+		entry := CommissionEntry{
+			OrderId:    order.Id,
+			EmployeeId: sellerId,
+			ProductId:  line.ProductId,
+			Unit:       line.Unit,
+			RuleId:     &rule.Id,
+			Qty:        line.Qty,
+			SaleAmount: float64(line.Price) * float64(line.Qty),
+		}
+
+		switch rule.Type {
+		case CommissionPercent:
+			entry.Commission = entry.SaleAmount * rule.Rate / 100
+		case CommissionFlat:
+			entry.Commission = rule.Amount * float64(line.Qty)
+		case CommissionTiered:
+			volume, err := monthlyVolume(ctx, tx, sellerId, time.Now())
+			if err != nil {
+				return err
+			}
+			entry.Commission = entry.SaleAmount * tierRate(rule.Tiers, volume) / 100
+		}
+
+		if err := insertCommissionEntry(ctx, tx, &entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reverseCommissions books within tx negative entries that cancel the commission earned
+// on qty units of a product, sold in the unit of key, on the order. Pass a zero key and
+// qty to reverse everything still outstanding. Callers hold the lock on the order row, so
+// that concurrent reversals see each other's entries.
+func reverseCommissions(ctx context.Context, tx *sql.Tx, orderId int, key RefundKey, qty int) error {
+	query := `
+			SELECT ` + commissionEntryColumns + `
+			FROM commission_entries
+			WHERE order_id = $1 AND ((product_id = $2 AND unit = $3) OR $2 = '')
+			ORDER BY id
+			`
+	rows, err := tx.QueryContext(ctx, query, orderId, key.ProductId, key.Unit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Work out what is still outstanding per seller, product and unit so repeated partial
+	// refunds never reverse more than was earned.
+	type sellerKey struct {
+		employeeId int
+		productId  string
+		unit       string
+	}
+	outstanding := make(map[sellerKey]*CommissionEntry)
+	var keys []sellerKey
+
+	for rows.Next() {
+		var e CommissionEntry
+		if err := scanCommissionEntry(rows, &e); err != nil {
+			return err
+		}
+
+		k := sellerKey{e.EmployeeId, e.ProductId, e.Unit}
+		acc, ok := outstanding[k]
+		if !ok {
+			acc = &CommissionEntry{OrderId: e.OrderId, EmployeeId: e.EmployeeId, ProductId: e.ProductId, Unit: e.Unit, RuleId: e.RuleId}
+			outstanding[k] = acc
+			keys = append(keys, k)
+		}
+		acc.Qty += e.Qty
+		acc.SaleAmount += e.SaleAmount
+		acc.Commission += e.Commission
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	remaining := qty
+	for _, k := range keys {
+		acc := outstanding[k]
+		if acc.Qty <= 0 {
+			continue
+		}
+
+		n := acc.Qty
+		if qty > 0 {
+			if remaining <= 0 {
+				break
+			}
+			if remaining < n {
+				n = remaining
+			}
+			remaining -= n
+		}
+
+		share := float64(n) / float64(acc.Qty)
+		reversal := CommissionEntry{
+			OrderId:    acc.OrderId,
+			EmployeeId: acc.EmployeeId,
+			ProductId:  acc.ProductId,
+			Unit:       acc.Unit,
+			RuleId:     acc.RuleId,
+			Qty:        -n,
+			SaleAmount: -acc.SaleAmount * share,
+			Commission: -acc.Commission * share,
+			IsReversal: true,
+		}
+		if err := insertCommissionEntry(ctx, tx, &reversal); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Statement lists an employee's commission entries booked within [from, to) with totals.
+func (c CommissionModule) Statement(employeeId int, from, to time.Time) (*CommissionStatement, error) {
+	query := `
+			SELECT ` + commissionEntryColumns + `
+			FROM commission_entries
+			WHERE employee_id = $1 AND created_at >= $2 AND created_at < $3
+			ORDER BY created_at, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, employeeId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statement := CommissionStatement{EmployeeId: employeeId, From: from, To: to, Entries: []CommissionEntry{}}
+	for rows.Next() {
+		var e CommissionEntry
+		if err := scanCommissionEntry(rows, &e); err != nil {
+			return nil, err
+		}
+
+		statement.Sales += e.SaleAmount
+		if e.IsReversal {
+			statement.Reversed -= e.Commission
+		} else {
+			statement.Earned += e.Commission
+		}
+		statement.Entries = append(statement.Entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statement.Net = statement.Earned - statement.Reversed
+	return &statement, nil
+}
+
+func commissionRuleFor(ctx context.Context, tx *sql.Tx, productId int) (*CommissionRule, error) {
+	query := `
+			SELECT ` + commissionRuleColumns + `
+			FROM commission_rules
+			LEFT JOIN category_ancestors((SELECT category_id FROM products WHERE id = $1)) ancestors
+				ON ancestors.ancestor_id = commission_rules.category_id
+			WHERE active
+			  AND (product_id = $1
+			       OR (product_id IS NULL AND ancestors.ancestor_id IS NOT NULL)
+			       OR (product_id IS NULL AND category_id IS NULL))
+			ORDER BY product_id IS NOT NULL DESC, category_id IS NOT NULL DESC, ancestors.depth, id DESC
+			LIMIT 1
+			`
+
+	var rule CommissionRule
+	err := scanCommissionRule(tx.QueryRowContext(ctx, query, productId), &rule)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func monthlyVolume(ctx context.Context, tx *sql.Tx, employeeId int, at time.Time) (float64, error) {
+	query := `
+			SELECT COALESCE(SUM(sale_amount), 0)
+			FROM commission_entries
+			WHERE employee_id = $1 AND created_at >= $2 AND created_at < $3
+			`
+	start := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location())
+
+	var volume float64
+	err := tx.QueryRowContext(ctx, query, employeeId, start, start.AddDate(0, 1, 0)).Scan(&volume)
+	return volume, err
+}
+
+const commissionEntryColumns = `id, order_id, employee_id, product_id, unit, rule_id, qty, sale_amount, commission, is_reversal, created_at`
+
+func scanCommissionEntry(row interface{ Scan(...interface{}) error }, e *CommissionEntry) error {
+	return row.Scan(&e.Id, &e.OrderId, &e.EmployeeId, &e.ProductId, &e.Unit, &e.RuleId, &e.Qty,
+		&e.SaleAmount, &e.Commission, &e.IsReversal, &e.CreatedAt)
+}
+
+func insertCommissionEntry(ctx context.Context, tx *sql.Tx, e *CommissionEntry) error {
+	query := `
+			INSERT INTO commission_entries (order_id, employee_id, product_id, unit, rule_id, qty, sale_amount, commission, is_reversal)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, created_at
+			`
+	args := []interface{}{e.OrderId, e.EmployeeId, e.ProductId, e.Unit, e.RuleId, e.Qty, e.SaleAmount, e.Commission, e.IsReversal}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&e.Id, &e.CreatedAt)
+}
+
+// tierRate returns the rate of the highest tier whose threshold the volume has reached.
+func tierRate(tiers []CommissionTier, volume float64) float64 {
+	sorted := append([]CommissionTier(nil), tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinVolume < sorted[j].MinVolume })
+
+	rate := 0.0
+	for _, tier := range sorted {
+		if volume >= tier.MinVolume {
+			rate = tier.Rate
+		}
+	}
+	return rate
+}
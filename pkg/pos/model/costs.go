@@ -0,0 +1,250 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Cost methods: how the cost of goods sold is valued.
+const (
+	CostAverage = "average"
+	CostFIFO    = "fifo"
+)
+
+// applyCost values m and keeps the product's cost layers and unit cost up to date,
+// within tx. It must run before m changes the product's stock.
+//
+// Stock coming in adds a cost layer at m.UnitCost, or at the product's current cost when
+// that is nil. Stock going out consumes layers oldest first and is valued at the current
+// cost (average method) or at the cost of the layers it used (FIFO); whatever goes beyond
+// the layers is valued at the current cost. Transfers only move stock between stores and
+// leave costs alone.
+func applyCost(ctx context.Context, tx *sql.Tx, m *StockMovement) error {
+	if m.Type == MovementTransfer || m.QtyDelta == 0 {
+		return nil
+	}
+
+	var amount, cost int
+	var method string
+	query := `SELECT amount, cost, cost_method FROM products WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, m.ProductId).Scan(&amount, &cost, &method); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, m.ProductId)
+		}
+		return err
+	}
+
+	if m.QtyDelta > 0 {
+		unitCost := cost
+		if m.UnitCost != nil {
+			unitCost = *m.UnitCost
+		}
+		m.UnitCost = &unitCost
+		m.costValue = unitCost * m.QtyDelta
+
+		query = `INSERT INTO cost_layers (product_id, unit_cost, qty_remaining) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, query, m.ProductId, unitCost, m.QtyDelta); err != nil {
+			return err
+		}
+
+		if method == CostAverage {
+			onHand := amount
+			if onHand < 0 {
+				onHand = 0
+			}
+			cost = roundDiv(int64(cost)*int64(onHand)+int64(unitCost)*int64(m.QtyDelta), int64(onHand+m.QtyDelta))
+		}
+	} else {
+		layerValue, layerQty, err := consumeCostLayers(ctx, tx, m.ProductId, -m.QtyDelta)
+		if err != nil {
+			return err
+		}
+
+		m.costValue = cost * -m.QtyDelta
+		if method == CostFIFO {
+			m.costValue = layerValue + cost*(-m.QtyDelta-layerQty)
+		}
+		unitCost := roundDiv(int64(m.costValue), int64(-m.QtyDelta))
+		m.UnitCost = &unitCost
+	}
+
+	if method == CostFIFO {
+		query = `
+				SELECT COALESCE(ROUND(SUM(qty_remaining * unit_cost)::numeric / NULLIF(SUM(qty_remaining), 0)), $2)
+				FROM cost_layers
+				WHERE product_id = $1 AND qty_remaining > 0
+				`
+		if err := tx.QueryRowContext(ctx, query, m.ProductId, cost).Scan(&cost); err != nil {
+			return err
+		}
+	}
+
+	query = `UPDATE products SET cost = $2 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, m.ProductId, cost); err != nil {
+		return err
+	}
+
+	if m.line != nil && m.QtyDelta < 0 {
+		m.line.setCost(m.costValue)
+	}
+
+	return nil
+}
+
+// consumeCostLayers takes qty units out of the product's cost layers, oldest first, and
+// returns the value and quantity taken. The quantity is less than qty when the layers
+// run out.
+func consumeCostLayers(ctx context.Context, tx *sql.Tx, productId, qty int) (value, taken int, err error) {
+	query := `
+			SELECT id, unit_cost, qty_remaining
+			FROM cost_layers
+			WHERE product_id = $1 AND qty_remaining > 0
+			ORDER BY id
+			FOR UPDATE
+			`
+	rows, err := tx.QueryContext(ctx, query, productId)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type layer struct{ id, unitCost, qty int }
+	var used []layer
+	for taken < qty && rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.id, &l.unitCost, &l.qty); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if l.qty > qty-taken {
+			l.qty = qty - taken
+		}
+		taken += l.qty
+		value += l.qty * l.unitCost
+		used = append(used, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	query = `UPDATE cost_layers SET qty_remaining = qty_remaining - $2 WHERE id = $1`
+	for _, l := range used {
+		if _, err := tx.ExecContext(ctx, query, l.id, l.qty); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return value, taken, nil
+}
+
+// roundDiv divides rounding half away from zero. A zero divisor gives zero.
+func roundDiv(a, b int64) int {
+	if b == 0 {
+		return 0
+	}
+	if (a < 0) != (b < 0) {
+		return int((a - b/2) / b)
+	}
+	return int((a + b/2) / b)
+}
+
+// Revalue sets the unit cost of a product and of all its stock on hand, for instance to
+// give stock from before cost tracking a cost.
+func (p ProductModule) Revalue(id, cost int) (*Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var product Product
+	query := `
+			UPDATE products
+			SET cost = $2, updated_at = NOW()
+			WHERE id = $1
+			RETURNING ` + productColumns
+	if err := tx.QueryRowContext(ctx, query, id, cost).Scan(productFields(&product)...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	query = `UPDATE cost_layers SET unit_cost = $2 WHERE product_id = $1 AND qty_remaining > 0`
+	if _, err := tx.ExecContext(ctx, query, id, cost); err != nil {
+		return nil, err
+	}
+
+	return &product, tx.Commit()
+}
+
+// ProductMargin is the gross margin made on one product over a reporting period.
+type ProductMargin struct {
+	ProductId     string   `json:"product_id"`
+	Name          string   `json:"name"`
+	QtySold       int      `json:"qty_sold"`
+	Revenue       int      `json:"revenue"`
+	Cogs          int      `json:"cogs"`
+	Margin        int      `json:"margin"`
+	MarginPercent *float64 `json:"margin_percent"`
+}
+
+// MarginsByProduct reports revenue, cost of goods sold and margin per product for the
+// order lines sold within [from, to), net of refunds made in the same period, highest
+// margin first. The quantity sold is in the product's base unit. Lines sold before cost
+// tracking count as having cost nothing.
+func (o OrderModule) MarginsByProduct(from, to time.Time) ([]ProductMargin, error) {
+	query := `
+        WITH lines AS (
+            SELECT p->>'product_id' AS product_id, COALESCE(p->>'unit', '') AS unit, (p->>'qty')::int AS qty,
+                (p->>'qty')::int * (p->>'price')::int AS revenue, COALESCE((p->>'cogs')::int, 0) AS cogs
+            FROM orders, jsonb_array_elements(orders.products) p
+            WHERE orders.created_at >= $1 AND orders.created_at < $2
+            UNION ALL
+            SELECT p->>'product_id', COALESCE(p->>'unit', ''), -(p->>'qty')::int,
+                -(p->>'qty')::int * (p->>'price')::int, -COALESCE((p->>'cogs')::int, 0)
+            FROM refunds, jsonb_array_elements(refunds.products) p
+            WHERE refunds.created_at >= $1 AND refunds.created_at < $2
+        )
+        SELECT l.product_id, COALESCE(pr.name, ''), SUM(l.qty * COALESCE(u.factor, 1)), SUM(l.revenue), SUM(l.cogs)
+        FROM lines l
+        LEFT JOIN products pr ON pr.id::text = l.product_id
+        LEFT JOIN product_units u ON u.product_id = pr.id AND u.name = l.unit
+        GROUP BY l.product_id, pr.name
+        ORDER BY SUM(l.revenue) - SUM(l.cogs) DESC, l.product_id
+    `
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := o.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	margins := []ProductMargin{}
+	for rows.Next() {
+		var m ProductMargin
+		if err := rows.Scan(&m.ProductId, &m.Name, &m.QtySold, &m.Revenue, &m.Cogs); err != nil {
+			return nil, err
+		}
+		m.Margin = m.Revenue - m.Cogs
+		if m.Revenue > 0 {
+			percent := float64(m.Margin) * 100 / float64(m.Revenue)
+			m.MarginPercent = &percent
+		}
+		margins = append(margins, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return margins, nil
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DisplayIdle     = "idle"
+	DisplayOrder    = "order"
+	DisplayComplete = "complete"
+
+	// An unpaid order stops showing once it has not been touched for displayOpenFor, and a
+	// paid one shows the change due for displayCompleteFor before going back to idle.
+	displayOpenFor     = 30 * time.Minute
+	displayCompleteFor = 30 * time.Second
+)
+
+type DisplayLine struct {
+	ProductId string  `json:"product_id"`
+	Name      string  `json:"name"`
+	Qty       int     `json:"qty"`
+	Price     int     `json:"price"`
+	Discount  float64 `json:"discount"`
+	Total     float64 `json:"total"`
+}
+
+// DisplaySnapshot is what the customer-facing display of a station shows right now.
+type DisplaySnapshot struct {
+	State     string          `json:"state"`
+	ReceiptID string          `json:"receipt_id,omitempty"`
+	Lines     []DisplayLine   `json:"lines,omitempty"`
+	Subtotal  float64         `json:"subtotal"`
+	Discount  float64         `json:"discount"`
+	Total     float64         `json:"total"`
+	Paid      float64         `json:"paid"`
+	ChangeDue float64         `json:"change_due"`
+	Content   json.RawMessage `json:"content,omitempty"`
+}
+
+// NewDisplaySnapshot builds the display state from the station's latest order (nil when
+// it has none) and its idle-state content. A line's discount is the difference between
+// its normal price and what the customer pays for it.
+func NewDisplaySnapshot(order *Order, content json.RawMessage, now time.Time) DisplaySnapshot {
+	paid := order != nil && order.TotalPrice > 0 && order.TotalPaid >= order.TotalPrice
+
+	switch {
+	case order == nil,
+		paid && now.Sub(order.UpdatedAt) > displayCompleteFor,
+		!paid && now.Sub(order.UpdatedAt) > displayOpenFor:
+		return DisplaySnapshot{State: DisplayIdle, Content: content}
+	}
+
+	snapshot := DisplaySnapshot{
+		State:     DisplayOrder,
+		ReceiptID: order.ReceiptID,
+		Lines:     []DisplayLine{},
+		Total:     order.TotalPrice,
+		Paid:      order.TotalPaid,
+	}
+	for _, p := range order.Products {
+		line := DisplayLine{
+			ProductId: p.ProductId,
+			Name:      p.Product.Name,
+			Qty:       p.Qty,
+			Price:     p.Price,
+			Total:     float64(p.Price) * float64(p.Qty),
+		}
+		if normal := float64(p.TotalNormalPrice); normal > line.Total {
+			line.Discount = normal - line.Total
+		}
+		snapshot.Lines = append(snapshot.Lines, line)
+		snapshot.Subtotal += line.Total + line.Discount
+		snapshot.Discount += line.Discount
+	}
+
+	if paid {
+		snapshot.State = DisplayComplete
+		snapshot.ChangeDue = order.TotalReturn
+		if snapshot.ChangeDue == 0 {
+			snapshot.ChangeDue = order.TotalPaid - order.TotalPrice
+		}
+	}
+
+	return snapshot
+}
+
+// GetLatestForStation returns the order the station most recently worked on.
+func (o OrderModule) GetLatestForStation(stationId int) (*Order, error) {
+	query := `
+			SELECT ` + orderColumns + `
+			FROM orders
+			WHERE station_id = $1
+			ORDER BY updated_at DESC, id DESC
+			LIMIT 1
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+	err := scanOrder(o.DB.QueryRowContext(ctx, query, stationId), &order)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &order, nil
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// IdempotencyKey is a client supplied Idempotency-Key together with a fingerprint of the
+// request it was first used for and, once that request finished, the stored response.
+// Keys are only unique within a Scope, the caller that chose them. StatusCode is nil
+// while the original request is still being processed.
+type IdempotencyKey struct {
+	Scope        string
+	Key          string
+	Fingerprint  []byte
+	StatusCode   *int
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type IdempotencyModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+const idempotencyKeyColumns = `scope, key, fingerprint, status_code, content_type, response_body, created_at, expires_at`
+
+func scanIdempotencyKey(row interface{ Scan(...interface{}) error }, k *IdempotencyKey) error {
+	return row.Scan(&k.Scope, &k.Key, &k.Fingerprint, &k.StatusCode, &k.ContentType, &k.ResponseBody, &k.CreatedAt, &k.ExpiresAt)
+}
+
+// Begin claims the key of the scope for a new request; an expired key is claimed afresh.
+// If the key was already claimed and has not expired, the existing record is returned
+// with claimed set to false.
+func (m IdempotencyModule) Begin(scope, key string, fingerprint []byte, ttl time.Duration) (record *IdempotencyKey, claimed bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The key may be released or expire between claiming and reading it, in which case
+	// claiming it is tried again.
+	for attempt := 0; attempt < 3; attempt++ {
+		query := `
+			INSERT INTO idempotency_keys (scope, key, fingerprint, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (scope, key) DO UPDATE
+			SET fingerprint = EXCLUDED.fingerprint, status_code = NULL, content_type = '', response_body = NULL,
+				created_at = NOW(), expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= NOW()
+			RETURNING ` + idempotencyKeyColumns
+		record = &IdempotencyKey{}
+		err = scanIdempotencyKey(m.DB.QueryRowContext(ctx, query, scope, key, fingerprint, time.Now().Add(ttl)), record)
+		if err == nil {
+			return record, true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, false, err
+		}
+
+		query = `
+			SELECT ` + idempotencyKeyColumns + `
+			FROM idempotency_keys
+			WHERE scope = $1 AND key = $2 AND expires_at > NOW()
+			`
+		err = scanIdempotencyKey(m.DB.QueryRowContext(ctx, query, scope, key), record)
+		if err == nil {
+			return record, false, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, err
+}
+
+// Complete stores the response of the request that claimed the key so retries can replay
+// it.
+func (m IdempotencyModule) Complete(scope, key string, statusCode int, contentType string, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, response_body = $5
+		WHERE scope = $1 AND key = $2
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, key, statusCode, contentType, body)
+	return err
+}
+
+// Release forgets a key, allowing the request to be retried from scratch.
+func (m IdempotencyModule) Release(scope, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, key)
+	return err
+}
+
+// DeleteExpired removes all keys whose replay window has passed.
+func (m IdempotencyModule) DeleteExpired() (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
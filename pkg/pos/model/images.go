@@ -0,0 +1,168 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ProductImage is an uploaded picture of a product. URL is the original as uploaded and
+// Thumbnails the URLs of its scaled-down copies by size name. Keys are where the original
+// and the thumbnails are kept in the media storage.
+type ProductImage struct {
+	Id          int               `json:"id"`
+	ProductId   int               `json:"productId"`
+	Position    int               `json:"position"`
+	URL         string            `json:"url"`
+	Thumbnails  map[string]string `json:"thumbnails"`
+	ContentType string            `json:"contentType"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Size        int64             `json:"size"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Keys        []string          `json:"-"`
+}
+
+// ProductImages scans the JSON array productColumns builds from product_images.
+type ProductImages []ProductImage
+
+func (i *ProductImages) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into ProductImages", src)
+	}
+	return json.Unmarshal(data, i)
+}
+
+const imageColumns = `id, product_id, position, url, thumbnails, content_type, width, height, size, created_at, keys`
+
+func scanImage(row interface{ Scan(...interface{}) error }, img *ProductImage) error {
+	var thumbnails []byte
+	err := row.Scan(&img.Id, &img.ProductId, &img.Position, &img.URL, &thumbnails, &img.ContentType,
+		&img.Width, &img.Height, &img.Size, &img.CreatedAt, pq.Array(&img.Keys))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(thumbnails, &img.Thumbnails)
+}
+
+// AddImage stores an image whose files are in the media storage already, after the
+// product's other images. It returns ErrRecordNotFound when the product does not exist.
+// The product is marked as updated, so that it shows up in the catalog changes.
+func (p ProductModule) AddImage(img *ProductImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := touchProduct(ctx, tx, img.ProductId); err != nil {
+		return err
+	}
+
+	thumbnails, err := json.Marshal(img.Thumbnails)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO product_images (product_id, position, url, thumbnails, content_type, width, height, size, keys)
+			VALUES ($1, (SELECT COALESCE(MAX(position), 0) + 1 FROM product_images WHERE product_id = $1),
+				$2, $3, $4, $5, $6, $7, $8)
+			RETURNING id, position, created_at
+			`
+	args := []interface{}{img.ProductId, img.URL, thumbnails, img.ContentType, img.Width, img.Height, img.Size, pq.Array(img.Keys)}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&img.Id, &img.Position, &img.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetImages returns the images of a product in display order.
+func (p ProductModule) GetImages(productId int) ([]ProductImage, error) {
+	query := `
+			SELECT ` + imageColumns + `
+			FROM product_images
+			WHERE product_id = $1
+			ORDER BY position, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []ProductImage{}
+	for rows.Next() {
+		var img ProductImage
+		if err := scanImage(rows, &img); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	return images, rows.Err()
+}
+
+// DeleteImage removes an image of a product and returns it, so that its files can be
+// removed from the media storage. It returns ErrRecordNotFound when the product has no
+// such image.
+func (p ProductModule) DeleteImage(productId, imageId int) (*ProductImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var img ProductImage
+	query := `
+			DELETE FROM product_images
+			WHERE id = $1 AND product_id = $2
+			RETURNING ` + imageColumns
+	if err := scanImage(tx.QueryRowContext(ctx, query, imageId, productId), &img); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err := touchProduct(ctx, tx, productId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// touchProduct marks a product as updated, in the catalog feed too. It returns
+// ErrRecordNotFound when the product does not exist.
+func touchProduct(ctx context.Context, tx *sql.Tx, productId int) error {
+	query := `UPDATE products SET updated_at = CURRENT_TIMESTAMP, sync_version = catalog_sync_version() WHERE id = $1`
+	result, err := tx.ExecContext(ctx, query, productId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,599 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+
+	"github.com/lib/pq"
+)
+
+// Kitchen ticket statuses, in the order a ticket moves through them.
+const (
+	TicketNew        = "new"
+	TicketInProgress = "in_progress"
+	TicketReady      = "ready"
+	TicketServed     = "served"
+)
+
+var ticketFlow = []string{TicketNew, TicketInProgress, TicketReady, TicketServed}
+
+var (
+	// ErrTicketAlreadyServed is returned when bumping a ticket that has been served.
+	ErrTicketAlreadyServed = errors.New("ticket has already been served")
+	// ErrTicketNotBumped is returned when recalling a ticket that is not ready or served.
+	ErrTicketNotBumped = errors.New("only ready or served tickets can be recalled")
+)
+
+// PrepStation is a kitchen area (grill, bar, cold kitchen) that prepares the products
+// of the categories routed to it.
+type PrepStation struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	CategoryIds []int     `json:"category_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type TicketItem struct {
+	ProductId string `json:"product_id"`
+	Name      string `json:"name"`
+	Qty       int    `json:"qty"`
+}
+
+// KitchenTicket is the part of an order that one prep station has to prepare.
+type KitchenTicket struct {
+	Id            int          `json:"id"`
+	OrderId       int          `json:"order_id"`
+	PrepStationId int          `json:"prep_station_id"`
+	Status        string       `json:"status"`
+	Items         []TicketItem `json:"items"`
+	CreatedAt     time.Time    `json:"created_at"`
+	StartedAt     *time.Time   `json:"started_at"`
+	ReadyAt       *time.Time   `json:"ready_at"`
+	ServedAt      *time.Time   `json:"served_at"`
+	RecalledAt    *time.Time   `json:"recalled_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// PrepTime summarises how long a prep station took from a ticket being fired until it
+// was ready.
+type PrepTime struct {
+	PrepStationId  int     `json:"prep_station_id"`
+	Name           string  `json:"name"`
+	Tickets        int     `json:"tickets"`
+	AvgPrepSeconds float64 `json:"avg_prep_seconds"`
+	MaxPrepSeconds float64 `json:"max_prep_seconds"`
+}
+
+type KitchenModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePrepStation(v *validator.Validator, station *PrepStation) {
+	v.Check(station.Name != "", "name", "must be provided")
+	v.Check(len(station.Name) <= 255, "name", "must not be more than 255 bytes long")
+
+	ids := make([]string, len(station.CategoryIds))
+	for i, id := range station.CategoryIds {
+		ids[i] = strconv.Itoa(id)
+	}
+	v.Check(validator.Unique(ids), "category_ids", "must not contain duplicate values")
+}
+
+func (k KitchenModule) CreatePrepStation(station *PrepStation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := k.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+			INSERT INTO prep_stations (name)
+			VALUES ($1)
+			RETURNING id, created_at, updated_at
+			`
+	err = tx.QueryRowContext(ctx, query, station.Name).Scan(&station.Id, &station.CreatedAt, &station.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := setPrepStationCategories(ctx, tx, station); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setPrepStationCategories routes the station's categories to it, taking them away from
+// whichever station they were routed to before.
+func setPrepStationCategories(ctx context.Context, tx *sql.Tx, station *PrepStation) error {
+	if station.CategoryIds == nil {
+		station.CategoryIds = []int{}
+	}
+
+	query := `DELETE FROM prep_station_categories WHERE prep_station_id = $1`
+	if _, err := tx.ExecContext(ctx, query, station.Id); err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO prep_station_categories (category_id, prep_station_id)
+			SELECT unnest($1::int[]), $2
+			ON CONFLICT (category_id) DO UPDATE SET prep_station_id = EXCLUDED.prep_station_id
+			`
+	_, err := tx.ExecContext(ctx, query, pq.Array(station.CategoryIds), station.Id)
+	return err
+}
+
+const prepStationColumns = `
+		id, name, created_at, updated_at,
+		ARRAY(SELECT category_id FROM prep_station_categories WHERE prep_station_id = prep_stations.id ORDER BY category_id)`
+
+func scanPrepStation(row interface{ Scan(...interface{}) error }, station *PrepStation) error {
+	var categoryIds pq.Int64Array
+	err := row.Scan(&station.Id, &station.Name, &station.CreatedAt, &station.UpdatedAt, &categoryIds)
+	if err != nil {
+		return err
+	}
+
+	station.CategoryIds = make([]int, len(categoryIds))
+	for i, id := range categoryIds {
+		station.CategoryIds[i] = int(id)
+	}
+	return nil
+}
+
+func (k KitchenModule) GetPrepStation(id int) (*PrepStation, error) {
+	query := `SELECT ` + prepStationColumns + ` FROM prep_stations WHERE id = $1`
+
+	var station PrepStation
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanPrepStation(k.DB.QueryRowContext(ctx, query, id), &station)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &station, nil
+}
+
+func (k KitchenModule) GetAllPrepStations() ([]PrepStation, error) {
+	query := `SELECT ` + prepStationColumns + ` FROM prep_stations ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := k.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stations := []PrepStation{}
+	for rows.Next() {
+		var station PrepStation
+		if err := scanPrepStation(rows, &station); err != nil {
+			return nil, err
+		}
+		stations = append(stations, station)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stations, nil
+}
+
+func (k KitchenModule) UpdatePrepStation(id int, station *PrepStation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := k.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+			UPDATE prep_stations
+			SET name = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2
+			RETURNING id, created_at, updated_at
+			`
+	err = tx.QueryRowContext(ctx, query, station.Name, id).Scan(&station.Id, &station.CreatedAt, &station.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if err := setPrepStationCategories(ctx, tx, station); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (k KitchenModule) DeletePrepStation(id int) error {
+	query := `DELETE FROM prep_stations WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := k.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+const ticketColumns = `id, order_id, prep_station_id, status, items, created_at, started_at, ready_at, served_at, recalled_at, updated_at`
+
+func scanTicket(row interface{ Scan(...interface{}) error }, ticket *KitchenTicket) error {
+	var items []byte
+	err := row.Scan(&ticket.Id, &ticket.OrderId, &ticket.PrepStationId, &ticket.Status, &items, &ticket.CreatedAt,
+		&ticket.StartedAt, &ticket.ReadyAt, &ticket.ServedAt, &ticket.RecalledAt, &ticket.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(items, &ticket.Items)
+}
+
+// Fire splits the order's lines into tickets, one per prep station, based on the category
+// of each product: the prep station of the category, or of the nearest category above it
+// that is routed. Lines whose categories are not routed to a prep station are not kitchen
+// items and are skipped. Quantities that already went out on earlier tickets are not
+// fired again, so an order can be fired again after products were added to it.
+func (k KitchenModule) Fire(order *Order) ([]KitchenTicket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := k.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialise concurrent fires of the same order.
+	query := `SELECT id FROM orders WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, order.Id).Scan(&order.Id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	fired := make(map[string]int)
+	query = `SELECT items FROM kitchen_tickets WHERE order_id = $1`
+	rows, err := tx.QueryContext(ctx, query, order.Id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var raw []byte
+		var items []TicketItem
+		if err := rows.Scan(&raw); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &items); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for _, item := range items {
+			fired[item.ProductId] += item.Qty
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	productIds := make([]string, 0, len(order.Products))
+	for _, p := range order.Products {
+		productIds = append(productIds, p.ProductId)
+	}
+
+	type route struct {
+		name          string
+		prepStationId int
+	}
+	routes := make(map[string]route)
+	query = `
+			SELECT products.id::text, products.name, route.prep_station_id
+			FROM products
+			INNER JOIN LATERAL (
+				SELECT prep_station_categories.prep_station_id
+				FROM category_ancestors(products.category_id) ancestors
+				INNER JOIN prep_station_categories ON prep_station_categories.category_id = ancestors.ancestor_id
+				ORDER BY ancestors.depth
+				LIMIT 1
+			) route ON TRUE
+			WHERE products.id::text = ANY($1)
+			`
+	rows, err = tx.QueryContext(ctx, query, pq.Array(productIds))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var productId string
+		var rt route
+		var name sql.NullString
+		if err := rows.Scan(&productId, &name, &rt.prepStationId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rt.name = name.String
+		routes[productId] = rt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var stationOrder []int
+	items := make(map[int][]TicketItem)
+	for _, p := range unfired(order.Products, fired) {
+		rt, ok := routes[p.ProductId]
+		if !ok {
+			continue
+		}
+
+		if _, ok := items[rt.prepStationId]; !ok {
+			stationOrder = append(stationOrder, rt.prepStationId)
+		}
+		items[rt.prepStationId] = append(items[rt.prepStationId], TicketItem{ProductId: p.ProductId, Name: rt.name, Qty: p.Qty})
+	}
+
+	query = `
+			INSERT INTO kitchen_tickets (order_id, prep_station_id, items)
+			VALUES ($1, $2, $3)
+			RETURNING ` + ticketColumns
+	tickets := []KitchenTicket{}
+	for _, prepStationId := range stationOrder {
+		itemsJSON, err := json.Marshal(items[prepStationId])
+		if err != nil {
+			return nil, err
+		}
+
+		var ticket KitchenTicket
+		err = scanTicket(tx.QueryRowContext(ctx, query, order.Id, prepStationId, itemsJSON), &ticket)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
+// unfired returns the order lines less the quantities of each product that already went
+// out on tickets, taken off the lines in order. Lines with nothing left to fire are dropped.
+func unfired(lines []OrderProduct, fired map[string]int) []OrderProduct {
+	left := make(map[string]int, len(fired))
+	for productId, qty := range fired {
+		left[productId] = qty
+	}
+
+	var out []OrderProduct
+	for _, p := range lines {
+		if already := left[p.ProductId]; already > 0 {
+			used := min(already, p.Qty)
+			left[p.ProductId] -= used
+			p.Qty -= used
+		}
+		if p.Qty <= 0 {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func (k KitchenModule) GetTicket(id int) (*KitchenTicket, error) {
+	query := `SELECT ` + ticketColumns + ` FROM kitchen_tickets WHERE id = $1`
+
+	var ticket KitchenTicket
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanTicket(k.DB.QueryRowContext(ctx, query, id), &ticket)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &ticket, nil
+}
+
+// GetTickets returns a prep station's tickets in the given statuses, oldest first.
+func (k KitchenModule) GetTickets(prepStationId int, statuses []string) ([]KitchenTicket, error) {
+	query := `
+			SELECT ` + ticketColumns + `
+			FROM kitchen_tickets
+			WHERE prep_station_id = $1 AND status = ANY($2)
+			ORDER BY created_at, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := k.DB.QueryContext(ctx, query, prepStationId, pq.Array(statuses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tickets := []KitchenTicket{}
+	for rows.Next() {
+		var ticket KitchenTicket
+		if err := scanTicket(rows, &ticket); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
+func (k KitchenModule) GetTicketsForOrder(orderId int) ([]KitchenTicket, error) {
+	query := `SELECT ` + ticketColumns + ` FROM kitchen_tickets WHERE order_id = $1 ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := k.DB.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tickets := []KitchenTicket{}
+	for rows.Next() {
+		var ticket KitchenTicket
+		if err := scanTicket(rows, &ticket); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
+// Bump moves a ticket on to its next status and records when that happened.
+func (k KitchenModule) Bump(id int) (*KitchenTicket, error) {
+	ticket, err := k.GetTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	if ticket.Status == TicketServed {
+		return nil, ErrTicketAlreadyServed
+	}
+
+	query := `
+			UPDATE kitchen_tickets
+			SET status = $3,
+				started_at = CASE WHEN $3 = 'in_progress' THEN COALESCE(started_at, NOW()) ELSE started_at END,
+				ready_at = CASE WHEN $3 = 'ready' THEN NOW() ELSE ready_at END,
+				served_at = CASE WHEN $3 = 'served' THEN NOW() ELSE served_at END,
+				updated_at = NOW()
+			WHERE id = $1 AND status = $2
+			RETURNING ` + ticketColumns
+
+	return k.moveTicket(query, ticket, nextTicketStatus(ticket.Status, 1))
+}
+
+// Recall brings a ready or served ticket back to the previous status, for example when
+// a dish was sent back.
+func (k KitchenModule) Recall(id int) (*KitchenTicket, error) {
+	ticket, err := k.GetTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	if ticket.Status != TicketReady && ticket.Status != TicketServed {
+		return nil, ErrTicketNotBumped
+	}
+
+	query := `
+			UPDATE kitchen_tickets
+			SET status = $3,
+				ready_at = CASE WHEN $3 = 'in_progress' THEN NULL ELSE ready_at END,
+				served_at = NULL,
+				recalled_at = NOW(),
+				updated_at = NOW()
+			WHERE id = $1 AND status = $2
+			RETURNING ` + ticketColumns
+
+	return k.moveTicket(query, ticket, nextTicketStatus(ticket.Status, -1))
+}
+
+func (k KitchenModule) moveTicket(query string, ticket *KitchenTicket, status string) (*KitchenTicket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanTicket(k.DB.QueryRowContext(ctx, query, ticket.Id, ticket.Status, status), ticket)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEditConflict
+		}
+		return nil, err
+	}
+
+	return ticket, nil
+}
+
+func nextTicketStatus(status string, step int) string {
+	for i, s := range ticketFlow {
+		if s == status {
+			return ticketFlow[i+step]
+		}
+	}
+	return status
+}
+
+// PrepTimes reports, per prep station, how long tickets fired in [from, to) took to
+// become ready.
+func (k KitchenModule) PrepTimes(from, to time.Time) ([]PrepTime, error) {
+	query := `
+			SELECT prep_stations.id, prep_stations.name, count(kitchen_tickets.id),
+				COALESCE(AVG(EXTRACT(EPOCH FROM kitchen_tickets.ready_at - kitchen_tickets.created_at)), 0),
+				COALESCE(MAX(EXTRACT(EPOCH FROM kitchen_tickets.ready_at - kitchen_tickets.created_at)), 0)
+			FROM prep_stations
+			LEFT JOIN kitchen_tickets ON kitchen_tickets.prep_station_id = prep_stations.id
+				AND kitchen_tickets.ready_at IS NOT NULL
+				AND kitchen_tickets.created_at >= $1 AND kitchen_tickets.created_at < $2
+			GROUP BY prep_stations.id, prep_stations.name
+			ORDER BY prep_stations.id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := k.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []PrepTime{}
+	for rows.Next() {
+		var s PrepTime
+		if err := rows.Scan(&s.PrepStationId, &s.Name, &s.Tickets, &s.AvgPrepSeconds, &s.MaxPrepSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnfired(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []OrderProduct
+		fired map[string]int
+		want  []OrderProduct
+	}{
+		{
+			name:  "first fire sends everything",
+			lines: []OrderProduct{{ProductId: "1", Qty: 2}, {ProductId: "2", Qty: 1}},
+			want:  []OrderProduct{{ProductId: "1", Qty: 2}, {ProductId: "2", Qty: 1}},
+		},
+		{
+			name:  "fire again without changes sends nothing",
+			lines: []OrderProduct{{ProductId: "1", Qty: 2}, {ProductId: "2", Qty: 1}},
+			fired: map[string]int{"1": 2, "2": 1},
+			want:  nil,
+		},
+		{
+			name:  "only the added quantity is sent",
+			lines: []OrderProduct{{ProductId: "1", Qty: 3}, {ProductId: "2", Qty: 1}},
+			fired: map[string]int{"1": 2, "2": 1},
+			want:  []OrderProduct{{ProductId: "1", Qty: 1}},
+		},
+		{
+			name:  "fired quantity is taken off lines in order",
+			lines: []OrderProduct{{ProductId: "1", Qty: 2}, {ProductId: "2", Qty: 1}, {ProductId: "1", Qty: 2}},
+			fired: map[string]int{"1": 3},
+			want:  []OrderProduct{{ProductId: "2", Qty: 1}, {ProductId: "1", Qty: 1}},
+		},
+		{
+			name:  "more fired than ordered sends nothing",
+			lines: []OrderProduct{{ProductId: "1", Qty: 1}},
+			fired: map[string]int{"1": 4},
+			want:  nil,
+		},
+		{
+			name:  "lines without quantity are dropped",
+			lines: []OrderProduct{{ProductId: "1", Qty: 0}, {ProductId: "2", Qty: 1}},
+			want:  []OrderProduct{{ProductId: "2", Qty: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fired := make(map[string]int, len(tt.fired))
+			for productId, qty := range tt.fired {
+				fired[productId] = qty
+			}
+
+			got := unfired(tt.lines, tt.fired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			for productId, qty := range fired {
+				if tt.fired[productId] != qty {
+					t.Errorf("fired quantity of %s changed to %d", productId, tt.fired[productId])
+				}
+			}
+		})
+	}
+}
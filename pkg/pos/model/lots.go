@@ -0,0 +1,334 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+var (
+	// ErrExpiredLot is returned when a sale could only be filled from lots past their
+	// expiry date.
+	ErrExpiredLot = errors.New("lot has expired")
+	// ErrLotsNotTracked is returned when lots are given for a product that does not track them.
+	ErrLotsNotTracked = errors.New("product does not track lots")
+)
+
+// LotAllocation is the part of a stock movement that went into or came out of one lot.
+// Qty has the sign of the movement. ExpiresOn is a YYYY-MM-DD date.
+type LotAllocation struct {
+	LotId     int     `json:"lot_id"`
+	LotNumber string  `json:"lot_number"`
+	ExpiresOn *string `json:"expires_on"`
+	Qty       int     `json:"qty"`
+}
+
+// Lot is one batch of a product held in one store.
+type Lot struct {
+	Id        int       `json:"id"`
+	ProductId int       `json:"product_id"`
+	StoreId   int       `json:"store_id"`
+	LotNumber string    `json:"lot_number"`
+	ExpiresOn *string   `json:"expires_on"`
+	Qty       int       `json:"qty"`
+	Expired   bool      `json:"expired"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExpiringLot is a lot in the expiring-soon report.
+type ExpiringLot struct {
+	Lot
+	ProductName string `json:"product_name"`
+}
+
+// ValidateLot checks the lot a delivery of stock goes into.
+func ValidateLot(v *validator.Validator, key, lotNumber string, expiresOn *string) {
+	v.Check(len(lotNumber) <= 64, key+"lot_number", "must not be more than 64 bytes long")
+	if expiresOn != nil {
+		_, err := time.Parse(time.DateOnly, *expiresOn)
+		v.Check(err == nil, key+"expires_on", "must be a date in YYYY-MM-DD format")
+		v.Check(lotNumber != "", key+"lot_number", "must be provided with an expiry date")
+	}
+}
+
+// applyLots books m into or out of the lots of its product and store, within tx, once m
+// is in the ledger. Products that do not track lots are left alone.
+func applyLots(ctx context.Context, tx *sql.Tx, m *StockMovement) error {
+	var tracked bool
+	query := `SELECT track_lots FROM products WHERE id = $1`
+	if err := tx.QueryRowContext(ctx, query, m.ProductId).Scan(&tracked); err != nil {
+		return err
+	}
+	if !tracked {
+		if len(m.Lots) > 0 {
+			return fmt.Errorf("%w: %d", ErrLotsNotTracked, m.ProductId)
+		}
+		return nil
+	}
+
+	var allocations []LotAllocation
+	var err error
+	if m.QtyDelta > 0 {
+		allocations, err = addToLots(ctx, tx, m)
+	} else {
+		allocations, err = takeFromLots(ctx, tx, m)
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO stock_movement_lots (movement_id, lot_id, qty)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (movement_id, lot_id) DO UPDATE SET qty = stock_movement_lots.qty + EXCLUDED.qty
+			`
+	for _, a := range allocations {
+		if _, err := tx.ExecContext(ctx, query, m.Id, a.LotId, a.Qty); err != nil {
+			return err
+		}
+	}
+
+	m.Lots = allocations
+	return nil
+}
+
+// addToLots puts stock coming in into the lots given with m, then into the lots it
+// originally came out of, if any. Whatever is left goes into the store's first lot to
+// expire that has not expired, or into a lot without a number when there is none.
+func addToLots(ctx context.Context, tx *sql.Tx, m *StockMovement) ([]LotAllocation, error) {
+	wanted := m.Lots
+	if len(wanted) == 0 && m.originType != "" {
+		var err error
+		if wanted, err = originLots(ctx, tx, m); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining := m.QtyDelta
+	allocations := make([]LotAllocation, 0, len(wanted)+1)
+	for _, a := range wanted {
+		if a.Qty > remaining {
+			a.Qty = remaining
+		}
+		if a.Qty <= 0 {
+			continue
+		}
+		if err := addToLot(ctx, tx, m, &a); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+		remaining -= a.Qty
+	}
+
+	if remaining > 0 {
+		a := LotAllocation{Qty: remaining}
+		query := `
+				SELECT lot_number, to_char(expires_on, 'YYYY-MM-DD')
+				FROM lots
+				WHERE product_id = $1 AND store_id = $2 AND qty > 0
+					AND (expires_on IS NULL OR expires_on >= CURRENT_DATE)
+				ORDER BY expires_on NULLS LAST, id
+				LIMIT 1
+				`
+		err := tx.QueryRowContext(ctx, query, m.ProductId, m.StoreId).Scan(&a.LotNumber, &a.ExpiresOn)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err := addToLot(ctx, tx, m, &a); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+
+	return allocations, nil
+}
+
+// addToLot adds a.Qty to the lot a names in m's store, creating the lot if need be. An
+// existing lot keeps its expiry date unless it had none.
+func addToLot(ctx context.Context, tx *sql.Tx, m *StockMovement, a *LotAllocation) error {
+	query := `
+			INSERT INTO lots (product_id, store_id, lot_number, expires_on, qty)
+			VALUES ($1, $2, $3, $4::date, $5)
+			ON CONFLICT (product_id, store_id, lot_number) DO UPDATE
+			SET qty = lots.qty + EXCLUDED.qty, expires_on = COALESCE(lots.expires_on, EXCLUDED.expires_on)
+			RETURNING id, to_char(expires_on, 'YYYY-MM-DD')
+			`
+	return tx.QueryRowContext(ctx, query, m.ProductId, m.StoreId, a.LotNumber, a.ExpiresOn, a.Qty).Scan(&a.LotId, &a.ExpiresOn)
+}
+
+// originLots returns, as positive quantities, the lots that the outgoing movements m
+// reverses took the product out of.
+func originLots(ctx context.Context, tx *sql.Tx, m *StockMovement) ([]LotAllocation, error) {
+	query := `
+			SELECT l.lot_number, to_char(l.expires_on, 'YYYY-MM-DD'), -SUM(a.qty)
+			FROM stock_movement_lots a
+			JOIN lots l ON l.id = a.lot_id
+			JOIN stock_movements sm ON sm.id = a.movement_id
+			WHERE sm.source_type = $1 AND sm.source_id = $2 AND sm.product_id = $3 AND a.qty < 0
+			GROUP BY l.id
+			ORDER BY l.expires_on NULLS LAST, l.id
+			`
+	rows, err := tx.QueryContext(ctx, query, m.originType, m.originId, m.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []LotAllocation
+	for rows.Next() {
+		var a LotAllocation
+		if err := rows.Scan(&a.LotNumber, &a.ExpiresOn, &a.Qty); err != nil {
+			return nil, err
+		}
+		lots = append(lots, a)
+	}
+
+	return lots, rows.Err()
+}
+
+// takeFromLots takes stock going out from the store's lots, first to expire first. Sales
+// pass over expired lots, and fail with ErrExpiredLot when only expired lots could fill
+// them; offline sales that already happened are booked regardless. Stock going out
+// beyond what the lots hold is taken from no lot.
+func takeFromLots(ctx context.Context, tx *sql.Tx, m *StockMovement) ([]LotAllocation, error) {
+	query := `
+			SELECT id, lot_number, to_char(expires_on, 'YYYY-MM-DD'), qty, COALESCE(expires_on < CURRENT_DATE, FALSE)
+			FROM lots
+			WHERE product_id = $1 AND store_id = $2 AND qty > 0
+			ORDER BY expires_on NULLS LAST, id
+			FOR UPDATE
+			`
+	rows, err := tx.QueryContext(ctx, query, m.ProductId, m.StoreId)
+	if err != nil {
+		return nil, err
+	}
+
+	skipExpired := m.Type == MovementSale && !m.offline
+	need := -m.QtyDelta
+	var allocations []LotAllocation
+	var expired *LotAllocation
+	for need > 0 && rows.Next() {
+		var a LotAllocation
+		var isExpired bool
+		if err := rows.Scan(&a.LotId, &a.LotNumber, &a.ExpiresOn, &a.Qty, &isExpired); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if isExpired && skipExpired {
+			if expired == nil {
+				expired = &a
+			}
+			continue
+		}
+		if a.Qty > need {
+			a.Qty = need
+		}
+		need -= a.Qty
+		a.Qty = -a.Qty
+		allocations = append(allocations, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if need > 0 && expired != nil {
+		return nil, fmt.Errorf("%w: product %d lot %q expired on %s", ErrExpiredLot, m.ProductId, expired.LotNumber, *expired.ExpiresOn)
+	}
+
+	query = `UPDATE lots SET qty = qty + $2 WHERE id = $1`
+	for _, a := range allocations {
+		if _, err := tx.ExecContext(ctx, query, a.LotId, a.Qty); err != nil {
+			return nil, err
+		}
+	}
+
+	return allocations, nil
+}
+
+const lotColumns = `lots.id, lots.product_id, lots.store_id, lots.lot_number, to_char(lots.expires_on, 'YYYY-MM-DD'), lots.qty,
+		COALESCE(lots.expires_on < CURRENT_DATE, FALSE), lots.created_at`
+
+func lotFields(lot *Lot) []interface{} {
+	return []interface{}{&lot.Id, &lot.ProductId, &lot.StoreId, &lot.LotNumber, &lot.ExpiresOn, &lot.Qty, &lot.Expired, &lot.CreatedAt}
+}
+
+// GetLots returns the lots of a product that still hold stock, first to expire first,
+// optionally in one store only (storeId > 0).
+func (s StockModule) GetLots(productId, storeId int) ([]Lot, error) {
+	query := `
+			SELECT ` + lotColumns + `
+			FROM lots
+			WHERE product_id = $1 AND (store_id = $2 OR $2 = 0) AND qty > 0
+			ORDER BY expires_on NULLS LAST, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, productId, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := []Lot{}
+	for rows.Next() {
+		var lot Lot
+		if err := rows.Scan(lotFields(&lot)...); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+// GetExpiring returns the lots holding stock that expire within the given number of days,
+// including those that already expired, first to expire first, optionally in one store
+// only (storeId > 0).
+func (s StockModule) GetExpiring(days, storeId int) ([]ExpiringLot, error) {
+	query := `
+			SELECT ` + lotColumns + `, products.name
+			FROM lots
+			JOIN products ON products.id = lots.product_id
+			WHERE lots.qty > 0 AND lots.expires_on < CURRENT_DATE + $1::int + 1 AND (lots.store_id = $2 OR $2 = 0)
+			ORDER BY lots.expires_on, lots.id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, days, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := []ExpiringLot{}
+	for rows.Next() {
+		var lot ExpiringLot
+		if err := rows.Scan(append(lotFields(&lot.Lot), &lot.ProductName)...); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+// lotOrigin sets the source whose outgoing movements m puts stock back from.
+func (m *StockMovement) lotOrigin(sourceType string, sourceId int) {
+	m.originType, m.originId = sourceType, strconv.Itoa(sourceId)
+}
@@ -16,12 +16,29 @@ var (
 )
 
 type Models struct {
-	Employee    EmployeeModel
-	Product     ProductModule
-	Category    CategoryModule
-	Order       OrderModule
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Employee     EmployeeModel
+	Product      ProductModule
+	Category     CategoryModule
+	Order        OrderModule
+	Tokens       TokenModel
+	Permissions  PermissionModel
+	Shift        ShiftModule
+	CashMovement CashMovementModule
+	TimeClock    TimeClockModule
+	Refund       RefundModule
+	Commission   CommissionModule
+	Station      StationModule
+	Idempotency  IdempotencyModule
+	Catalog      CatalogModule
+	Kitchen      KitchenModule
+	Stock        StockModule
+	Purchasing   PurchasingModule
+	Stocktake    StocktakeModule
+	Store        StoreModule
+	Transfer     TransferModule
+	Recipe       RecipeModule
+	Price        PriceModule
+	PriceList    PriceListModule
 }
 
 func NewModels(db *sql.DB) Models {
@@ -58,5 +75,90 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Shift: ShiftModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		CashMovement: CashMovementModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		TimeClock: TimeClockModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Refund: RefundModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Commission: CommissionModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Station: StationModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Idempotency: IdempotencyModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Catalog: CatalogModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Kitchen: KitchenModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Stock: StockModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Purchasing: PurchasingModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Stocktake: StocktakeModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Store: StoreModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Transfer: TransferModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Recipe: RecipeModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Price: PriceModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		PriceList: PriceListModule{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 	}
 }
@@ -5,13 +5,35 @@ import (
 )
 
 type OrderProduct struct {
-	Id               int       `json:"id"`
-	OrderId          string    `json:"order_id"`
-	ProductId        string    `json:"product_id"`
-	Qty              int       `json:"qty"`
-	Price            int       `json:"price"`
-	TotalNormalPrice int       `json:"total_normal_price"`
-	Product          Product   `json:"product"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	Id               int              `json:"id"`
+	OrderId          string           `json:"order_id"`
+	ProductId        string           `json:"product_id"`
+	Qty              int              `json:"qty"`
+	Price            int              `json:"price"`
+	TotalNormalPrice int              `json:"total_normal_price"`
+	SellerId         *int             `json:"seller_id,omitempty"`
+	UnitCost         *int             `json:"unit_cost,omitempty"`
+	Cogs             *int             `json:"cogs,omitempty"`
+	Serials          []string         `json:"serials,omitempty"`
+	Unit             string           `json:"unit,omitempty"`
+	PriceListId      *int             `json:"price_list_id,omitempty"`
+	Ingredients      []LineIngredient `json:"ingredients,omitempty"`
+	Product          Product          `json:"product"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+}
+
+// LineIngredient is how much of an ingredient went into one base unit of an order line's
+// product when it was sold. Qty is in Unit, or in the ingredient's base unit when that is
+// empty.
+type LineIngredient struct {
+	IngredientId int    `json:"ingredient_id"`
+	Qty          int    `json:"qty"`
+	Unit         string `json:"unit,omitempty"`
+}
+
+// setCost sets the cost of goods sold of the line, and its unit cost per unit of the line.
+func (p *OrderProduct) setCost(cogs int) {
+	unitCost := roundDiv(int64(cogs), int64(p.Qty))
+	p.UnitCost, p.Cogs = &unitCost, &cogs
 }
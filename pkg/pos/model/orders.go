@@ -4,10 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 )
 
+// ErrOrderRefunded is returned when voiding an order that has refunds.
+var ErrOrderRefunded = errors.New("an order with refunds cannot be voided")
+
 type Order struct {
 	Id          int            `json:"id"`
 	EmployeeID  int            `json:"employee_id"`
@@ -15,9 +19,20 @@ type Order struct {
 	TotalPaid   float64        `json:"total_paid"`
 	TotalReturn float64        `json:"total_return"`
 	ReceiptID   string         `json:"receipt_id"`
+	ShiftId     *int           `json:"shift_id"`
+	StationId   *int           `json:"station_id"`
+	StoreId     int            `json:"store_id"`
 	Products    []OrderProduct `json:"products"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	// CustomerGroup, such as wholesale or staff, selects the price lists that apply to
+	// the order. PriceListId is the list that priced the order when it was created.
+	CustomerGroup string    `json:"customer_group"`
+	PriceListId   *int      `json:"price_list_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// ClientUUID and LocalCreatedAt are set by registers for orders created offline.
+	ClientUUID     *string    `json:"client_uuid,omitempty"`
+	LocalCreatedAt *time.Time `json:"local_created_at,omitempty"`
+	SyncedAt       *time.Time `json:"synced_at,omitempty"`
 }
 
 type OrderModule struct {
@@ -26,51 +41,127 @@ type OrderModule struct {
 	ErrorLog *log.Logger
 }
 
-func (o OrderModule) Create(order *Order) error {
+// Create stores the order and takes its lines out of stock in the order's store, or in
+// the default store when StoreId is zero. The lines get their cost of goods sold.
+func (o OrderModule) Create(order *Order) ([]*StockMovement, error) {
 	query := `
-			INSERT INTO orders (employee_id, total_price, total_paid, total_return, receipt_id, created_at, updated_at, products)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-				RETURNING id
+			INSERT INTO orders (employee_id, total_price, total_paid, total_return, receipt_id, created_at, updated_at, products, shift_id, station_id, store_id,
+				customer_group, price_list_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE(NULLIF($11, 0), (SELECT id FROM stores WHERE is_default)), $12, $13)
+				RETURNING id, store_id
 			`
 	// Serialize products slice to JSON
 	productsJSON, err := json.Marshal(order.Products)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID, order.CreatedAt, order.UpdatedAt, productsJSON}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = time.Now()
+	}
+	if order.UpdatedAt.IsZero() {
+		order.UpdatedAt = order.CreatedAt
+	}
+
+	args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID, order.CreatedAt, order.UpdatedAt, productsJSON, order.ShiftId, order.StationId, order.StoreId,
+		order.CustomerGroup, order.PriceListId}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	return o.DB.QueryRowContext(ctx, query, args...).Scan(&order.Id)
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&order.Id, &order.StoreId); err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, ErrUnknownStore
+		}
+		return nil, err
+	}
+
+	movements, err := OrderMovements(MovementSale, -1, order, order.employee(), "", order.Products)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, err
+	}
+	if err := saveOrderProducts(ctx, tx, order); err != nil {
+		return nil, err
+	}
+	if err := accrueCommissions(ctx, tx, order, order.Products); err != nil {
+		return nil, err
+	}
+
+	return movements, tx.Commit()
+}
+
+// saveOrderProducts rewrites the order's lines within tx, once recording the sale
+// movements has set their cost of goods sold.
+func saveOrderProducts(ctx context.Context, tx *sql.Tx, order *Order) error {
+	productsJSON, err := json.Marshal(order.Products)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE orders SET products = $2 WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, order.Id, productsJSON)
+	return err
+}
+
+// employee returns the ID of the employee who rang up the order, nil if unknown.
+func (order *Order) employee() *int {
+	if order.EmployeeID == 0 {
+		return nil
+	}
+	id := order.EmployeeID
+	return &id
+}
+
+const orderColumns = `id, employee_id, total_price, total_paid, total_return, receipt_id, created_at, updated_at, products,
+        shift_id, station_id, store_id, client_uuid, local_created_at, synced_at, customer_group, price_list_id`
+
+func scanOrder(row interface{ Scan(...interface{}) error }, order *Order) error {
+	var productsJSON []byte
+	err := row.Scan(&order.Id, &order.EmployeeID, &order.TotalPrice, &order.TotalPaid,
+		&order.TotalReturn, &order.ReceiptID, &order.CreatedAt, &order.UpdatedAt, &productsJSON,
+		&order.ShiftId, &order.StationId, &order.StoreId, &order.ClientUUID, &order.LocalCreatedAt, &order.SyncedAt,
+		&order.CustomerGroup, &order.PriceListId)
+	if err != nil {
+		return err
+	}
+
+	if productsJSON != nil {
+		return json.Unmarshal(productsJSON, &order.Products)
+	}
+	return nil
 }
 
 func (o OrderModule) Get(id int) (*Order, error) {
 	query := `
-        SELECT * FROM orders 
+        SELECT ` + orderColumns + `
+        FROM orders
         WHERE id = $1
     `
 	var order Order
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := o.DB.QueryRowContext(ctx, query, id)
-	var productsJSON []byte
-	err := row.Scan(&order.Id, &order.EmployeeID, &order.TotalPrice, &order.TotalPaid,
-		&order.TotalReturn, &order.ReceiptID, &order.CreatedAt, &order.UpdatedAt, &productsJSON)
-
+	err := scanOrder(o.DB.QueryRowContext(ctx, query, id), &order)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := json.Unmarshal(productsJSON, &order.Products); err != nil {
-		return nil, err
-	}
-
 	return &order, nil
 }
 
 func (o OrderModule) GetAll() (*[]Order, error) {
-	query := `SELECT * FROM orders`
+	query := `
+        SELECT ` + orderColumns + `
+        FROM orders
+    `
 
 	var orders []Order
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -84,18 +175,9 @@ func (o OrderModule) GetAll() (*[]Order, error) {
 
 	for rows.Next() {
 		var ord Order
-		var productsJSON []byte
-
-		err := rows.Scan(&ord.Id, &ord.EmployeeID, &ord.TotalPrice, &ord.TotalPaid,
-			&ord.TotalReturn, &ord.ReceiptID, &ord.CreatedAt, &ord.UpdatedAt, &productsJSON)
-		if err != nil {
+		if err := scanOrder(rows, &ord); err != nil {
 			return nil, err
 		}
-
-		if err := json.Unmarshal(productsJSON, &ord.Products); err != nil {
-			return nil, err
-		}
-
 		orders = append(orders, ord)
 	}
 
@@ -106,34 +188,227 @@ func (o OrderModule) GetAll() (*[]Order, error) {
 	return &orders, nil
 }
 
-func (o OrderModule) Update(id int, order *Order) error {
-	query := `
+// LineChange lists the lines an update adds to or removes from an order. Added lines
+// must be the order's own copies, so that their sale sets their cost of goods sold.
+type LineChange struct {
+	Added   []OrderProduct
+	Removed []OrderProduct
+}
+
+// Update saves a change to the lines of an order read earlier, taking added lines out of
+// stock and putting removed ones back, less what refunds already brought back, and
+// booking or reversing the commission on them. It returns ErrEditConflict when the order
+// changed since it was read.
+func (o OrderModule) Update(id int, order *Order, lines LineChange, employeeId *int) ([]*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var updatedAt time.Time
+	query := `SELECT updated_at FROM orders WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&updatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if !updatedAt.Equal(order.UpdatedAt) {
+		return nil, ErrEditConflict
+	}
+
+	refunded, err := refundedQty(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	removed := netRefunds(lines.Removed, order.Products, refunded)
+
+	movements, err := OrderMovements(MovementSale, -1, order, employeeId, "", lines.Added)
+	if err != nil {
+		return nil, err
+	}
+	restock, err := OrderMovements(MovementSale, 1, order, employeeId, "line removed", removed)
+	if err != nil {
+		return nil, err
+	}
+	movements = append(movements, restock...)
+
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, err
+	}
+
+	productsJSON, err := json.Marshal(order.Products)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
         UPDATE orders
         SET employee_id = $1, total_price = $2, total_paid = $3, total_return = $4, receipt_id = $5, products = $6, updated_at = $7
         WHERE id = $8
         RETURNING updated_at
     `
+	args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID, productsJSON, time.Now(), id}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&order.UpdatedAt); err != nil {
+		return nil, err
+	}
 
-	productsJSON, err := json.Marshal(order.Products)
-	if err != nil {
-		return err
+	if err := accrueCommissions(ctx, tx, order, lines.Added); err != nil {
+		return nil, err
+	}
+	for _, p := range removed {
+		if p.Qty == 0 {
+			continue
+		}
+		if err := reverseCommissions(ctx, tx, id, RefundKey{p.ProductId, p.Unit}, p.Qty); err != nil {
+			return nil, err
+		}
 	}
 
-	args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID, productsJSON, time.Now(), id}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return movements, tx.Commit()
+}
+
+// Delete voids the order, putting back in stock whatever was sold and reversing the
+// commission earned on it. An order with refunds cannot be voided, since the refunds were
+// paid out and stay on record; the rest of it can be refunded instead.
+func (o OrderModule) Delete(id int, employeeId *int) ([]*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return o.DB.QueryRowContext(ctx, query, args...).Scan(&order.UpdatedAt)
-}
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-func (o OrderModule) Delete(id int) error {
-	query := `
+	// Refunds lock the order too, so none can slip in between.
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE id = $1 FOR UPDATE`
+	var order Order
+	if err := scanOrder(tx.QueryRowContext(ctx, query, id), &order); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	var refunded bool
+	query = `SELECT EXISTS (SELECT 1 FROM refunds WHERE order_id = $1)`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&refunded); err != nil {
+		return nil, err
+	}
+	if refunded {
+		return nil, ErrOrderRefunded
+	}
+
+	movements, err := OrderMovements(MovementSale, 1, &order, employeeId, "order voided", order.Products)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
 			DELETE FROM orders 
 			WHERE id = $1
 			`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return nil, err
+	}
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, err
+	}
+	if err := reverseCommissions(ctx, tx, id, RefundKey{}, 0); err != nil {
+		return nil, err
+	}
+
+	return movements, tx.Commit()
+}
+
+// netRefunds returns lines less the units that refunds already brought back, counted per
+// product and unit. Refunds count first against kept, the lines that stay on the order,
+// and only what those cannot cover against lines. A line some of which came back drops
+// its serials, since which of its units they were is not known.
+func netRefunds(lines, kept []OrderProduct, refunded map[RefundKey]int) []OrderProduct {
+	left := make(map[RefundKey]int, len(refunded))
+	for key, qty := range refunded {
+		left[key] = qty
+	}
+	for _, p := range kept {
+		key := RefundKey{p.ProductId, p.Unit}
+		left[key] -= min(left[key], p.Qty)
+	}
+
+	net := make([]OrderProduct, 0, len(lines))
+	for _, p := range lines {
+		key := RefundKey{p.ProductId, p.Unit}
+		if r := min(left[key], p.Qty); r > 0 {
+			left[key] -= r
+			p.Qty -= r
+			p.Serials = nil
+		}
+		net = append(net, p)
+	}
+	return net
+}
+
+// StationSales is the sales total of one register over a reporting period.
+type StationSales struct {
+	StationId  *int    `json:"station_id"`
+	Name       string  `json:"name"`
+	OrderCount int     `json:"order_count"`
+	SalesTotal float64 `json:"sales_total"`
+	Refunds    float64 `json:"refunds"`
+	Cogs       float64 `json:"cogs"`
+	Margin     float64 `json:"margin"`
+}
+
+// SalesByStation breaks down orders and refunds created within [from, to) by register.
+// Orders that were not rung up on a station are reported under a nil station ID. Cost of
+// goods sold and margin are net of refunds.
+func (o OrderModule) SalesByStation(from, to time.Time) ([]StationSales, error) {
+	query := `
+        SELECT s.station_id, COALESCE(st.name, ''), s.order_count, s.sales_total, COALESCE(r.refunds, 0),
+            s.cogs - COALESCE(r.cogs, 0)
+        FROM (
+            SELECT station_id, count(*) AS order_count, COALESCE(SUM(total_price), 0) AS sales_total,
+                COALESCE(SUM(lines_cogs(products)), 0) AS cogs
+            FROM orders
+            WHERE created_at >= $1 AND created_at < $2
+            GROUP BY station_id
+        ) s
+        LEFT JOIN (
+            SELECT station_id, SUM(amount) AS refunds, COALESCE(SUM(lines_cogs(products)), 0) AS cogs
+            FROM refunds
+            WHERE created_at >= $1 AND created_at < $2
+            GROUP BY station_id
+        ) r ON r.station_id IS NOT DISTINCT FROM s.station_id
+        LEFT JOIN stations st ON st.id = s.station_id
+        ORDER BY s.station_id NULLS FIRST
+    `
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := o.DB.ExecContext(ctx, query, id)
-	return err
+	rows, err := o.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sales := []StationSales{}
+	for rows.Next() {
+		var s StationSales
+		if err := rows.Scan(&s.StationId, &s.Name, &s.OrderCount, &s.SalesTotal, &s.Refunds, &s.Cogs); err != nil {
+			return nil, err
+		}
+		s.Margin = s.SalesTotal - s.Refunds - s.Cogs
+		sales = append(sales, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sales, nil
 }
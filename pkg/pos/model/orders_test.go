@@ -0,0 +1,79 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetRefunds(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []OrderProduct
+		kept     []OrderProduct
+		refunded map[RefundKey]int
+		want     []OrderProduct
+	}{
+		{
+			name:  "nothing refunded",
+			lines: []OrderProduct{{ProductId: "1", Qty: 3, Serials: []string{"A"}}},
+			want:  []OrderProduct{{ProductId: "1", Qty: 3, Serials: []string{"A"}}},
+		},
+		{
+			name:     "refund taken off the line",
+			lines:    []OrderProduct{{ProductId: "1", Qty: 3, Serials: []string{"A", "B", "C"}}},
+			refunded: map[RefundKey]int{{"1", ""}: 1},
+			want:     []OrderProduct{{ProductId: "1", Qty: 2}},
+		},
+		{
+			name:     "fully refunded line left empty",
+			lines:    []OrderProduct{{ProductId: "1", Qty: 2}},
+			refunded: map[RefundKey]int{{"1", ""}: 5},
+			want:     []OrderProduct{{ProductId: "1", Qty: 0}},
+		},
+		{
+			name:     "refund spread over lines of the same product",
+			lines:    []OrderProduct{{ProductId: "1", Qty: 2}, {ProductId: "1", Qty: 2}},
+			refunded: map[RefundKey]int{{"1", ""}: 3},
+			want:     []OrderProduct{{ProductId: "1", Qty: 0}, {ProductId: "1", Qty: 1}},
+		},
+		{
+			name:     "refund in another unit left alone",
+			lines:    []OrderProduct{{ProductId: "1", Qty: 2, Unit: "case"}, {ProductId: "1", Qty: 2}},
+			refunded: map[RefundKey]int{{"1", ""}: 1},
+			want:     []OrderProduct{{ProductId: "1", Qty: 2, Unit: "case"}, {ProductId: "1", Qty: 1}},
+		},
+		{
+			name:     "kept lines absorb refunds first",
+			lines:    []OrderProduct{{ProductId: "1", Qty: 2}},
+			kept:     []OrderProduct{{ProductId: "1", Qty: 1}},
+			refunded: map[RefundKey]int{{"1", ""}: 2},
+			want:     []OrderProduct{{ProductId: "1", Qty: 1}},
+		},
+		{
+			name:     "kept lines absorb all refunds",
+			lines:    []OrderProduct{{ProductId: "1", Qty: 2, Serials: []string{"A", "B"}}},
+			kept:     []OrderProduct{{ProductId: "1", Qty: 4}},
+			refunded: map[RefundKey]int{{"1", ""}: 3},
+			want:     []OrderProduct{{ProductId: "1", Qty: 2, Serials: []string{"A", "B"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refunded := make(map[RefundKey]int, len(tt.refunded))
+			for key, qty := range tt.refunded {
+				refunded[key] = qty
+			}
+
+			got := netRefunds(tt.lines, tt.kept, tt.refunded)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			for key, qty := range refunded {
+				if tt.refunded[key] != qty {
+					t.Errorf("refunded quantity of %v changed to %d", key, tt.refunded[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+var (
+	// ErrDuplicatePriceList is returned when a price list would get the name of another one.
+	ErrDuplicatePriceList = errors.New("a price list with this name already exists")
+	// ErrUnknownCategory is returned when something refers to a category that does not exist.
+	ErrUnknownCategory = errors.New("category does not exist")
+	// ErrUnknownStation is returned when something refers to a station that does not exist.
+	ErrUnknownStation = errors.New("station does not exist")
+)
+
+// PriceWindow is a weekly period a price list is active in, from Start up to End (both
+// "HH:MM") on each of Days (0 is Sunday), or on every day when Days is empty. A window
+// whose End is not after its Start runs past midnight into the next day. Windows are in
+// the server's local time zone, so the server runs with TZ set to the stores' zone.
+type PriceWindow struct {
+	Days  []int  `json:"days"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// contains reports whether t, in its own location, falls within the window.
+func (w PriceWindow) contains(t time.Time) bool {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	day := int(t.Weekday())
+	switch {
+	case from < to && minute >= from && minute < to:
+	case from >= to && minute >= from:
+	case from >= to && minute < to:
+		// Past midnight: the window opened the day before.
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// PriceListItem overrides the price of a product, or of every product of a category,
+// with either a fixed Price or a DiscountPercent off the product's own price. Prices are
+// per base unit.
+type PriceListItem struct {
+	Id              int      `json:"id"`
+	ProductId       *int     `json:"product_id"`
+	CategoryId      *int     `json:"category_id"`
+	Price           *int     `json:"price"`
+	DiscountPercent *float64 `json:"discount_percent"`
+}
+
+// PriceList is a named set of prices, such as wholesale, staff or happy hour. It applies
+// to orders of one of its CustomerGroups at one of its stations, or to any when it lists
+// none, while one of its Schedule windows is open, or always when it has none. Of the
+// lists that apply, the one with the highest Priority prices the order, and the oldest
+// one on ties. An item for a product wins over an item for its category, and an item for
+// a category over one for a category further up; products the list has no item for keep
+// their own price.
+type PriceList struct {
+	Id             int             `json:"id"`
+	Name           string          `json:"name"`
+	Priority       int             `json:"priority"`
+	Active         bool            `json:"active"`
+	CustomerGroups []string        `json:"customer_groups"`
+	StationIds     []int64         `json:"station_ids"`
+	Schedule       []PriceWindow   `json:"schedule"`
+	Items          []PriceListItem `json:"items"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+type PriceListModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePriceList(v *validator.Validator, list *PriceList) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 64, "name", "must not be more than 64 bytes long")
+
+	v.Check(validator.Unique(list.CustomerGroups), "customer_groups", "must not contain duplicate values")
+	for _, group := range list.CustomerGroups {
+		v.Check(group != "", "customer_groups", "must not contain empty groups")
+		v.Check(len(group) <= 32, "customer_groups", "must not contain groups more than 32 bytes long")
+	}
+
+	stations := make(map[int64]bool)
+	for _, id := range list.StationIds {
+		v.Check(id > 0, "station_ids", "must contain station IDs")
+		v.Check(!stations[id], "station_ids", "must not contain duplicate values")
+		stations[id] = true
+	}
+
+	for i, window := range list.Schedule {
+		key := fmt.Sprintf("schedule[%d]", i)
+		_, startErr := time.Parse("15:04", window.Start)
+		_, endErr := time.Parse("15:04", window.End)
+		v.Check(startErr == nil, key+".start", "must be a time in HH:MM format")
+		v.Check(endErr == nil, key+".end", "must be a time in HH:MM format")
+		v.Check(window.Start != window.End, key+".end", "must not be the start")
+		for _, day := range window.Days {
+			v.Check(day >= 0 && day <= 6, key+".days", "must contain days from 0 (Sunday) to 6 (Saturday)")
+		}
+	}
+
+	products := make(map[int]bool)
+	categories := make(map[int]bool)
+	for i, item := range list.Items {
+		key := fmt.Sprintf("items[%d]", i)
+		v.Check((item.ProductId == nil) != (item.CategoryId == nil), key, "must have either a product_id or a category_id")
+		v.Check((item.Price == nil) != (item.DiscountPercent == nil), key, "must have either a price or a discount_percent")
+		v.Check(item.Price == nil || *item.Price >= 0, key+".price", "must not be negative")
+		v.Check(item.DiscountPercent == nil || (*item.DiscountPercent > 0 && *item.DiscountPercent <= 100),
+			key+".discount_percent", "must be greater than 0 and at most 100")
+		if item.ProductId != nil {
+			v.Check(!products[*item.ProductId], key+".product_id", "must not be listed twice")
+			products[*item.ProductId] = true
+		}
+		if item.CategoryId != nil {
+			v.Check(!categories[*item.CategoryId], key+".category_id", "must not be listed twice")
+			categories[*item.CategoryId] = true
+		}
+	}
+}
+
+// activeAt reports whether the list's schedule is open at t, in the server's local time
+// whatever zone t was given in.
+func (list *PriceList) activeAt(t time.Time) bool {
+	if len(list.Schedule) == 0 {
+		return true
+	}
+	for _, window := range list.Schedule {
+		if window.contains(t.Local()) {
+			return true
+		}
+	}
+	return false
+}
+
+const priceListColumns = `id, name, priority, active, customer_groups,
+		ARRAY(SELECT station_id FROM price_list_stations WHERE price_list_id = price_lists.id ORDER BY station_id),
+		schedule, created_at, updated_at`
+
+func scanPriceList(row interface{ Scan(...interface{}) error }, list *PriceList) error {
+	var scheduleJSON []byte
+	err := row.Scan(&list.Id, &list.Name, &list.Priority, &list.Active, pq.Array(&list.CustomerGroups),
+		pq.Array(&list.StationIds), &scheduleJSON, &list.CreatedAt, &list.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if list.CustomerGroups == nil {
+		list.CustomerGroups = []string{}
+	}
+	if list.StationIds == nil {
+		list.StationIds = []int64{}
+	}
+	return json.Unmarshal(scheduleJSON, &list.Schedule)
+}
+
+// priceListItems returns the items of a price list, products before categories.
+func priceListItems(ctx context.Context, q queryer, listId int) ([]PriceListItem, error) {
+	query := `
+			SELECT id, product_id, category_id, price, discount_percent
+			FROM price_list_items
+			WHERE price_list_id = $1
+			ORDER BY product_id IS NULL, product_id, category_id
+			`
+	rows, err := q.QueryContext(ctx, query, listId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PriceListItem{}
+	for rows.Next() {
+		var item PriceListItem
+		if err := rows.Scan(&item.Id, &item.ProductId, &item.CategoryId, &item.Price, &item.DiscountPercent); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// savePriceList writes the list's stations, items and schedule, replacing what it had.
+func savePriceList(ctx context.Context, tx *sql.Tx, list *PriceList) error {
+	query := `DELETE FROM price_list_stations WHERE price_list_id = $1`
+	if _, err := tx.ExecContext(ctx, query, list.Id); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO price_list_stations (price_list_id, station_id) VALUES ($1, $2)`
+	for _, stationId := range list.StationIds {
+		if _, err := tx.ExecContext(ctx, query, list.Id, stationId); err != nil {
+			if isForeignKeyViolation(err) {
+				return fmt.Errorf("%w: %d", ErrUnknownStation, stationId)
+			}
+			return err
+		}
+	}
+
+	query = `DELETE FROM price_list_items WHERE price_list_id = $1`
+	if _, err := tx.ExecContext(ctx, query, list.Id); err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO price_list_items (price_list_id, product_id, category_id, price, discount_percent)
+			VALUES ($1, $2, $3, $4, $5)
+			`
+	for _, item := range list.Items {
+		_, err := tx.ExecContext(ctx, query, list.Id, item.ProductId, item.CategoryId, item.Price, item.DiscountPercent)
+		if isForeignKeyViolation(err) {
+			if item.ProductId != nil {
+				return fmt.Errorf("%w: %d", ErrUnknownProduct, *item.ProductId)
+			}
+			return fmt.Errorf("%w: %d", ErrUnknownCategory, *item.CategoryId)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	list.Items, err = priceListItems(ctx, tx, list.Id)
+	return err
+}
+
+// prepare fills in the empty lists of a price list about to be saved and returns its
+// schedule as JSON.
+func (list *PriceList) prepare() ([]byte, error) {
+	if list.CustomerGroups == nil {
+		list.CustomerGroups = []string{}
+	}
+	if list.StationIds == nil {
+		list.StationIds = []int64{}
+	}
+	if list.Schedule == nil {
+		list.Schedule = []PriceWindow{}
+	}
+	return json.Marshal(list.Schedule)
+}
+
+func isDuplicatePriceList(err error) bool {
+	return isUniqueViolation(err, "price_lists_name_key")
+}
+
+func (m PriceListModule) Create(list *PriceList) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	scheduleJSON, err := list.prepare()
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO price_lists (name, priority, active, customer_groups, schedule)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at, updated_at
+			`
+	args := []interface{}{list.Name, list.Priority, list.Active, pq.Array(list.CustomerGroups), scheduleJSON}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&list.Id, &list.CreatedAt, &list.UpdatedAt); err != nil {
+		if isDuplicatePriceList(err) {
+			return ErrDuplicatePriceList
+		}
+		return err
+	}
+
+	if err := savePriceList(ctx, tx, list); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PriceListModule) Get(id int) (*PriceList, error) {
+	query := `SELECT ` + priceListColumns + ` FROM price_lists WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list PriceList
+	if err := scanPriceList(m.DB.QueryRowContext(ctx, query, id), &list); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	var err error
+	list.Items, err = priceListItems(ctx, m.DB, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// GetAll returns every price list without its items, the one that wins ties first.
+func (m PriceListModule) GetAll() ([]PriceList, error) {
+	query := `SELECT ` + priceListColumns + ` FROM price_lists ORDER BY priority DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []PriceList{}
+	for rows.Next() {
+		var list PriceList
+		if err := scanPriceList(rows, &list); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// Update replaces the price list, its stations, schedule and items.
+func (m PriceListModule) Update(id int, list *PriceList) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	scheduleJSON, err := list.prepare()
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE price_lists
+			SET name = $1, priority = $2, active = $3, customer_groups = $4, schedule = $5, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $6
+			RETURNING id, created_at, updated_at
+			`
+	args := []interface{}{list.Name, list.Priority, list.Active, pq.Array(list.CustomerGroups), scheduleJSON, id}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&list.Id, &list.CreatedAt, &list.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case isDuplicatePriceList(err):
+			return ErrDuplicatePriceList
+		}
+		return err
+	}
+
+	if err := savePriceList(ctx, tx, list); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a price list. Orders it priced keep their prices and lose the reference.
+func (m PriceListModule) Delete(id int) error {
+	query := `DELETE FROM price_lists WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Applicable returns the price list that prices an order for the customer group at the
+// station (nil when the order has none) at time at, or nil when no list applies.
+func (m PriceListModule) Applicable(customerGroup string, stationId *int, at time.Time) (*PriceList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return applicablePriceList(ctx, m.DB, customerGroup, stationId, at)
+}
+
+func applicablePriceList(ctx context.Context, q queryer, customerGroup string, stationId *int, at time.Time) (*PriceList, error) {
+	query := `
+			SELECT ` + priceListColumns + `
+			FROM price_lists
+			WHERE active
+				AND (cardinality(customer_groups) = 0 OR $1 = ANY(customer_groups))
+				AND (NOT EXISTS (SELECT 1 FROM price_list_stations WHERE price_list_id = price_lists.id)
+					OR EXISTS (SELECT 1 FROM price_list_stations WHERE price_list_id = price_lists.id AND station_id = $2))
+			ORDER BY priority DESC, id
+			`
+	rows, err := q.QueryContext(ctx, query, customerGroup, stationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var list PriceList
+		if err := scanPriceList(rows, &list); err != nil {
+			return nil, err
+		}
+		if list.activeAt(at) {
+			return &list, nil
+		}
+	}
+
+	return nil, rows.Err()
+}
+
+// PriceOrder prices the given lines of the order that come without a price, with the
+// price list that applies to the order at time at. Such a line gets the list's price of
+// its product, or the product's own price, for one unit of the line. A line the list
+// priced records the list as its PriceListId, and its TotalNormalPrice is what it would
+// have cost at the product's own price. PriceOrder returns the ID of the list, or nil when
+// no list applies or the list priced none of the lines.
+func (m PriceListModule) PriceOrder(order *Order, lines []OrderProduct, at time.Time) (*int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	list, err := applicablePriceList(ctx, tx, order.CustomerGroup, order.StationId, at)
+	if err != nil {
+		return nil, err
+	}
+	var listId *int
+	if list != nil {
+		listId = &list.Id
+	}
+	used := false
+
+	query := `
+			SELECT COALESCE(p.price, 0), i.price, i.discount_percent
+			FROM products p
+			LEFT JOIN LATERAL (
+				SELECT price, discount_percent
+				FROM price_list_items
+				LEFT JOIN category_ancestors(p.category_id) ancestors ON ancestors.ancestor_id = price_list_items.category_id
+				WHERE price_list_id = $2 AND (product_id = p.id OR ancestors.ancestor_id IS NOT NULL)
+				ORDER BY product_id IS NULL, ancestors.depth
+				LIMIT 1
+			) i ON TRUE
+			WHERE p.id = $1
+			`
+	for i := range lines {
+		line := &lines[i]
+		if line.Price != 0 {
+			continue
+		}
+
+		productId, err := strconv.Atoi(line.ProductId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownProduct, line.ProductId)
+		}
+
+		var price int
+		var listPrice *int
+		var discount *float64
+		if err := tx.QueryRowContext(ctx, query, productId, listId).Scan(&price, &listPrice, &discount); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("%w: %d", ErrUnknownProduct, productId)
+			}
+			return nil, err
+		}
+
+		factor, err := unitFactor(ctx, tx, productId, line.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		unitPrice := price
+		switch {
+		case listPrice != nil:
+			unitPrice = *listPrice
+		case discount != nil:
+			unitPrice = int(math.Round(float64(price) * (100 - *discount) / 100))
+		}
+		if listPrice != nil || discount != nil {
+			line.PriceListId = listId
+			used = true
+		}
+
+		line.Price = unitPrice * factor
+		if line.TotalNormalPrice == 0 {
+			line.TotalNormalPrice = price * factor * line.Qty
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, nil
+	}
+	return listId, nil
+}
@@ -0,0 +1,350 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+// Scheduled price statuses.
+const (
+	ScheduledPricePending   = "pending"
+	ScheduledPriceApplied   = "applied"
+	ScheduledPriceCancelled = "cancelled"
+)
+
+// ErrScheduledPriceNotPending is returned when cancelling a scheduled price that was
+// applied or cancelled already.
+var ErrScheduledPriceNotPending = errors.New("scheduled price is not pending")
+
+// PriceChange is one change of a product's price. OldPrice is nil for the price the
+// product was created with. ScheduledPriceId is set when the change applied a scheduled
+// price. ChangedAt is when the product got the price, also for a scheduled price, whose
+// own effective time stays on the scheduled price.
+type PriceChange struct {
+	Id               int64     `json:"id"`
+	ProductId        int       `json:"product_id"`
+	OldPrice         *int      `json:"old_price"`
+	NewPrice         int       `json:"new_price"`
+	EmployeeId       *int      `json:"employee_id"`
+	ScheduledPriceId *int      `json:"scheduled_price_id"`
+	ChangedAt        time.Time `json:"changed_at"`
+}
+
+// ScheduledPrice is a price a product will get at EffectiveAt.
+type ScheduledPrice struct {
+	Id          int        `json:"id"`
+	ProductId   int        `json:"product_id"`
+	Price       int        `json:"price"`
+	EffectiveAt time.Time  `json:"effective_at"`
+	Status      string     `json:"status"`
+	EmployeeId  *int       `json:"employee_id"`
+	AppliedAt   *time.Time `json:"applied_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type PriceModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// ValidateScheduledPrices checks a batch of price changes scheduled together. They must
+// take effect after now, and name each product once.
+func ValidateScheduledPrices(v *validator.Validator, prices []ScheduledPrice, now time.Time) {
+	v.Check(len(prices) > 0, "prices", "must contain at least one price")
+
+	seen := make(map[int]bool)
+	for i, price := range prices {
+		key := fmt.Sprintf("prices[%d]", i)
+		v.Check(price.ProductId > 0, key+".product_id", "must be provided")
+		v.Check(!seen[price.ProductId], key+".product_id", "must not be listed twice")
+		v.Check(price.Price >= 0, key+".price", "must not be negative")
+		v.Check(price.EffectiveAt.After(now), key+".effective_at", "must be in the future")
+		seen[price.ProductId] = true
+	}
+}
+
+// recordPriceChange adds a change of a product's price to its history. A zero changedAt
+// records the change as made now.
+func recordPriceChange(ctx context.Context, tx *sql.Tx, change *PriceChange) error {
+	query := `
+			INSERT INTO price_changes (product_id, old_price, new_price, employee_id, scheduled_price_id, changed_at)
+			VALUES ($1, $2, $3, $4, $5, COALESCE($6, CURRENT_TIMESTAMP))
+			RETURNING id, changed_at
+			`
+	var changedAt *time.Time
+	if !change.ChangedAt.IsZero() {
+		changedAt = &change.ChangedAt
+	}
+	args := []interface{}{change.ProductId, change.OldPrice, change.NewPrice, change.EmployeeId, change.ScheduledPriceId, changedAt}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&change.Id, &change.ChangedAt)
+}
+
+const priceChangeColumns = `id, product_id, old_price, new_price, employee_id, scheduled_price_id, changed_at`
+
+func priceChangeFields(c *PriceChange) []interface{} {
+	return []interface{}{&c.Id, &c.ProductId, &c.OldPrice, &c.NewPrice, &c.EmployeeId, &c.ScheduledPriceId, &c.ChangedAt}
+}
+
+// History returns the price changes of a product, newest first.
+func (p PriceModule) History(productId int, filters Filters) ([]PriceChange, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + priceChangeColumns + `
+			FROM price_changes
+			WHERE product_id = $1
+			ORDER BY changed_at DESC, id DESC
+			LIMIT $2 OFFSET $3
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, productId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	changes := []PriceChange{}
+	for rows.Next() {
+		var change PriceChange
+		if err := rows.Scan(append([]interface{}{&totalRecords}, priceChangeFields(&change)...)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		changes = append(changes, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return changes, metadata, nil
+}
+
+// At returns the change that set the price a product had at the given time. It returns
+// ErrRecordNotFound when the product had no price yet, or does not exist.
+func (p PriceModule) At(productId int, at time.Time) (*PriceChange, error) {
+	query := `
+			SELECT ` + priceChangeColumns + `
+			FROM price_changes
+			WHERE product_id = $1 AND changed_at <= $2
+			ORDER BY changed_at DESC, id DESC
+			LIMIT 1
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var change PriceChange
+	if err := p.DB.QueryRowContext(ctx, query, productId, at).Scan(priceChangeFields(&change)...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+const scheduledPriceColumns = `id, product_id, price, effective_at, status, employee_id, applied_at, created_at`
+
+func scheduledPriceFields(s *ScheduledPrice) []interface{} {
+	return []interface{}{&s.Id, &s.ProductId, &s.Price, &s.EffectiveAt, &s.Status, &s.EmployeeId, &s.AppliedAt, &s.CreatedAt}
+}
+
+// Schedule stores a batch of price changes, all or none. Every product must exist.
+func (p PriceModule) Schedule(prices []ScheduledPrice, employeeId *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	productIds := make([]int64, len(prices))
+	for i, price := range prices {
+		productIds[i] = int64(price.ProductId)
+	}
+	if err := productsExist(ctx, tx, productIds); err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO scheduled_prices (product_id, price, effective_at, employee_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING ` + scheduledPriceColumns
+	for i := range prices {
+		price := &prices[i]
+		args := []interface{}{price.ProductId, price.Price, price.EffectiveAt, employeeId}
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(scheduledPriceFields(price)...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetScheduled returns scheduled prices, optionally of one product only (productId > 0)
+// and with one status only, first to take effect first.
+func (p PriceModule) GetScheduled(productId int, status string, filters Filters) ([]ScheduledPrice, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + scheduledPriceColumns + `
+			FROM scheduled_prices
+			WHERE (product_id = $1 OR $1 = 0) AND (status = $2 OR $2 = '')
+			ORDER BY effective_at, id
+			LIMIT $3 OFFSET $4
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, productId, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	prices := []ScheduledPrice{}
+	for rows.Next() {
+		var price ScheduledPrice
+		if err := rows.Scan(append([]interface{}{&totalRecords}, scheduledPriceFields(&price)...)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		prices = append(prices, price)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return prices, metadata, nil
+}
+
+// CancelScheduled cancels a pending scheduled price.
+func (p PriceModule) CancelScheduled(id int) (*ScheduledPrice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var price ScheduledPrice
+	query := `
+			UPDATE scheduled_prices
+			SET status = 'cancelled'
+			WHERE id = $1 AND status = 'pending'
+			RETURNING ` + scheduledPriceColumns
+	err := p.DB.QueryRowContext(ctx, query, id).Scan(scheduledPriceFields(&price)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		query = `SELECT EXISTS (SELECT 1 FROM scheduled_prices WHERE id = $1)`
+		if err := p.DB.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrScheduledPriceNotPending
+		}
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &price, nil
+}
+
+// ApplyDue gives products the pending scheduled prices that are due at now, first to take
+// effect first, and returns the scheduled prices it applied. Each is recorded in the
+// price history as changed when it was applied, so that the history follows the order in
+// which products actually got their prices.
+func (p PriceModule) ApplyDue(now time.Time) ([]ScheduledPrice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+			SELECT ` + scheduledPriceColumns + `
+			FROM scheduled_prices
+			WHERE status = 'pending' AND effective_at <= $1
+			ORDER BY effective_at, id
+			FOR UPDATE SKIP LOCKED
+			`
+	rows, err := tx.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []ScheduledPrice
+	for rows.Next() {
+		var price ScheduledPrice
+		if err := rows.Scan(scheduledPriceFields(&price)...); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, price)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range due {
+		price := &due[i]
+		if err := setPrice(ctx, tx, price.ProductId, price.Price, price.EmployeeId, price.Id); err != nil {
+			return nil, err
+		}
+
+		query = `
+				UPDATE scheduled_prices
+				SET status = 'applied', applied_at = $2
+				WHERE id = $1
+				RETURNING status, applied_at
+				`
+		if err := tx.QueryRowContext(ctx, query, price.Id, now).Scan(&price.Status, &price.AppliedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return due, nil
+}
+
+// setPrice gives a product a new price as the scheduled price scheduledId, recording the
+// change when the price differs.
+func setPrice(ctx context.Context, tx *sql.Tx, productId, price int, employeeId *int, scheduledId int) error {
+	var oldPrice int
+	query := `SELECT COALESCE(price, 0) FROM products WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, productId).Scan(&oldPrice); err != nil {
+		return err
+	}
+	if oldPrice == price {
+		return nil
+	}
+
+	query = `UPDATE products SET price = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, query, price, productId); err != nil {
+		return err
+	}
+
+	return recordPriceChange(ctx, tx, &PriceChange{
+		ProductId:        productId,
+		OldPrice:         &oldPrice,
+		NewPrice:         price,
+		EmployeeId:       employeeId,
+		ScheduledPriceId: &scheduledId,
+	})
+}
@@ -3,20 +3,80 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/lib/pq"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+var (
+	// ErrTrackingInUse is returned when turning lot or serial tracking on or off for a
+	// product that has stock or stock movements, which were not tracked the same way.
+	ErrTrackingInUse = errors.New("lot and serial tracking cannot change once the product has stock movements")
+	// ErrProductInUse is returned when deleting a product that other records still refer
+	// to, other than as a recipe ingredient.
+	ErrProductInUse = errors.New("product is in use")
 )
 
 type Product struct {
-	Id          int       `json:"id"`
-	Name        string    `json:"name"`
-	CategoryId  int       `json:"categoryId"`
-	Price       int       `json:"price"`
-	Description string    `json:"description"`
-	Amount      int       `json:"amount"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"UpdatedAt"`
+	Id             int        `json:"id"`
+	Name           string     `json:"name"`
+	CategoryId     int        `json:"categoryId"`
+	Price          int        `json:"price"`
+	Description    string     `json:"description"`
+	Amount         int        `json:"amount"`
+	StockFlaggedAt *time.Time `json:"stockFlaggedAt"`
+	ReorderPoint   *int       `json:"reorderPoint"`
+	ReorderQty     *int       `json:"reorderQty"`
+	Cost           int        `json:"cost"`
+	CostMethod     string     `json:"costMethod"`
+	Margin         int        `json:"margin"`
+	MarginPercent  *float64   `json:"marginPercent"`
+	TrackLots      *bool      `json:"trackLots"`
+	Serialized     *bool      `json:"serialized"`
+	BaseUnit       string     `json:"baseUnit"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"UpdatedAt"`
+	// StoreId is set when Amount is the stock of that store rather than the total, and
+	// Stocks when the stock of every store was asked for.
+	StoreId *int         `json:"storeId,omitempty"`
+	Stocks  []StoreStock `json:"stocks,omitempty"`
+	// Units are the units other than BaseUnit the product is bought, sold or counted in.
+	// Update keeps them when none are given.
+	Units ProductUnits `json:"units"`
+	// Images are only changed through uploads and are ignored by Create and Update.
+	Images ProductImages `json:"images"`
+}
+
+const productColumns = `id, name, category_id, price, description, amount, stock_flagged_at, reorder_point, reorder_qty,
+		cost, cost_method, price - cost, CASE WHEN price > 0 THEN ROUND(100.0 * (price - cost) / price, 1) END,
+		track_lots, serialized, base_unit,
+		(SELECT COALESCE(jsonb_agg(jsonb_build_object('name', name, 'factor', factor) ORDER BY factor), '[]')
+			FROM product_units WHERE product_id = products.id),
+		(SELECT COALESCE(jsonb_agg(jsonb_build_object('id', id, 'productId', product_id, 'position', position, 'url', url,
+				'thumbnails', thumbnails, 'contentType', content_type, 'width', width, 'height', height, 'size', size,
+				'createdAt', created_at AT TIME ZONE 'UTC') ORDER BY position, id), '[]')
+			FROM product_images WHERE product_id = products.id),
+		created_at, updated_at`
+
+// productFields returns the scan destinations matching productColumns.
+func productFields(prd *Product) []interface{} {
+	return []interface{}{&prd.Id, &prd.Name, &prd.CategoryId, &prd.Price, &prd.Description, &prd.Amount,
+		&prd.StockFlaggedAt, &prd.ReorderPoint, &prd.ReorderQty, &prd.Cost, &prd.CostMethod, &prd.Margin, &prd.MarginPercent,
+		&prd.TrackLots, &prd.Serialized, &prd.BaseUnit, &prd.Units, &prd.Images, &prd.CreatedAt, &prd.UpdatedAt}
+}
+
+func ValidateProduct(v *validator.Validator, product *Product) {
+	v.Check(product.ReorderPoint == nil || *product.ReorderPoint >= 0, "reorderPoint", "must not be negative")
+	v.Check(product.ReorderQty == nil || *product.ReorderQty > 0, "reorderQty", "must be greater than zero")
+	v.Check(product.ReorderQty == nil || product.ReorderPoint != nil, "reorderQty", "requires a reorder point")
+	v.Check(product.Cost >= 0, "cost", "must not be negative")
+	v.Check(product.CostMethod == "" || validator.In(product.CostMethod, CostAverage, CostFIFO), "costMethod", "must be average or fifo")
+	ValidateUnits(v, product.BaseUnit, product.Units)
 }
 
 type ProductModule struct {
@@ -25,24 +85,68 @@ type ProductModule struct {
 	ErrorLog *log.Logger
 }
 
-func (p ProductModule) Create(product *Product) error {
+// Create stores the product. Its initial amount is booked as an opening stock movement in
+// the given store, or in the default store when storeId is zero, at the product's cost.
+// Products are valued at weighted average cost unless another cost method is given, and
+// counted in units unless another base unit is given.
+func (p ProductModule) Create(product *Product, employeeId *int, storeId int) error {
 	fmt.Println("Hello From Product Module")
 	query := `
-			INSERT INTO products (name, category_id, price, description, amount)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id
+			INSERT INTO products (name, category_id, price, description, amount, reorder_point, reorder_qty, cost, cost_method, track_lots, serialized,
+				base_unit)
+			VALUES ($1, $2, $3, $4, 0, $5, $6, $7, COALESCE(NULLIF($8, ''), 'average'), COALESCE($9, FALSE), COALESCE($10, FALSE),
+				COALESCE(NULLIF($11, ''), 'unit'))
+			RETURNING id, cost_method, track_lots, serialized, base_unit
 			`
-	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.Amount}
+	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.ReorderPoint, product.ReorderQty,
+		product.Cost, product.CostMethod, product.TrackLots, product.Serialized, product.BaseUnit}
 	fmt.Println(args...)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&product.Id, &product.CostMethod, &product.TrackLots, &product.Serialized, &product.BaseUnit); err != nil {
+		return err
+	}
+
+	if err := recordPriceChange(ctx, tx, &PriceChange{ProductId: product.Id, NewPrice: product.Price, EmployeeId: employeeId}); err != nil {
+		return err
+	}
+
+	if err := setProductUnits(ctx, tx, product.Id, product.Units); err != nil {
+		return err
+	}
+	if product.Units == nil {
+		product.Units = ProductUnits{}
+	}
+	product.Images = ProductImages{}
+
+	if product.Amount != 0 {
+		movement := &StockMovement{
+			ProductId:  product.Id,
+			StoreId:    storeId,
+			Type:       MovementAdjustment,
+			QtyDelta:   product.Amount,
+			Reason:     "opening stock",
+			EmployeeId: employeeId,
+		}
+		if err := recordMovement(ctx, tx, movement); err != nil {
+			return err
+		}
+	}
+
 	fmt.Println("Buy From Product Module")
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.Id)
+	return tx.Commit()
 }
 
 func (p ProductModule) Get(id int) (*Product, error) {
 	query := `
-			SELECT * FROM products 
+			SELECT ` + productColumns + ` FROM products
 			WHERE id = $1
 			`
 
@@ -51,8 +155,7 @@ func (p ProductModule) Get(id int) (*Product, error) {
 	defer cancel()
 
 	row := p.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&product.Id, &product.Name, &product.CategoryId,
-		&product.Price, &product.Description, &product.Amount, &product.CreatedAt, &product.UpdatedAt)
+	err := row.Scan(productFields(&product)...)
 
 	if err != nil {
 		return nil, err
@@ -63,9 +166,10 @@ func (p ProductModule) Get(id int) (*Product, error) {
 
 func (p ProductModule) GetAll(name string, category int, filters Filters) (*[]Product, Metadata, error) {
 	query := fmt.Sprintf(`
-			SELECT count(*) OVER(), * from products
+			WITH RECURSIVE `+categorySubtree("$2")+`
+			SELECT count(*) OVER(), `+productColumns+` from products
 			WHERE (to_tsvector('simple', name ) @@ plainto_tsquery('simple', $1) OR $1 = '')
-			AND (category_id = $2 OR $2 = 1)
+			AND ($2 = 0 OR category_id IN (SELECT id FROM subtree))
 			ORDER BY %s %s, id ASC
 			LIMIT $3 OFFSET $4
 	`, filters.sortColumn(), filters.sortDirection())
@@ -83,7 +187,7 @@ func (p ProductModule) GetAll(name string, category int, filters Filters) (*[]Pr
 
 	for rows.Next() {
 		var prd Product
-		err := rows.Scan(&totalRecords, &prd.Id, &prd.Name, &prd.CategoryId, &prd.Price, &prd.Description, &prd.Amount, &prd.CreatedAt, &prd.UpdatedAt)
+		err := rows.Scan(append([]interface{}{&totalRecords}, productFields(&prd)...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -97,18 +201,70 @@ func (p ProductModule) GetAll(name string, category int, filters Filters) (*[]Pr
 	return &products, metadata, nil
 }
 
-func (p ProductModule) Update(id int, product *Product) error {
-	query := `
-			UPDATE products
-			SET name = $1, category_id = $2, price = $3, description = $4, amount = $5
-			WHERE id = $6
-			RETURNING updated_at
-			`
-	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.Amount, id}
+// Update saves the product's details. The amount and cost are not touched here: they
+// only change through stock movements and revaluation. The cost method, lot and serial
+// tracking, base unit and units are kept when none are given. A new price is recorded in
+// the price history as changed by employeeId.
+func (p ProductModule) Update(id int, product *Product, employeeId *int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.UpdatedAt)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldPrice int
+	var baseUnit string
+	var trackLots, serialized, stocked bool
+	query := `
+			SELECT COALESCE(price, 0), base_unit, track_lots, serialized,
+				amount <> 0 OR EXISTS (SELECT 1 FROM stock_movements WHERE product_id = products.id)
+			FROM products
+			WHERE id = $1
+			FOR UPDATE
+			`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&oldPrice, &baseUnit, &trackLots, &serialized, &stocked); err != nil {
+		return err
+	}
+	if product.BaseUnit != "" && product.BaseUnit != baseUnit && stocked {
+		return ErrBaseUnitInUse
+	}
+	if stocked && (product.TrackLots != nil && *product.TrackLots != trackLots ||
+		product.Serialized != nil && *product.Serialized != serialized) {
+		return ErrTrackingInUse
+	}
+
+	if product.Units != nil {
+		if err := setProductUnits(ctx, tx, id, product.Units); err != nil {
+			return err
+		}
+	}
+
+	query = `
+			UPDATE products
+			SET name = $1, category_id = $2, price = $3, description = $4, reorder_point = $5, reorder_qty = $6,
+				cost_method = COALESCE(NULLIF($7, ''), cost_method), track_lots = COALESCE($8, track_lots),
+				serialized = COALESCE($9, serialized),
+				base_unit = COALESCE(NULLIF($10, ''), base_unit)
+			WHERE id = $11
+			RETURNING ` + productColumns
+	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description,
+		product.ReorderPoint, product.ReorderQty, product.CostMethod, product.TrackLots, product.Serialized,
+		product.BaseUnit, id}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(productFields(product)...); err != nil {
+		return err
+	}
+
+	if product.Price != oldPrice {
+		change := &PriceChange{ProductId: id, OldPrice: &oldPrice, NewPrice: product.Price, EmployeeId: employeeId}
+		if err := recordPriceChange(ctx, tx, change); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (p ProductModule) Delete(id int) error {
@@ -120,5 +276,45 @@ func (p ProductModule) Delete(id int) error {
 	defer cancel()
 
 	_, err := p.DB.ExecContext(ctx, query, id)
+	if isForeignKeyViolation(err) {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Constraint == "recipe_lines_ingredient_id_fkey" {
+			return ErrIngredientInUse
+		}
+		return ErrProductInUse
+	}
 	return err
 }
+
+// GetFlagged returns the products whose stock was driven below zero by offline sales.
+func (p ProductModule) GetFlagged() ([]Product, error) {
+	query := `
+			SELECT ` + productColumns + `
+			FROM products
+			WHERE stock_flagged_at IS NOT NULL
+			ORDER BY stock_flagged_at
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		var prd Product
+		if err := rows.Scan(productFields(&prd)...); err != nil {
+			return nil, err
+		}
+		products = append(products, prd)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
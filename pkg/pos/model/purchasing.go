@@ -0,0 +1,655 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+
+	"github.com/lib/pq"
+)
+
+// Purchase order statuses, in the order a purchase order moves through them.
+const (
+	PurchaseOrderDraft             = "draft"
+	PurchaseOrderSent              = "sent"
+	PurchaseOrderPartiallyReceived = "partially_received"
+	PurchaseOrderReceived          = "received"
+)
+
+var (
+	// ErrUnknownSupplier is returned when a purchase order names a supplier that does not exist.
+	ErrUnknownSupplier = errors.New("supplier does not exist")
+	// ErrSupplierInUse is returned when deleting a supplier that has purchase orders.
+	ErrSupplierInUse = errors.New("supplier has purchase orders")
+	// ErrPurchaseOrderNotDraft is returned when changing a purchase order that has been sent.
+	ErrPurchaseOrderNotDraft = errors.New("only draft purchase orders can be changed")
+	// ErrPurchaseOrderNotOpen is returned when receiving against a purchase order that has
+	// not been sent or has been received in full.
+	ErrPurchaseOrderNotOpen = errors.New("only sent or partially received purchase orders can be received")
+	// ErrUnknownPurchaseOrderLine is returned when receiving a line the purchase order does not have.
+	ErrUnknownPurchaseOrderLine = errors.New("purchase order line does not exist")
+)
+
+type Supplier struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	ContactName string    `json:"contact_name"`
+	Email       string    `json:"email"`
+	Phone       string    `json:"phone"`
+	Address     string    `json:"address"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PurchaseOrderLine is one product ordered from a supplier, at the supplier's SKU and
+// cost per unit. Quantities and cost are in Unit, or in the product's base unit when it
+// is empty.
+type PurchaseOrderLine struct {
+	Id          int    `json:"id"`
+	ProductId   int    `json:"product_id"`
+	SupplierSku string `json:"supplier_sku"`
+	Unit        string `json:"unit"`
+	UnitCost    int    `json:"unit_cost"`
+	QtyOrdered  int    `json:"qty_ordered"`
+	QtyReceived int    `json:"qty_received"`
+}
+
+// PurchaseOrder is an order placed with a supplier for delivery to one store.
+type PurchaseOrder struct {
+	Id         int                 `json:"id"`
+	SupplierId int                 `json:"supplier_id"`
+	StoreId    int                 `json:"store_id"`
+	Status     string              `json:"status"`
+	Notes      string              `json:"notes"`
+	EmployeeId *int                `json:"employee_id"`
+	Lines      []PurchaseOrderLine `json:"lines,omitempty"`
+	SentAt     *time.Time          `json:"sent_at"`
+	ReceivedAt *time.Time          `json:"received_at"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+// PurchaseReceipt is the quantity of one purchase order line that arrived in a delivery,
+// for products that track lots the lot it belongs to, and for serialized products the
+// serial of each unit.
+type PurchaseReceipt struct {
+	LineId    int      `json:"line_id"`
+	Qty       int      `json:"qty"`
+	LotNumber string   `json:"lot_number"`
+	ExpiresOn *string  `json:"expires_on"`
+	Serials   []string `json:"serials"`
+}
+
+type PurchasingModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateSupplier(v *validator.Validator, supplier *Supplier) {
+	v.Check(supplier.Name != "", "name", "must be provided")
+	v.Check(len(supplier.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(len(supplier.ContactName) <= 255, "contact_name", "must not be more than 255 bytes long")
+	v.Check(supplier.Email == "" || validator.Matches(supplier.Email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(len(supplier.Phone) <= 64, "phone", "must not be more than 64 bytes long")
+}
+
+func ValidatePurchaseOrder(v *validator.Validator, order *PurchaseOrder) {
+	v.Check(order.SupplierId > 0, "supplier_id", "must be provided")
+	v.Check(order.StoreId >= 0, "store_id", "must be a valid store")
+	v.Check(len(order.Lines) > 0, "lines", "must contain at least one line")
+
+	for i, line := range order.Lines {
+		key := fmt.Sprintf("lines[%d]", i)
+		v.Check(line.ProductId > 0, key+".product_id", "must be provided")
+		v.Check(line.QtyOrdered > 0, key+".qty_ordered", "must be greater than zero")
+		v.Check(line.UnitCost >= 0, key+".unit_cost", "must not be negative")
+		v.Check(len(line.SupplierSku) <= 64, key+".supplier_sku", "must not be more than 64 bytes long")
+		v.Check(len(line.Unit) <= 16, key+".unit", "must not be more than 16 bytes long")
+	}
+}
+
+// ValidatePurchaseReceipts checks a delivery. A line may be received for more than was
+// ordered, as suppliers do over-deliver.
+func ValidatePurchaseReceipts(v *validator.Validator, receipts []PurchaseReceipt) {
+	v.Check(len(receipts) > 0, "lines", "must contain at least one line")
+
+	ids := make([]string, len(receipts))
+	for i, receipt := range receipts {
+		key := fmt.Sprintf("lines[%d].", i)
+		ids[i] = strconv.Itoa(receipt.LineId)
+		v.Check(receipt.Qty > 0, key+"qty", "must be greater than zero")
+		ValidateLot(v, key, receipt.LotNumber, receipt.ExpiresOn)
+		ValidateSerials(v, key+"serials", receipt.Serials)
+	}
+	v.Check(validator.Unique(ids), "lines", "must not contain the same line twice")
+}
+
+const supplierColumns = `id, name, contact_name, email, phone, address, created_at, updated_at`
+
+func scanSupplier(row interface{ Scan(...interface{}) error }, supplier *Supplier) error {
+	return row.Scan(&supplier.Id, &supplier.Name, &supplier.ContactName, &supplier.Email, &supplier.Phone,
+		&supplier.Address, &supplier.CreatedAt, &supplier.UpdatedAt)
+}
+
+func (p PurchasingModule) CreateSupplier(supplier *Supplier) error {
+	query := `
+			INSERT INTO suppliers (name, contact_name, email, phone, address)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING ` + supplierColumns
+	args := []interface{}{supplier.Name, supplier.ContactName, supplier.Email, supplier.Phone, supplier.Address}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanSupplier(p.DB.QueryRowContext(ctx, query, args...), supplier)
+}
+
+func (p PurchasingModule) GetSupplier(id int) (*Supplier, error) {
+	query := `SELECT ` + supplierColumns + ` FROM suppliers WHERE id = $1`
+
+	var supplier Supplier
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanSupplier(p.DB.QueryRowContext(ctx, query, id), &supplier)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &supplier, nil
+}
+
+func (p PurchasingModule) GetAllSuppliers() ([]Supplier, error) {
+	query := `SELECT ` + supplierColumns + ` FROM suppliers ORDER BY name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppliers := []Supplier{}
+	for rows.Next() {
+		var supplier Supplier
+		if err := scanSupplier(rows, &supplier); err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, supplier)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suppliers, nil
+}
+
+func (p PurchasingModule) UpdateSupplier(id int, supplier *Supplier) error {
+	query := `
+			UPDATE suppliers
+			SET name = $1, contact_name = $2, email = $3, phone = $4, address = $5, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $6
+			RETURNING ` + supplierColumns
+	args := []interface{}{supplier.Name, supplier.ContactName, supplier.Email, supplier.Phone, supplier.Address, id}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanSupplier(p.DB.QueryRowContext(ctx, query, args...), supplier)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DeleteSupplier removes a supplier nothing was ever ordered from.
+func (p PurchasingModule) DeleteSupplier(id int) error {
+	query := `
+			DELETE FROM suppliers
+			WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM purchase_orders WHERE supplier_id = $1)
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	if _, err := p.GetSupplier(id); err != nil {
+		return err
+	}
+	return ErrSupplierInUse
+}
+
+const purchaseOrderColumns = `id, supplier_id, store_id, status, notes, employee_id, sent_at, received_at, created_at, updated_at`
+
+func scanPurchaseOrder(row interface{ Scan(...interface{}) error }, order *PurchaseOrder) error {
+	return row.Scan(&order.Id, &order.SupplierId, &order.StoreId, &order.Status, &order.Notes, &order.EmployeeId,
+		&order.SentAt, &order.ReceivedAt, &order.CreatedAt, &order.UpdatedAt)
+}
+
+// queryer is what both *sql.DB and *sql.Tx offer for reading.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func getPurchaseOrderLines(ctx context.Context, q queryer, order *PurchaseOrder) error {
+	query := `
+			SELECT id, product_id, supplier_sku, unit, unit_cost, qty_ordered, qty_received
+			FROM purchase_order_lines
+			WHERE purchase_order_id = $1
+			ORDER BY id
+			`
+	rows, err := q.QueryContext(ctx, query, order.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	order.Lines = []PurchaseOrderLine{}
+	for rows.Next() {
+		var line PurchaseOrderLine
+		err := rows.Scan(&line.Id, &line.ProductId, &line.SupplierSku, &line.Unit, &line.UnitCost, &line.QtyOrdered, &line.QtyReceived)
+		if err != nil {
+			return err
+		}
+		order.Lines = append(order.Lines, line)
+	}
+
+	return rows.Err()
+}
+
+// productsExist returns ErrUnknownProduct naming the first of productIds that does not
+// exist.
+func productsExist(ctx context.Context, tx *sql.Tx, productIds []int64) error {
+	var missing sql.NullInt64
+	query := `
+			SELECT min(wanted.id)
+			FROM unnest($1::int[]) AS wanted(id)
+			WHERE NOT EXISTS (SELECT 1 FROM products WHERE products.id = wanted.id)
+			`
+	if err := tx.QueryRowContext(ctx, query, pq.Array(productIds)).Scan(&missing); err != nil {
+		return err
+	}
+	if missing.Valid {
+		return fmt.Errorf("%w: %d", ErrUnknownProduct, missing.Int64)
+	}
+	return nil
+}
+
+// setPurchaseOrderLines replaces the lines of a draft purchase order. Every product must
+// exist and have the line's unit.
+func setPurchaseOrderLines(ctx context.Context, tx *sql.Tx, order *PurchaseOrder) error {
+	productIds := make([]int64, len(order.Lines))
+	for i, line := range order.Lines {
+		productIds[i] = int64(line.ProductId)
+	}
+
+	if err := productsExist(ctx, tx, productIds); err != nil {
+		return err
+	}
+
+	for _, line := range order.Lines {
+		if _, err := unitFactor(ctx, tx, line.ProductId, line.Unit); err != nil {
+			return err
+		}
+	}
+
+	query := `DELETE FROM purchase_order_lines WHERE purchase_order_id = $1`
+	if _, err := tx.ExecContext(ctx, query, order.Id); err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO purchase_order_lines (purchase_order_id, product_id, supplier_sku, unit, unit_cost, qty_ordered)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+			`
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		line.QtyReceived = 0
+		args := []interface{}{order.Id, line.ProductId, line.SupplierSku, line.Unit, line.UnitCost, line.QtyOrdered}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&line.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func supplierExists(ctx context.Context, tx *sql.Tx, id int) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM suppliers WHERE id = $1)`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownSupplier
+	}
+	return nil
+}
+
+// CreatePurchaseOrder saves a new draft purchase order with its lines. Without a StoreId
+// the goods are delivered to the default store.
+func (p PurchasingModule) CreatePurchaseOrder(order *PurchaseOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := supplierExists(ctx, tx, order.SupplierId); err != nil {
+		return err
+	}
+	storeId, err := resolveStore(ctx, tx, order.StoreId)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO purchase_orders (supplier_id, store_id, notes, employee_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING ` + purchaseOrderColumns
+	lines := order.Lines
+	err = scanPurchaseOrder(tx.QueryRowContext(ctx, query, order.SupplierId, storeId, order.Notes, order.EmployeeId), order)
+	if err != nil {
+		return err
+	}
+
+	order.Lines = lines
+	if err := setPurchaseOrderLines(ctx, tx, order); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (p PurchasingModule) GetPurchaseOrder(id int) (*PurchaseOrder, error) {
+	query := `SELECT ` + purchaseOrderColumns + ` FROM purchase_orders WHERE id = $1`
+
+	var order PurchaseOrder
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanPurchaseOrder(p.DB.QueryRowContext(ctx, query, id), &order)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err := getPurchaseOrderLines(ctx, p.DB, &order); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// GetPurchaseOrders lists purchase orders without their lines, newest first, optionally
+// only those of one supplier (supplierId > 0) or in one status.
+func (p PurchasingModule) GetPurchaseOrders(supplierId int, status string, filters Filters) ([]PurchaseOrder, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + purchaseOrderColumns + `
+			FROM purchase_orders
+			WHERE (supplier_id = $1 OR $1 = 0) AND (status = $2 OR $2 = '')
+			ORDER BY id DESC
+			LIMIT $3 OFFSET $4
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, supplierId, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []PurchaseOrder{}
+	for rows.Next() {
+		var order PurchaseOrder
+		err := rows.Scan(&totalRecords, &order.Id, &order.SupplierId, &order.StoreId, &order.Status, &order.Notes, &order.EmployeeId,
+			&order.SentAt, &order.ReceivedAt, &order.CreatedAt, &order.UpdatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		orders = append(orders, order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+// lockPurchaseOrder loads a purchase order for update within tx.
+func lockPurchaseOrder(ctx context.Context, tx *sql.Tx, id int, order *PurchaseOrder) error {
+	query := `SELECT ` + purchaseOrderColumns + ` FROM purchase_orders WHERE id = $1 FOR UPDATE`
+	err := scanPurchaseOrder(tx.QueryRowContext(ctx, query, id), order)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdatePurchaseOrder changes the supplier, store, notes and lines of a draft purchase
+// order.
+func (p PurchasingModule) UpdatePurchaseOrder(id int, order *PurchaseOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current PurchaseOrder
+	if err := lockPurchaseOrder(ctx, tx, id, &current); err != nil {
+		return err
+	}
+	if current.Status != PurchaseOrderDraft {
+		return ErrPurchaseOrderNotDraft
+	}
+	if err := supplierExists(ctx, tx, order.SupplierId); err != nil {
+		return err
+	}
+	storeId := current.StoreId
+	if order.StoreId != 0 {
+		if storeId, err = resolveStore(ctx, tx, order.StoreId); err != nil {
+			return err
+		}
+	}
+
+	query := `
+			UPDATE purchase_orders
+			SET supplier_id = $1, store_id = $2, notes = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+			RETURNING ` + purchaseOrderColumns
+	lines := order.Lines
+	err = scanPurchaseOrder(tx.QueryRowContext(ctx, query, order.SupplierId, storeId, order.Notes, id), order)
+	if err != nil {
+		return err
+	}
+
+	order.Lines = lines
+	if err := setPurchaseOrderLines(ctx, tx, order); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePurchaseOrder removes a draft purchase order.
+func (p PurchasingModule) DeletePurchaseOrder(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var order PurchaseOrder
+	if err := lockPurchaseOrder(ctx, tx, id, &order); err != nil {
+		return err
+	}
+	if order.Status != PurchaseOrderDraft {
+		return ErrPurchaseOrderNotDraft
+	}
+
+	query := `DELETE FROM purchase_orders WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Send marks a draft purchase order as sent to the supplier, after which its lines are
+// fixed and goods can be received against it.
+func (p PurchasingModule) Send(id int) (*PurchaseOrder, error) {
+	query := `
+			UPDATE purchase_orders
+			SET status = 'sent', sent_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND status = 'draft'
+			RETURNING ` + purchaseOrderColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order PurchaseOrder
+	err := scanPurchaseOrder(p.DB.QueryRowContext(ctx, query, id), &order)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := p.GetPurchaseOrder(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrPurchaseOrderNotDraft
+	}
+
+	if err := getPurchaseOrderLines(ctx, p.DB, &order); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// Receive books a delivery against a sent purchase order: the received quantities are
+// added to the lines and, converted from the line's unit, to the stock of the order's
+// store as receipt movements at the line's unit cost. The order becomes received once
+// every line has arrived in full, and partially received until then.
+func (p PurchasingModule) Receive(id int, receipts []PurchaseReceipt, employeeId *int) (*PurchaseOrder, []*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var order PurchaseOrder
+	if err := lockPurchaseOrder(ctx, tx, id, &order); err != nil {
+		return nil, nil, err
+	}
+	if order.Status != PurchaseOrderSent && order.Status != PurchaseOrderPartiallyReceived {
+		return nil, nil, ErrPurchaseOrderNotOpen
+	}
+
+	movements := make([]*StockMovement, 0, len(receipts))
+	query := `
+			UPDATE purchase_order_lines
+			SET qty_received = qty_received + $3
+			WHERE id = $2 AND purchase_order_id = $1
+			RETURNING product_id, unit, unit_cost
+			`
+	for _, receipt := range receipts {
+		m := &StockMovement{
+			UnitCost:   new(int),
+			StoreId:    order.StoreId,
+			Type:       MovementReceipt,
+			QtyDelta:   receipt.Qty,
+			Reason:     "purchase order received",
+			EmployeeId: employeeId,
+			SourceType: "purchase_order",
+			SourceId:   strconv.Itoa(order.Id),
+			Serials:    receipt.Serials,
+		}
+		if receipt.LotNumber != "" {
+			m.Lots = []LotAllocation{{LotNumber: receipt.LotNumber, ExpiresOn: receipt.ExpiresOn, Qty: receipt.Qty}}
+		}
+		err := tx.QueryRowContext(ctx, query, order.Id, receipt.LineId, receipt.Qty).Scan(&m.ProductId, &m.Unit, m.UnitCost)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil, fmt.Errorf("%w: %d", ErrUnknownPurchaseOrderLine, receipt.LineId)
+			}
+			return nil, nil, err
+		}
+		movements = append(movements, m)
+	}
+
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+			UPDATE purchase_orders
+			SET status = CASE WHEN complete THEN 'received' ELSE 'partially_received' END,
+				received_at = CASE WHEN complete THEN NOW() END,
+				updated_at = NOW()
+			FROM (
+				SELECT bool_and(qty_received >= qty_ordered) AS complete
+				FROM purchase_order_lines
+				WHERE purchase_order_id = $1
+			) AS lines
+			WHERE id = $1
+			RETURNING ` + purchaseOrderColumns
+	if err := scanPurchaseOrder(tx.QueryRowContext(ctx, query, order.Id), &order); err != nil {
+		return nil, nil, err
+	}
+
+	if err := getPurchaseOrderLines(ctx, tx, &order); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &order, movements, nil
+}
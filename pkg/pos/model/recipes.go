@@ -0,0 +1,348 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+var (
+	// ErrNestedRecipe is returned when a recipe would use a product that has a recipe
+	// itself, or give a recipe to a product that is an ingredient.
+	ErrNestedRecipe = errors.New("recipes cannot be nested")
+	// ErrSerializedIngredient is returned when a recipe would use a serialized product.
+	ErrSerializedIngredient = errors.New("serialized products cannot be ingredients")
+	// ErrIngredientInUse is returned when deleting a product that recipes still use.
+	ErrIngredientInUse = errors.New("product is an ingredient of a recipe")
+)
+
+// RecipeLine is how much of one ingredient goes into one unit of a product. Qty is in
+// Unit, one of the ingredient's units, or in its base unit when that is empty.
+type RecipeLine struct {
+	IngredientId int    `json:"ingredient_id"`
+	Name         string `json:"name"`
+	Qty          int    `json:"qty"`
+	Unit         string `json:"unit"`
+	Cost         int    `json:"cost"`
+}
+
+// Recipe is the bill of materials of a product. Selling a product with a recipe takes
+// its ingredients out of stock instead of the product itself. Cost is what the
+// ingredients of one unit cost at their current unit costs.
+type Recipe struct {
+	ProductId int          `json:"product_id"`
+	Lines     []RecipeLine `json:"lines"`
+	Cost      int          `json:"cost"`
+}
+
+type RecipeModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateRecipe(v *validator.Validator, recipe *Recipe) {
+	seen := make(map[int]bool)
+	for i, line := range recipe.Lines {
+		key := fmt.Sprintf("lines[%d]", i)
+		v.Check(line.IngredientId > 0, key+".ingredient_id", "must be provided")
+		v.Check(line.IngredientId != recipe.ProductId, key+".ingredient_id", "must not be the product itself")
+		v.Check(!seen[line.IngredientId], key+".ingredient_id", "must not be listed twice")
+		v.Check(line.Qty > 0, key+".qty", "must be greater than zero")
+		v.Check(len(line.Unit) <= 16, key+".unit", "must not be more than 16 bytes long")
+		seen[line.IngredientId] = true
+	}
+}
+
+// Get returns the recipe of a product, which has no lines when the product has no
+// recipe. It returns ErrRecordNotFound when the product does not exist.
+func (m RecipeModule) Get(productId int) (*Recipe, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`
+	if err := m.DB.QueryRowContext(ctx, query, productId).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	lines, err := recipeLines(ctx, m.DB, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	recipe := &Recipe{ProductId: productId, Lines: lines}
+	for _, line := range lines {
+		recipe.Cost += line.Cost
+	}
+	return recipe, nil
+}
+
+// Set replaces the recipe of a product. No lines removes it, after which sales take the
+// product itself out of stock again.
+func (m RecipeModule) Set(recipe *Recipe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var isIngredient bool
+	query := `
+			SELECT EXISTS (SELECT 1 FROM recipe_lines WHERE ingredient_id = products.id)
+			FROM products
+			WHERE id = $1
+			FOR UPDATE
+			`
+	if err := tx.QueryRowContext(ctx, query, recipe.ProductId).Scan(&isIngredient); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if isIngredient && len(recipe.Lines) > 0 {
+		return fmt.Errorf("%w: product %d is an ingredient", ErrNestedRecipe, recipe.ProductId)
+	}
+
+	ingredientIds := make([]int64, len(recipe.Lines))
+	for i, line := range recipe.Lines {
+		ingredientIds[i] = int64(line.IngredientId)
+	}
+	if err := productsExist(ctx, tx, ingredientIds); err != nil {
+		return err
+	}
+
+	query = `
+			SELECT serialized, EXISTS (SELECT 1 FROM recipe_lines WHERE product_id = products.id)
+			FROM products
+			WHERE id = $1
+			`
+	for _, line := range recipe.Lines {
+		var serialized, hasRecipe bool
+		if err := tx.QueryRowContext(ctx, query, line.IngredientId).Scan(&serialized, &hasRecipe); err != nil {
+			return err
+		}
+		if serialized {
+			return fmt.Errorf("%w: %d", ErrSerializedIngredient, line.IngredientId)
+		}
+		if hasRecipe {
+			return fmt.Errorf("%w: ingredient %d has a recipe", ErrNestedRecipe, line.IngredientId)
+		}
+		if _, err := unitFactor(ctx, tx, line.IngredientId, line.Unit); err != nil {
+			return err
+		}
+	}
+
+	query = `DELETE FROM recipe_lines WHERE product_id = $1`
+	if _, err := tx.ExecContext(ctx, query, recipe.ProductId); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO recipe_lines (product_id, ingredient_id, qty, unit) VALUES ($1, $2, $3, $4)`
+	for _, line := range recipe.Lines {
+		if _, err := tx.ExecContext(ctx, query, recipe.ProductId, line.IngredientId, line.Qty, line.Unit); err != nil {
+			return err
+		}
+	}
+
+	recipe.Lines, err = recipeLines(ctx, tx, recipe.ProductId)
+	if err != nil {
+		return err
+	}
+	recipe.Cost = 0
+	for _, line := range recipe.Lines {
+		recipe.Cost += line.Cost
+	}
+
+	return tx.Commit()
+}
+
+// recipeLines returns the ingredients of one unit of a product, by name.
+func recipeLines(ctx context.Context, q queryer, productId int) ([]RecipeLine, error) {
+	query := `
+			SELECT r.ingredient_id, p.name, r.qty, r.unit,
+				r.qty * COALESCE((SELECT factor FROM product_units u WHERE u.product_id = p.id AND u.name = r.unit), 1) * p.cost
+			FROM recipe_lines r
+			JOIN products p ON p.id = r.ingredient_id
+			WHERE r.product_id = $1
+			ORDER BY p.name, r.ingredient_id
+			`
+	rows, err := q.QueryContext(ctx, query, productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []RecipeLine{}
+	for rows.Next() {
+		var line RecipeLine
+		if err := rows.Scan(&line.IngredientId, &line.Name, &line.Qty, &line.Unit, &line.Cost); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+// applyRecipe records, instead of m, a movement of each ingredient of m's product when it
+// has a recipe, and reports whether it did. Only movements of order lines are expanded:
+// the sale, removal, void or refund of a product made to a recipe moves its ingredients.
+// m then carries the ingredient movements, is valued at their total and sets the cost of
+// goods sold of its line; it stays out of the ledger itself. A sale keeps the ingredients
+// on its line, and stock coming back puts back those ingredients rather than what the
+// recipe holds now. Lines sold before ingredients were kept fall back to the recipe.
+func applyRecipe(ctx context.Context, tx *sql.Tx, m *StockMovement) (bool, error) {
+	if m.line == nil {
+		return false, nil
+	}
+
+	lines := m.line.Ingredients
+	if m.QtyDelta < 0 {
+		// What a sale used is only ever taken from the recipe.
+		m.line.Ingredients = nil
+	}
+	if m.QtyDelta < 0 || len(lines) == 0 {
+		recipe, err := recipeLines(ctx, tx, m.ProductId)
+		if err != nil || len(recipe) == 0 {
+			return false, err
+		}
+		lines = make([]LineIngredient, len(recipe))
+		for i, line := range recipe {
+			lines[i] = LineIngredient{IngredientId: line.IngredientId, Qty: line.Qty, Unit: line.Unit}
+		}
+	}
+
+	m.Ingredients = make([]*StockMovement, 0, len(lines))
+	m.costValue = 0
+	for _, line := range lines {
+		ingredient := &StockMovement{
+			ProductId:  line.IngredientId,
+			StoreId:    m.StoreId,
+			Type:       m.Type,
+			QtyDelta:   m.QtyDelta * line.Qty,
+			Reason:     m.Reason,
+			EmployeeId: m.EmployeeId,
+			SourceType: m.SourceType,
+			SourceId:   m.SourceId,
+			originType: m.originType,
+			originId:   m.originId,
+			Unit:       line.Unit,
+			offline:    m.offline,
+		}
+		if err := recordMovement(ctx, tx, ingredient); err != nil {
+			return false, err
+		}
+		m.Ingredients = append(m.Ingredients, ingredient)
+		m.costValue += ingredient.costValue
+	}
+
+	qty := m.QtyDelta
+	if qty < 0 {
+		qty = -qty
+	}
+	unitCost := roundDiv(int64(m.costValue), int64(qty))
+	m.UnitCost = &unitCost
+	if m.QtyDelta < 0 {
+		m.line.setCost(m.costValue)
+		m.line.Ingredients = lines
+	}
+
+	return true, nil
+}
+
+// UsageLine compares how much of an ingredient sales say was used since the previous
+// stocktake of the store with how much the count shows was used. A positive Variance is
+// usage beyond the recipes, valued at the ingredient's price when counting started.
+type UsageLine struct {
+	ProductId      int      `json:"product_id"`
+	Name           string   `json:"name"`
+	TheoreticalQty int      `json:"theoretical_qty"`
+	ActualQty      int      `json:"actual_qty"`
+	Variance       int      `json:"variance"`
+	VariancePct    *float64 `json:"variance_percent"`
+	Value          int      `json:"value"`
+}
+
+type UsageReport struct {
+	Stocktake *Stocktake  `json:"stocktake"`
+	From      *time.Time  `json:"from"`
+	To        time.Time   `json:"to"`
+	Lines     []UsageLine `json:"lines"`
+	Value     int         `json:"value"`
+}
+
+// Usage reports theoretical against actual usage of the recipe ingredients counted in a
+// stocktake. The period runs from the start of the store's previous finalized stocktake
+// (or from the beginning) to the start of this one. Theoretical usage is what sales and
+// refunds took out of stock in that period; actual usage adds the shortfall the count
+// found, and takes off the surplus.
+func (s StocktakeModule) Usage(id int) (*UsageReport, error) {
+	stocktake, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report := &UsageReport{Stocktake: stocktake, To: stocktake.CreatedAt, Lines: []UsageLine{}}
+	query := `
+			SELECT max(created_at)
+			FROM stocktakes
+			WHERE store_id = $1 AND status = 'finalized' AND created_at < $2 AND id <> $3
+			`
+	if err := s.DB.QueryRowContext(ctx, query, stocktake.StoreId, stocktake.CreatedAt, id).Scan(&report.From); err != nil {
+		return nil, err
+	}
+
+	query = `
+			SELECT l.product_id, l.name, l.counted_qty - l.expected_qty, l.unit_value,
+				COALESCE((SELECT -SUM(sm.qty_delta) FROM stock_movements sm
+					WHERE sm.product_id = l.product_id AND sm.store_id = $2 AND sm.type IN ('sale', 'refund')
+						AND sm.created_at < $3 AND ($4::timestamp IS NULL OR sm.created_at >= $4)), 0)
+			FROM stocktake_lines l
+			WHERE l.stocktake_id = $1 AND l.counted_qty IS NOT NULL
+				AND EXISTS (SELECT 1 FROM recipe_lines WHERE ingredient_id = l.product_id)
+			ORDER BY l.name, l.product_id
+			`
+	rows, err := s.DB.QueryContext(ctx, query, id, stocktake.StoreId, report.To, report.From)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line UsageLine
+		var countVariance, unitValue int
+		if err := rows.Scan(&line.ProductId, &line.Name, &countVariance, &unitValue, &line.TheoreticalQty); err != nil {
+			return nil, err
+		}
+		line.Variance = -countVariance
+		line.ActualQty = line.TheoreticalQty + line.Variance
+		line.Value = line.Variance * unitValue
+		if line.TheoreticalQty != 0 {
+			pct := float64(line.Variance) * 100 / float64(line.TheoreticalQty)
+			line.VariancePct = &pct
+		}
+		report.Value += line.Value
+		report.Lines = append(report.Lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
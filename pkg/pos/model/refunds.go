@@ -0,0 +1,199 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// ErrRefundExceedsSold is returned when a refund would bring back more of a product than
+// the order sold and has not refunded yet.
+var ErrRefundExceedsSold = errors.New("cannot refund more than was sold")
+
+type Refund struct {
+	Id         int            `json:"id"`
+	OrderId    int            `json:"order_id"`
+	EmployeeId int            `json:"employee_id"`
+	ShiftId    *int           `json:"shift_id"`
+	StationId  *int           `json:"station_id"`
+	Amount     float64        `json:"amount"`
+	Products   []OrderProduct `json:"products"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+type RefundModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Create stores the refund, puts the refunded products back in stock in the order's
+// store and reverses the commission earned on them. The order is locked while the refund
+// is checked against what it sold and has refunded before, so concurrent refunds cannot
+// together refund more than was sold.
+func (m RefundModule) Create(refund *Refund) ([]*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order := &Order{Id: refund.OrderId}
+	var soldJSON []byte
+	query := `SELECT store_id, products FROM orders WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, order.Id).Scan(&order.StoreId, &soldJSON); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	var sold []OrderProduct
+	if err := json.Unmarshal(soldJSON, &sold); err != nil {
+		return nil, err
+	}
+	refunded, err := refundedQty(ctx, tx, order.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range sold {
+		refunded[RefundKey{p.ProductId, p.Unit}] -= p.Qty
+	}
+	for _, p := range refund.Products {
+		key := RefundKey{p.ProductId, p.Unit}
+		refunded[key] += p.Qty
+		if refunded[key] > 0 {
+			return nil, fmt.Errorf("%w: product %s", ErrRefundExceedsSold, p.ProductId)
+		}
+	}
+
+	query = `
+			INSERT INTO refunds (order_id, employee_id, shift_id, station_id, amount, products)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at
+			`
+	productsJSON, err := json.Marshal(refund.Products)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{refund.OrderId, refund.EmployeeId, refund.ShiftId, refund.StationId, refund.Amount, productsJSON}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&refund.Id, &refund.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	employeeId := refund.EmployeeId
+	movements, err := OrderMovements(MovementRefund, 1, order, &employeeId, "", refund.Products)
+	if err != nil {
+		return nil, err
+	}
+	for _, mv := range movements {
+		mv.SourceType = "refund"
+		mv.SourceId = strconv.Itoa(refund.Id)
+	}
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, err
+	}
+	for _, p := range refund.Products {
+		if err := reverseCommissions(ctx, tx, refund.OrderId, RefundKey{p.ProductId, p.Unit}, p.Qty); err != nil {
+			return nil, err
+		}
+	}
+
+	return movements, tx.Commit()
+}
+
+func (m RefundModule) GetAllForOrder(orderId int) ([]Refund, error) {
+	query := `
+			SELECT id, order_id, employee_id, shift_id, station_id, amount, products, created_at
+			FROM refunds
+			WHERE order_id = $1
+			ORDER BY id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		var refund Refund
+		var productsJSON []byte
+
+		err := rows.Scan(&refund.Id, &refund.OrderId, &refund.EmployeeId, &refund.ShiftId, &refund.StationId, &refund.Amount, &productsJSON, &refund.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(productsJSON, &refund.Products); err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, refund)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// RefundKey identifies a product sold in one unit, which is what refunds are counted
+// against.
+type RefundKey struct {
+	ProductId string
+	Unit      string
+}
+
+// RefundedQty returns how many of each product and unit of the order have already been
+// refunded.
+func (m RefundModule) RefundedQty(orderId int) (map[RefundKey]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return refundedQty(ctx, m.DB, orderId)
+}
+
+func refundedQty(ctx context.Context, q queryer, orderId int) (map[RefundKey]int, error) {
+	query := `SELECT products FROM refunds WHERE order_id = $1`
+	rows, err := q.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	qty := make(map[RefundKey]int)
+	for rows.Next() {
+		var productsJSON []byte
+		if err := rows.Scan(&productsJSON); err != nil {
+			return nil, err
+		}
+
+		var products []OrderProduct
+		if err := json.Unmarshal(productsJSON, &products); err != nil {
+			return nil, err
+		}
+		for _, p := range products {
+			qty[RefundKey{p.ProductId, p.Unit}] += p.Qty
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return qty, nil
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LowStockProduct is a product at or below its reorder point together with how much
+// should be ordered to bring it back above it.
+type LowStockProduct struct {
+	Product
+	SuggestedQty int `json:"suggestedQty"`
+}
+
+// suggestedQty is the product's reorder quantity, or else enough to get back to the
+// reorder point plus one.
+func (p Product) suggestedQty() int {
+	if p.ReorderQty != nil {
+		return *p.ReorderQty
+	}
+	return *p.ReorderPoint - p.Amount + 1
+}
+
+func scanLowStock(rows *sql.Rows) ([]LowStockProduct, error) {
+	products := []LowStockProduct{}
+	for rows.Next() {
+		var prd LowStockProduct
+		if err := rows.Scan(productFields(&prd.Product)...); err != nil {
+			return nil, err
+		}
+		prd.SuggestedQty = prd.suggestedQty()
+		products = append(products, prd)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// GetLowStock returns every product at or below its reorder point, emptiest first.
+func (p ProductModule) GetLowStock() ([]LowStockProduct, error) {
+	query := `
+			SELECT ` + productColumns + `
+			FROM products
+			WHERE reorder_point IS NOT NULL AND amount <= reorder_point
+			ORDER BY amount - reorder_point, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLowStock(rows)
+}
+
+// ClaimLowStockAlerts marks products that dropped to their reorder point since the last
+// call as alerted and returns them. With webhook set their alerts are also left pending
+// delivery to the webhook. Stock movements unmark products that recover; products that no
+// longer have a reorder point are unmarked here. Either way they alert again on their
+// next dip.
+func (p ProductModule) ClaimLowStockAlerts(webhook bool) ([]LowStockProduct, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+			UPDATE products
+			SET low_stock_alerted_at = NULL, low_stock_webhook_pending = FALSE
+			WHERE low_stock_alerted_at IS NOT NULL AND (reorder_point IS NULL OR amount > reorder_point)
+			`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+
+	query = `
+			UPDATE products
+			SET low_stock_alerted_at = NOW(), low_stock_webhook_pending = $1
+			WHERE low_stock_alerted_at IS NULL AND reorder_point IS NOT NULL AND amount <= reorder_point
+			RETURNING ` + productColumns
+	rows, err := tx.QueryContext(ctx, query, webhook)
+	if err != nil {
+		return nil, err
+	}
+	products, err := scanLowStock(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return products, tx.Commit()
+}
+
+// GetPendingLowStockWebhooks returns the products whose low-stock alert has not been
+// delivered to the webhook yet, oldest alert first.
+func (p ProductModule) GetPendingLowStockWebhooks() ([]LowStockProduct, error) {
+	query := `
+			SELECT ` + productColumns + `
+			FROM products
+			WHERE low_stock_webhook_pending AND reorder_point IS NOT NULL
+			ORDER BY low_stock_alerted_at, id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLowStock(rows)
+}
+
+// LowStockWebhookDelivered marks the product's low-stock alert as delivered to the webhook.
+func (p ProductModule) LowStockWebhookDelivered(id int) error {
+	query := `UPDATE products SET low_stock_webhook_pending = FALSE WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,304 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+// Serial number statuses.
+const (
+	SerialInStock = "in_stock"
+	SerialSold    = "sold"
+	SerialRemoved = "removed"
+)
+
+var (
+	// ErrNotSerialized is returned when serials are given for a product that is not serialized.
+	ErrNotSerialized = errors.New("product is not serialized")
+	// ErrSerialRequired is returned when a sale or receipt of a serialized product does not
+	// give one serial per unit.
+	ErrSerialRequired = errors.New("serial numbers required")
+	// ErrSerialUnavailable is returned when a serial to take out of stock is not in stock.
+	ErrSerialUnavailable = errors.New("serial number is not in stock")
+	// ErrSerialInStock is returned when a serial to put into stock is in stock already.
+	ErrSerialInStock = errors.New("serial number is already in stock")
+)
+
+// SerialNumber is one unit of a serialized product. History lists the stock movements of
+// the unit, oldest first.
+type SerialNumber struct {
+	Id          int             `json:"id"`
+	ProductId   int             `json:"product_id"`
+	ProductName string          `json:"product_name"`
+	StoreId     int             `json:"store_id"`
+	Serial      string          `json:"serial"`
+	Status      string          `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	History     []StockMovement `json:"history,omitempty"`
+}
+
+// ValidateSerials checks the serials given with stock. Whether there is one per unit is
+// only known once the quantity is in base units, when the stock is recorded.
+func ValidateSerials(v *validator.Validator, key string, serials []string) {
+	v.Check(validator.Unique(serials), key, "must not contain duplicate values")
+	for _, serial := range serials {
+		v.Check(serial != "", key, "must not contain empty serials")
+		v.Check(len(serial) <= 64, key, "must not contain serials more than 64 bytes long")
+	}
+}
+
+// applySerials books the units of a serialized product that m moves, within tx, once m is
+// in the ledger. Sales and receipts must name one serial per unit; other movements may.
+// Stock coming back without serials brings back the units that the movements of
+// originType/originId took out. A unit taken out is sold by a sale and removed otherwise.
+// Offline sales, which already happened, are booked whatever their serials.
+func applySerials(ctx context.Context, tx *sql.Tx, m *StockMovement) error {
+	var serialized bool
+	query := `SELECT serialized FROM products WHERE id = $1`
+	if err := tx.QueryRowContext(ctx, query, m.ProductId).Scan(&serialized); err != nil {
+		return err
+	}
+	if !serialized {
+		if len(m.Serials) > 0 {
+			return fmt.Errorf("%w: %d", ErrNotSerialized, m.ProductId)
+		}
+		return nil
+	}
+
+	serials := m.Serials
+	if len(serials) == 0 && m.QtyDelta > 0 && m.originType != "" {
+		var err error
+		if serials, err = originSerials(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+
+	qty := m.QtyDelta
+	if qty < 0 {
+		qty = -qty
+	}
+	required := !m.offline && ((m.Type == MovementSale && m.QtyDelta < 0) || m.Type == MovementReceipt)
+	if len(serials) > qty || (required && len(serials) != qty) {
+		return fmt.Errorf("%w: product %d needs %d serials, got %d", ErrSerialRequired, m.ProductId, qty, len(serials))
+	}
+
+	for _, serial := range serials {
+		var id int
+		var err error
+		if m.QtyDelta > 0 {
+			id, err = serialIn(ctx, tx, m, serial)
+		} else {
+			id, err = serialOut(ctx, tx, m, serial)
+		}
+		if err != nil {
+			return err
+		}
+
+		query = `INSERT INTO stock_movement_serials (movement_id, serial_id) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, m.Id, id); err != nil {
+			return err
+		}
+	}
+
+	m.Serials = serials
+	return nil
+}
+
+// serialIn puts a unit into stock in m's store, registering it if it is new.
+func serialIn(ctx context.Context, tx *sql.Tx, m *StockMovement, serial string) (int, error) {
+	var id int
+	query := `
+			INSERT INTO serial_numbers (product_id, store_id, serial)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (product_id, serial) DO UPDATE
+			SET status = 'in_stock', store_id = EXCLUDED.store_id, updated_at = NOW()
+			WHERE serial_numbers.status <> 'in_stock'
+			RETURNING id
+			`
+	err := tx.QueryRowContext(ctx, query, m.ProductId, m.StoreId, serial).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: product %d serial %q", ErrSerialInStock, m.ProductId, serial)
+	}
+	return id, err
+}
+
+// serialOut takes a unit in stock out of stock in m's store. An offline sale of a unit
+// that is not in stock records the unit as sold anyway.
+func serialOut(ctx context.Context, tx *sql.Tx, m *StockMovement, serial string) (int, error) {
+	status := SerialRemoved
+	if m.Type == MovementSale {
+		status = SerialSold
+	}
+
+	var id int
+	query := `
+			UPDATE serial_numbers
+			SET status = $3, store_id = $4, updated_at = NOW()
+			WHERE product_id = $1 AND serial = $2 AND status = 'in_stock'
+			RETURNING id
+			`
+	err := tx.QueryRowContext(ctx, query, m.ProductId, serial, status, m.StoreId).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	if !m.offline {
+		return 0, fmt.Errorf("%w: product %d serial %q", ErrSerialUnavailable, m.ProductId, serial)
+	}
+
+	query = `
+			INSERT INTO serial_numbers (product_id, store_id, serial, status)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (product_id, serial) DO UPDATE
+			SET status = EXCLUDED.status, store_id = EXCLUDED.store_id, updated_at = NOW()
+			RETURNING id
+			`
+	err = tx.QueryRowContext(ctx, query, m.ProductId, m.StoreId, serial, status).Scan(&id)
+	return id, err
+}
+
+// originSerials returns up to m.QtyDelta serials that the outgoing movements m reverses
+// took out of stock and that have not moved since.
+func originSerials(ctx context.Context, tx *sql.Tx, m *StockMovement) ([]string, error) {
+	query := `
+			SELECT s.serial
+			FROM stock_movement_serials ms
+			JOIN stock_movements sm ON sm.id = ms.movement_id
+			JOIN serial_numbers s ON s.id = ms.serial_id
+			WHERE sm.source_type = $1 AND sm.source_id = $2 AND sm.product_id = $3 AND sm.qty_delta < 0
+				AND s.status <> 'in_stock'
+				AND NOT EXISTS (SELECT 1 FROM stock_movement_serials later
+					WHERE later.serial_id = ms.serial_id AND later.movement_id > ms.movement_id)
+			ORDER BY ms.movement_id, s.id
+			LIMIT $4
+			`
+	rows, err := tx.QueryContext(ctx, query, m.originType, m.originId, m.ProductId, m.QtyDelta)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var serials []string
+	for rows.Next() {
+		var serial string
+		if err := rows.Scan(&serial); err != nil {
+			return nil, err
+		}
+		serials = append(serials, serial)
+	}
+
+	return serials, rows.Err()
+}
+
+const serialColumns = `serial_numbers.id, serial_numbers.product_id, products.name, serial_numbers.store_id,
+		serial_numbers.serial, serial_numbers.status, serial_numbers.created_at, serial_numbers.updated_at`
+
+func serialFields(s *SerialNumber) []interface{} {
+	return []interface{}{&s.Id, &s.ProductId, &s.ProductName, &s.StoreId, &s.Serial, &s.Status, &s.CreatedAt, &s.UpdatedAt}
+}
+
+// GetSerials returns the units of a product, optionally with one status only and in one
+// store only (storeId > 0).
+func (s StockModule) GetSerials(productId int, status string, storeId int, filters Filters) ([]SerialNumber, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + serialColumns + `
+			FROM serial_numbers
+			JOIN products ON products.id = serial_numbers.product_id
+			WHERE serial_numbers.product_id = $1 AND (serial_numbers.status = $2 OR $2 = '')
+				AND (serial_numbers.store_id = $3 OR $3 = 0)
+			ORDER BY serial_numbers.serial
+			LIMIT $4 OFFSET $5
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, productId, status, storeId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	serials := []SerialNumber{}
+	for rows.Next() {
+		var serial SerialNumber
+		if err := rows.Scan(append([]interface{}{&totalRecords}, serialFields(&serial)...)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		serials = append(serials, serial)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return serials, metadata, nil
+}
+
+// LookupSerial returns every unit with the given serial, usually one, each with its full
+// movement history. It returns ErrRecordNotFound when there is none.
+func (s StockModule) LookupSerial(serial string) ([]SerialNumber, error) {
+	query := `
+			SELECT ` + serialColumns + `
+			FROM serial_numbers
+			JOIN products ON products.id = serial_numbers.product_id
+			WHERE serial_numbers.serial = $1
+			ORDER BY serial_numbers.id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, serial)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var serials []SerialNumber
+	for rows.Next() {
+		var sn SerialNumber
+		if err := rows.Scan(serialFields(&sn)...); err != nil {
+			return nil, err
+		}
+		serials = append(serials, sn)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(serials) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	query = `
+			SELECT ` + movementColumns + `
+			FROM stock_movements
+			WHERE id IN (SELECT movement_id FROM stock_movement_serials WHERE serial_id = $1)
+			ORDER BY id
+			`
+	for i := range serials {
+		history, err := s.DB.QueryContext(ctx, query, serials[i].Id)
+		if err != nil {
+			return nil, err
+		}
+		for history.Next() {
+			var m StockMovement
+			if err := history.Scan(movementFields(&m)...); err != nil {
+				history.Close()
+				return nil, err
+			}
+			serials[i].History = append(serials[i].History, m)
+		}
+		history.Close()
+		if err := history.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return serials, nil
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrShiftAlreadyOpen is returned when a shift is opened while another one is still open.
+var ErrShiftAlreadyOpen = errors.New("a shift is already open")
+
+type Shift struct {
+	Id          int        `json:"id"`
+	EmployeeId  int        `json:"employee_id"`
+	StationId   *int       `json:"station_id"`
+	OpeningCash float64    `json:"opening_cash"`
+	CountedCash *float64   `json:"counted_cash"`
+	OpenedAt    time.Time  `json:"opened_at"`
+	ClosedAt    *time.Time `json:"closed_at"`
+}
+
+// ZReport is the end-of-shift summary of sales and drawer cash.
+type ZReport struct {
+	Shift        Shift    `json:"shift"`
+	OrderCount   int      `json:"order_count"`
+	SalesTotal   float64  `json:"sales_total"`
+	CashSales    float64  `json:"cash_sales"`
+	Refunds      float64  `json:"refunds"`
+	PayIns       float64  `json:"pay_ins"`
+	PayOuts      float64  `json:"pay_outs"`
+	Drops        float64  `json:"drops"`
+	ExpectedCash float64  `json:"expected_cash"`
+	CountedCash  *float64 `json:"counted_cash"`
+	OverShort    *float64 `json:"over_short"`
+}
+
+type ShiftModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+const shiftColumns = `id, employee_id, station_id, opening_cash, counted_cash, opened_at, closed_at`
+
+func scanShift(row interface{ Scan(...interface{}) error }, shift *Shift) error {
+	return row.Scan(&shift.Id, &shift.EmployeeId, &shift.StationId, &shift.OpeningCash, &shift.CountedCash, &shift.OpenedAt, &shift.ClosedAt)
+}
+
+// isUniqueViolation reports whether err is PostgreSQL rejecting a row that would break the
+// unique constraint or index named constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+func (s ShiftModule) Open(shift *Shift) error {
+	query := `
+			INSERT INTO shifts (employee_id, station_id, opening_cash)
+			VALUES ($1, $2, $3)
+			RETURNING ` + shiftColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanShift(s.DB.QueryRowContext(ctx, query, shift.EmployeeId, shift.StationId, shift.OpeningCash), shift)
+	if isUniqueViolation(err, "shifts_single_open_idx") {
+		return ErrShiftAlreadyOpen
+	}
+	return err
+}
+
+func (s ShiftModule) Get(id int) (*Shift, error) {
+	query := `SELECT ` + shiftColumns + ` FROM shifts WHERE id = $1`
+
+	var shift Shift
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanShift(s.DB.QueryRowContext(ctx, query, id), &shift)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+// GetOpen returns the open shift of a station (or of the station-less drawer when stationId
+// is nil), or ErrRecordNotFound if the drawer is closed.
+func (s ShiftModule) GetOpen(stationId *int) (*Shift, error) {
+	query := `
+			SELECT ` + shiftColumns + `
+			FROM shifts
+			WHERE closed_at IS NULL AND COALESCE(station_id, 0) = COALESCE($1, 0)
+			`
+
+	var shift Shift
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanShift(s.DB.QueryRowContext(ctx, query, stationId), &shift)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+func (s ShiftModule) Close(id int, countedCash float64) (*Shift, error) {
+	query := `
+			UPDATE shifts
+			SET counted_cash = $1, closed_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND closed_at IS NULL
+			RETURNING ` + shiftColumns
+
+	var shift Shift
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanShift(s.DB.QueryRowContext(ctx, query, countedCash, id), &shift)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+// ZReport sums the orders, refunds and cash movements attributed to the shift and works
+// out how much cash should be in the drawer.
+func (s ShiftModule) ZReport(id int) (*ZReport, error) {
+	shift, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ZReport{Shift: *shift, CountedCash: shift.CountedCash}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+			SELECT count(*), COALESCE(SUM(total_price), 0), COALESCE(SUM(total_paid - total_return), 0)
+			FROM orders
+			WHERE shift_id = $1
+			`
+	err = s.DB.QueryRowContext(ctx, query, id).Scan(&report.OrderCount, &report.SalesTotal, &report.CashSales)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+			SELECT
+				COALESCE(SUM(amount) FILTER (WHERE type = $2), 0),
+				COALESCE(SUM(amount) FILTER (WHERE type = $3), 0),
+				COALESCE(SUM(amount) FILTER (WHERE type = $4), 0)
+			FROM cash_movements
+			WHERE shift_id = $1
+			`
+	args := []interface{}{id, CashPayIn, CashPayOut, CashDrop}
+	err = s.DB.QueryRowContext(ctx, query, args...).Scan(&report.PayIns, &report.PayOuts, &report.Drops)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE shift_id = $1`
+	err = s.DB.QueryRowContext(ctx, query, id).Scan(&report.Refunds)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ExpectedCash = shift.OpeningCash + report.CashSales - report.Refunds + report.PayIns - report.PayOuts - report.Drops
+	if shift.CountedCash != nil {
+		overShort := *shift.CountedCash - report.ExpectedCash
+		report.OverShort = &overShort
+	}
+
+	return &report, nil
+}
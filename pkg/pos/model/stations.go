@@ -0,0 +1,292 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+const (
+	StationActive   = "active"
+	StationInactive = "inactive"
+	StationRetired  = "retired"
+)
+
+// ErrStationNotActive is returned when an inactive or retired station is registered.
+var ErrStationNotActive = errors.New("station is not active")
+
+// Station is a register (till) that produces orders, shifts and receipts.
+type Station struct {
+	Id             int             `json:"id"`
+	Name           string          `json:"name"`
+	StoreId        int             `json:"store_id"`
+	PrinterConfig  json.RawMessage `json:"printer_config"`
+	ReceiptPrefix  string          `json:"receipt_prefix"`
+	Status         string          `json:"status"`
+	DisplayContent json.RawMessage `json:"display_content"`
+	RegisteredAt   *time.Time      `json:"registered_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+type StationModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateStation(v *validator.Validator, station *Station) {
+	v.Check(station.Name != "", "name", "must be provided")
+	v.Check(station.StoreId >= 0, "store_id", "must be a valid store")
+	v.Check(len(station.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(len(station.ReceiptPrefix) <= 16, "receipt_prefix", "must not be more than 16 bytes long")
+	v.Check(validator.In(station.Status, StationActive, StationInactive, StationRetired), "status", "must be active, inactive or retired")
+	v.Check(len(station.PrinterConfig) == 0 || json.Valid(station.PrinterConfig), "printer_config", "must be a JSON object")
+	v.Check(len(station.DisplayContent) == 0 || json.Valid(station.DisplayContent), "display_content", "must be a JSON object")
+}
+
+const stationColumns = `id, name, store_id, printer_config, receipt_prefix, status, display_content, registered_at, created_at, updated_at`
+
+func scanStation(row interface{ Scan(...interface{}) error }, station *Station) error {
+	var printerConfig, displayContent []byte
+	err := row.Scan(&station.Id, &station.Name, &station.StoreId, &printerConfig, &station.ReceiptPrefix,
+		&station.Status, &displayContent, &station.RegisteredAt, &station.CreatedAt, &station.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	station.PrinterConfig = json.RawMessage(printerConfig)
+	station.DisplayContent = json.RawMessage(displayContent)
+	return nil
+}
+
+func (station *Station) setDefaults() {
+	if len(station.PrinterConfig) == 0 {
+		station.PrinterConfig = json.RawMessage(`{}`)
+	}
+	if len(station.DisplayContent) == 0 {
+		station.DisplayContent = json.RawMessage(`{}`)
+	}
+}
+
+// Create adds a station to its store, or to the default store when StoreId is zero.
+func (s StationModule) Create(station *Station) error {
+	query := `
+			INSERT INTO stations (name, store_id, printer_config, receipt_prefix, status, display_content)
+			VALUES ($1, COALESCE(NULLIF($2, 0), (SELECT id FROM stores WHERE is_default)), $3, $4, $5, $6)
+			RETURNING id, store_id, created_at, updated_at
+			`
+	station.setDefaults()
+
+	args := []interface{}{station.Name, station.StoreId, []byte(station.PrinterConfig), station.ReceiptPrefix, station.Status,
+		[]byte(station.DisplayContent)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&station.Id, &station.StoreId, &station.CreatedAt, &station.UpdatedAt)
+	if isForeignKeyViolation(err) {
+		return ErrUnknownStore
+	}
+	return err
+}
+
+func (s StationModule) Get(id int) (*Station, error) {
+	query := `SELECT ` + stationColumns + ` FROM stations WHERE id = $1`
+
+	var station Station
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStation(s.DB.QueryRowContext(ctx, query, id), &station)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &station, nil
+}
+
+func (s StationModule) GetAll() ([]Station, error) {
+	query := `SELECT ` + stationColumns + ` FROM stations ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stations := []Station{}
+	for rows.Next() {
+		var station Station
+		if err := scanStation(rows, &station); err != nil {
+			return nil, err
+		}
+		stations = append(stations, station)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stations, nil
+}
+
+func (s StationModule) Update(id int, station *Station) error {
+	query := `
+			UPDATE stations
+			SET name = $1, store_id = COALESCE(NULLIF($2, 0), store_id), printer_config = $3, receipt_prefix = $4, status = $5, display_content = $6,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $7
+			RETURNING ` + stationColumns
+
+	station.setDefaults()
+
+	args := []interface{}{station.Name, station.StoreId, []byte(station.PrinterConfig), station.ReceiptPrefix, station.Status,
+		[]byte(station.DisplayContent), id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStation(s.DB.QueryRowContext(ctx, query, args...), station)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case isForeignKeyViolation(err):
+		return ErrUnknownStore
+	}
+	return err
+}
+
+func (s StationModule) Delete(id int) error {
+	query := `DELETE FROM stations WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Register issues a new device credential for the station, replacing any previous one.
+// Only the hash is stored, so the plaintext is returned to be handed to the device once.
+func (s StationModule) Register(id int) (string, *Station, error) {
+	query := `
+			UPDATE stations
+			SET credential_hash = $1, registered_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND status = $3
+			RETURNING ` + stationColumns
+
+	return s.issueCredential(query, id)
+}
+
+// IssueDisplayCredential issues the credential of the station's customer-facing display,
+// replacing any previous one. It only grants access to the display feed.
+func (s StationModule) IssueDisplayCredential(id int) (string, *Station, error) {
+	query := `
+			UPDATE stations
+			SET display_credential_hash = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND status = $3
+			RETURNING ` + stationColumns
+
+	return s.issueCredential(query, id)
+}
+
+func (s StationModule) issueCredential(query string, id int) (string, *Station, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	var station Station
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStation(s.DB.QueryRowContext(ctx, query, hash[:], id, StationActive), &station)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			if _, getErr := s.Get(id); getErr != nil {
+				return "", nil, getErr
+			}
+			return "", nil, ErrStationNotActive
+		}
+		return "", nil, err
+	}
+
+	return plaintext, &station, nil
+}
+
+// GetForCredential returns the active station a device credential was issued to.
+func (s StationModule) GetForCredential(plaintext string) (*Station, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `SELECT ` + stationColumns + ` FROM stations WHERE credential_hash = $1 AND status = $2`
+
+	var station Station
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStation(s.DB.QueryRowContext(ctx, query, hash[:], StationActive), &station)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &station, nil
+}
+
+// GetForDisplayCredential returns the active station a display credential was issued to.
+func (s StationModule) GetForDisplayCredential(plaintext string) (*Station, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `SELECT ` + stationColumns + ` FROM stations WHERE display_credential_hash = $1 AND status = $2`
+
+	var station Station
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStation(s.DB.QueryRowContext(ctx, query, hash[:], StationActive), &station)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &station, nil
+}
+
+// NextReceiptNumber reserves the station's next receipt number, e.g. "A1-000042".
+func (s StationModule) NextReceiptNumber(id int) (string, error) {
+	query := `
+			UPDATE stations
+			SET receipt_seq = receipt_seq + 1
+			WHERE id = $1
+			RETURNING receipt_prefix, receipt_seq
+			`
+
+	var prefix string
+	var seq int
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, id).Scan(&prefix, &seq)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%06d", prefix, seq), nil
+}
@@ -0,0 +1,358 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+
+	"github.com/lib/pq"
+)
+
+// Stock movement types.
+const (
+	MovementSale       = "sale"
+	MovementRefund     = "refund"
+	MovementReceipt    = "receipt"
+	MovementAdjustment = "adjustment"
+	MovementTransfer   = "transfer"
+	MovementStocktake  = "stocktake"
+)
+
+// ErrUnknownProduct is returned when stock is moved for a product that does not exist.
+var ErrUnknownProduct = errors.New("product does not exist")
+
+// StockMovement is one immutable entry of the inventory ledger. A product's stock in a
+// store is the sum of its movements there; BalanceAfter is that stock right after this
+// movement. A zero StoreId records the movement in the default store. UnitCost is what
+// one unit moved was valued at; it may be given for stock coming in. Lots are the lots of
+// a lot-tracked product the movement went into or came out of; they may be given for
+// stock coming in. Serials are the units of a serialized product the movement moved. The
+// movement of an order line for a product made to a recipe is not in the ledger: it moves
+// the Ingredients instead. A movement may be given in another Unit of the product than its
+// base unit; recording converts it, keeping the quantity as given in UnitQty.
+type StockMovement struct {
+	Id           int64            `json:"id"`
+	ProductId    int              `json:"product_id"`
+	StoreId      int              `json:"store_id"`
+	Type         string           `json:"type"`
+	QtyDelta     int              `json:"qty_delta"`
+	BalanceAfter int              `json:"balance_after"`
+	UnitCost     *int             `json:"unit_cost"`
+	Reason       string           `json:"reason"`
+	EmployeeId   *int             `json:"employee_id"`
+	SourceType   string           `json:"source_type,omitempty"`
+	SourceId     string           `json:"source_id,omitempty"`
+	Lots         []LotAllocation  `json:"lots,omitempty"`
+	Serials      []string         `json:"serials,omitempty"`
+	Ingredients  []*StockMovement `json:"ingredients,omitempty"`
+	Unit         string           `json:"unit,omitempty"`
+	UnitQty      *int             `json:"unit_qty,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+
+	// costValue is the value of the whole movement, and line the order line whose cost of
+	// goods sold it sets. Stock coming back goes into the lots, and brings back the units,
+	// that the movements of originType/originId took out. offline marks a sale that already
+	// happened, which takes expired lots and unavailable serials.
+	costValue  int
+	line       *OrderProduct
+	originType string
+	originId   string
+	offline    bool
+}
+
+type StockModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// ValidateManualMovement checks a movement entered by hand. Sales, refunds, transfers
+// and stocktakes are only recorded by the operations they belong to.
+func ValidateManualMovement(v *validator.Validator, m *StockMovement) {
+	v.Check(validator.In(m.Type, MovementReceipt, MovementAdjustment), "type", "must be receipt or adjustment")
+	v.Check(m.QtyDelta != 0, "qty_delta", "must not be zero")
+	v.Check(m.Type != MovementReceipt || m.QtyDelta > 0, "qty_delta", "must be greater than zero for a receipt")
+	v.Check(m.Reason != "", "reason", "must be provided")
+	v.Check(len(m.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	v.Check(m.UnitCost == nil || *m.UnitCost >= 0, "unit_cost", "must not be negative")
+	v.Check(m.UnitCost == nil || m.QtyDelta > 0, "unit_cost", "can only be given for stock coming in")
+	v.Check(len(m.Lots) == 0 || m.QtyDelta > 0, "lot_number", "can only be given for stock coming in")
+	for _, lot := range m.Lots {
+		ValidateLot(v, "", lot.LotNumber, lot.ExpiresOn)
+	}
+	v.Check(len(m.Unit) <= 16, "unit", "must not be more than 16 bytes long")
+	ValidateSerials(v, "serials", m.Serials)
+}
+
+// StoreStock is a product's stock in one store.
+type StoreStock struct {
+	StoreId        int        `json:"storeId"`
+	Amount         int        `json:"amount"`
+	StockFlaggedAt *time.Time `json:"stockFlaggedAt"`
+}
+
+// OrderMovements turns order lines into stock movements in the order's store. sign is -1
+// for stock leaving (a sale), in which case recording the movements sets the cost of goods
+// sold on the lines, and 1 for stock coming back (a removed line, void or refund) at the
+// cost it was sold at.
+func OrderMovements(movementType string, sign int, order *Order, employeeId *int, reason string, lines []OrderProduct) ([]*StockMovement, error) {
+	movements := make([]*StockMovement, 0, len(lines))
+	for i := range lines {
+		line := &lines[i]
+		productId, err := strconv.Atoi(line.ProductId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownProduct, line.ProductId)
+		}
+		if line.Qty == 0 {
+			continue
+		}
+		movements = append(movements, &StockMovement{
+			ProductId:  productId,
+			StoreId:    order.StoreId,
+			Type:       movementType,
+			QtyDelta:   sign * line.Qty,
+			Reason:     reason,
+			EmployeeId: employeeId,
+			SourceType: "order",
+			SourceId:   strconv.Itoa(order.Id),
+			Serials:    line.Serials,
+			Unit:       line.Unit,
+			line:       line,
+		})
+		if sign > 0 {
+			m := movements[len(movements)-1]
+			m.lotOrigin("order", order.Id)
+			if line.UnitCost != nil {
+				unitCost := *line.UnitCost
+				m.UnitCost = &unitCost
+			}
+		}
+	}
+	return movements, nil
+}
+
+// resolveStore returns storeId if that store exists, or the default store's ID when
+// storeId is zero.
+func resolveStore(ctx context.Context, tx *sql.Tx, storeId int) (int, error) {
+	var id int
+	query := `SELECT id FROM stores WHERE id = $1 OR ($1 = 0 AND is_default)`
+	err := tx.QueryRowContext(ctx, query, storeId).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %d", ErrUnknownStore, storeId)
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// recordMovement applies a movement to the product's stock in its store and to the
+// product's total amount, and appends it to the ledger inside tx. Stock driven below zero
+// in a store is flagged for a recount; the flag clears once it is back at zero or above.
+// The product itself is flagged while any of its stores is. The movement is valued by
+// applyCost and booked into lots and serials by applyLots and applySerials.
+func recordMovement(ctx context.Context, tx *sql.Tx, m *StockMovement) error {
+	storeId, err := resolveStore(ctx, tx, m.StoreId)
+	if err != nil {
+		return err
+	}
+	m.StoreId = storeId
+
+	if err := applyUnit(ctx, tx, m); err != nil {
+		return err
+	}
+
+	if expanded, err := applyRecipe(ctx, tx, m); err != nil || expanded {
+		return err
+	}
+
+	if err := applyCost(ctx, tx, m); err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO product_stock (product_id, store_id, amount, stock_flagged_at)
+			SELECT id, $2, $3, CASE WHEN $3 < 0 THEN NOW() END
+			FROM products
+			WHERE id = $1
+			ON CONFLICT (product_id, store_id) DO UPDATE
+			SET amount = product_stock.amount + EXCLUDED.amount,
+				stock_flagged_at = CASE WHEN product_stock.amount + EXCLUDED.amount < 0
+					THEN COALESCE(product_stock.stock_flagged_at, NOW()) END,
+				updated_at = NOW()
+			RETURNING amount
+			`
+	err = tx.QueryRowContext(ctx, query, m.ProductId, m.StoreId, m.QtyDelta).Scan(&m.BalanceAfter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, m.ProductId)
+		}
+		return err
+	}
+
+	query = `
+			UPDATE products
+			SET amount = amount + $2,
+				stock_flagged_at = CASE WHEN EXISTS (SELECT 1 FROM product_stock WHERE product_id = $1 AND amount < 0)
+					THEN COALESCE(stock_flagged_at, NOW()) END,
+				low_stock_alerted_at = CASE WHEN amount + $2 > reorder_point THEN NULL ELSE low_stock_alerted_at END,
+				low_stock_webhook_pending = low_stock_webhook_pending AND NOT COALESCE(amount + $2 > reorder_point, FALSE)
+			WHERE id = $1
+			`
+	if _, err := tx.ExecContext(ctx, query, m.ProductId, m.QtyDelta); err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO stock_movements (product_id, store_id, type, qty_delta, balance_after, unit_cost, reason, employee_id, source_type, source_id,
+				unit, unit_qty)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id, created_at
+			`
+	args := []interface{}{m.ProductId, m.StoreId, m.Type, m.QtyDelta, m.BalanceAfter, m.UnitCost, m.Reason, m.EmployeeId, m.SourceType, m.SourceId,
+		m.Unit, m.UnitQty}
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&m.Id, &m.CreatedAt); err != nil {
+		return err
+	}
+
+	if err := applyLots(ctx, tx, m); err != nil {
+		return err
+	}
+
+	return applySerials(ctx, tx, m)
+}
+
+func recordMovements(ctx context.Context, tx *sql.Tx, movements []*StockMovement) error {
+	for _, m := range movements {
+		if err := recordMovement(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record applies the movements atomically.
+func (s StockModule) Record(movements ...*StockMovement) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const movementColumns = `id, product_id, store_id, type, qty_delta, balance_after, unit_cost, reason, employee_id, source_type, source_id,
+		unit, unit_qty, created_at`
+
+// movementFields returns the scan destinations matching movementColumns.
+func movementFields(m *StockMovement) []interface{} {
+	return []interface{}{&m.Id, &m.ProductId, &m.StoreId, &m.Type, &m.QtyDelta, &m.BalanceAfter, &m.UnitCost, &m.Reason,
+		&m.EmployeeId, &m.SourceType, &m.SourceId, &m.Unit, &m.UnitQty, &m.CreatedAt}
+}
+
+// GetForProduct returns a product's movements, newest first, optionally limited to one
+// movement type and to one store (storeId > 0).
+func (s StockModule) GetForProduct(productId int, movementType string, storeId int, filters Filters) ([]StockMovement, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + movementColumns + `,
+				(SELECT jsonb_agg(jsonb_build_object('lot_id', l.id, 'lot_number', l.lot_number,
+					'expires_on', to_char(l.expires_on, 'YYYY-MM-DD'), 'qty', a.qty) ORDER BY l.expires_on NULLS LAST, l.id)
+				FROM stock_movement_lots a JOIN lots l ON l.id = a.lot_id
+				WHERE a.movement_id = stock_movements.id),
+				(SELECT array_agg(s.serial ORDER BY s.serial)
+				FROM stock_movement_serials ms JOIN serial_numbers s ON s.id = ms.serial_id
+				WHERE ms.movement_id = stock_movements.id)
+			FROM stock_movements
+			WHERE product_id = $1 AND (type = $2 OR $2 = '') AND (store_id = $3 OR $3 = 0)
+			ORDER BY id DESC
+			LIMIT $4 OFFSET $5
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, productId, movementType, storeId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movements := []StockMovement{}
+	for rows.Next() {
+		var m StockMovement
+		var lots []byte
+		dest := append([]interface{}{&totalRecords}, movementFields(&m)...)
+		err := rows.Scan(append(dest, &lots, pq.Array(&m.Serials))...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if lots != nil {
+			if err := json.Unmarshal(lots, &m.Lots); err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+		movements = append(movements, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movements, metadata, nil
+}
+
+// GetLevels returns the stock of the given products per store, or in one store only when
+// storeId > 0. Products that never had stock in a store have no entry for it.
+func (s StockModule) GetLevels(productIds []int, storeId int) (map[int][]StoreStock, error) {
+	ids := make([]int64, len(productIds))
+	for i, id := range productIds {
+		ids[i] = int64(id)
+	}
+
+	query := `
+			SELECT product_id, store_id, amount, stock_flagged_at
+			FROM product_stock
+			WHERE product_id = ANY($1) AND (store_id = $2 OR $2 = 0)
+			ORDER BY product_id, store_id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, pq.Array(ids), storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := make(map[int][]StoreStock)
+	for rows.Next() {
+		var productId int
+		var level StoreStock
+		if err := rows.Scan(&productId, &level.StoreId, &level.Amount, &level.StockFlaggedAt); err != nil {
+			return nil, err
+		}
+		levels[productId] = append(levels[productId], level)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return levels, nil
+}
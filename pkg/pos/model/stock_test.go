@@ -0,0 +1,109 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestOrderMovements(t *testing.T) {
+	cost := 250
+	employeeId := 7
+	order := &Order{Id: 42, StoreId: 3}
+
+	tests := []struct {
+		name  string
+		sign  int
+		lines []OrderProduct
+		want  []StockMovement
+	}{
+		{
+			name:  "sale takes stock out",
+			sign:  -1,
+			lines: []OrderProduct{{ProductId: "1", Qty: 2}, {ProductId: "5", Qty: 1, Unit: "case"}},
+			want: []StockMovement{
+				{ProductId: 1, QtyDelta: -2},
+				{ProductId: 5, QtyDelta: -1, Unit: "case"},
+			},
+		},
+		{
+			name:  "lines without quantity are skipped",
+			sign:  -1,
+			lines: []OrderProduct{{ProductId: "1", Qty: 0}, {ProductId: "2", Qty: 3}},
+			want:  []StockMovement{{ProductId: 2, QtyDelta: -3}},
+		},
+		{
+			name:  "sale ignores the line cost",
+			sign:  -1,
+			lines: []OrderProduct{{ProductId: "1", Qty: 1, UnitCost: &cost}},
+			want:  []StockMovement{{ProductId: 1, QtyDelta: -1}},
+		},
+		{
+			name:  "stock coming back keeps units and cost",
+			sign:  1,
+			lines: []OrderProduct{{ProductId: "1", Qty: 2, UnitCost: &cost, Serials: []string{"A", "B"}}},
+			want:  []StockMovement{{ProductId: 1, QtyDelta: 2, UnitCost: &cost, Serials: []string{"A", "B"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OrderMovements(MovementSale, tt.sign, order, &employeeId, "reason", tt.lines)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d movements, want %d", len(got), len(tt.want))
+			}
+			for i, m := range got {
+				want := tt.want[i]
+				if m.ProductId != want.ProductId || m.QtyDelta != want.QtyDelta || m.Unit != want.Unit {
+					t.Errorf("movement %d: got product %d qty %d unit %q, want product %d qty %d unit %q",
+						i, m.ProductId, m.QtyDelta, m.Unit, want.ProductId, want.QtyDelta, want.Unit)
+				}
+				if !reflect.DeepEqual(m.UnitCost, want.UnitCost) {
+					t.Errorf("movement %d: got unit cost %v, want %v", i, m.UnitCost, want.UnitCost)
+				}
+				if !reflect.DeepEqual(m.Serials, want.Serials) {
+					t.Errorf("movement %d: got serials %v, want %v", i, m.Serials, want.Serials)
+				}
+				if m.StoreId != order.StoreId || m.SourceType != "order" || m.SourceId != "42" {
+					t.Errorf("movement %d: got store %d source %s/%s", i, m.StoreId, m.SourceType, m.SourceId)
+				}
+				if m.EmployeeId != &employeeId || m.Type != MovementSale || m.Reason != "reason" {
+					t.Errorf("movement %d: got employee %v type %q reason %q", i, m.EmployeeId, m.Type, m.Reason)
+				}
+				wantOrigin := ""
+				if tt.sign > 0 {
+					wantOrigin = "order"
+				}
+				if m.originType != wantOrigin {
+					t.Errorf("movement %d: got origin %q, want %q", i, m.originType, wantOrigin)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderMovementsCopiesUnitCost(t *testing.T) {
+	cost := 100
+	lines := []OrderProduct{{ProductId: "1", Qty: 1, UnitCost: &cost}}
+
+	got, err := OrderMovements(MovementRefund, 1, &Order{Id: 1}, nil, "", lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	*got[0].UnitCost = 1
+	if cost != 100 {
+		t.Errorf("changing the movement cost changed the line cost to %d", cost)
+	}
+}
+
+func TestOrderMovementsUnknownProduct(t *testing.T) {
+	lines := []OrderProduct{{ProductId: "abc", Qty: 1}}
+
+	_, err := OrderMovements(MovementSale, -1, &Order{Id: 1}, nil, "", lines)
+	if !errors.Is(err, ErrUnknownProduct) {
+		t.Errorf("got error %v, want ErrUnknownProduct", err)
+	}
+}
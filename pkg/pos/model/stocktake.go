@@ -0,0 +1,442 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+// Stocktake statuses.
+const (
+	StocktakeOpen      = "open"
+	StocktakeFinalized = "finalized"
+	StocktakeCancelled = "cancelled"
+)
+
+var (
+	// ErrStocktakeClosed is returned when counting or finalizing a stocktake that is no
+	// longer open.
+	ErrStocktakeClosed = errors.New("stocktake is no longer open")
+	// ErrNotInStocktake is returned when counting a product outside the stocktake's scope.
+	ErrNotInStocktake = errors.New("product is not part of the stocktake")
+)
+
+// Stocktake is a counting session in one store, over all products or those of one
+// category.
+type Stocktake struct {
+	Id          int        `json:"id"`
+	Name        string     `json:"name"`
+	StoreId     int        `json:"store_id"`
+	CategoryId  *int       `json:"category_id"`
+	Status      string     `json:"status"`
+	EmployeeId  *int       `json:"employee_id"`
+	Products    int        `json:"products"`
+	Counted     int        `json:"counted"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinalizedAt *time.Time `json:"finalized_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// StocktakeCount is one count entered for a product, in Unit or in the product's base
+// unit when that is empty. A recount replaces what was counted so far; other counts add
+// to it, so several devices can count the same product on different shelves, or cases
+// and loose units separately.
+type StocktakeCount struct {
+	ProductId int    `json:"product_id"`
+	Unit      string `json:"unit"`
+	Qty       int    `json:"qty"`
+	Recount   bool   `json:"recount"`
+}
+
+// VarianceLine compares what was counted with what was expected when the stocktake
+// started. Value is the variance at the product's price at that time.
+type VarianceLine struct {
+	ProductId   int        `json:"product_id"`
+	Name        string     `json:"name"`
+	ExpectedQty int        `json:"expected_qty"`
+	CountedQty  *int       `json:"counted_qty"`
+	Variance    int        `json:"variance"`
+	Value       int        `json:"value"`
+	Recounts    int        `json:"recounts"`
+	CountedAt   *time.Time `json:"counted_at"`
+}
+
+type VarianceReport struct {
+	Stocktake     *Stocktake     `json:"stocktake"`
+	Lines         []VarianceLine `json:"lines"`
+	Uncounted     int            `json:"uncounted"`
+	UnitsVariance int            `json:"units_variance"`
+	ValueVariance int            `json:"value_variance"`
+}
+
+type StocktakeModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateStocktake(v *validator.Validator, stocktake *Stocktake) {
+	v.Check(stocktake.Name != "", "name", "must be provided")
+	v.Check(len(stocktake.Name) <= 255, "name", "must not be more than 255 bytes long")
+	v.Check(stocktake.StoreId >= 0, "store_id", "must be a valid store")
+	v.Check(stocktake.CategoryId == nil || *stocktake.CategoryId > 0, "category_id", "must be a valid category")
+}
+
+func ValidateStocktakeCounts(v *validator.Validator, counts []StocktakeCount) {
+	v.Check(len(counts) > 0, "counts", "must contain at least one count")
+
+	for i, count := range counts {
+		key := fmt.Sprintf("counts[%d]", i)
+		v.Check(count.ProductId > 0, key+".product_id", "must be provided")
+		v.Check(count.Qty >= 0, key+".qty", "must not be negative")
+		v.Check(len(count.Unit) <= 16, key+".unit", "must not be more than 16 bytes long")
+	}
+}
+
+const stocktakeColumns = `
+		id, name, store_id, category_id, status, employee_id,
+		(SELECT count(*) FROM stocktake_lines WHERE stocktake_id = stocktakes.id),
+		(SELECT count(*) FROM stocktake_lines WHERE stocktake_id = stocktakes.id AND counted_qty IS NOT NULL),
+		created_at, finalized_at, updated_at`
+
+func scanStocktake(row interface{ Scan(...interface{}) error }, stocktake *Stocktake) error {
+	return row.Scan(&stocktake.Id, &stocktake.Name, &stocktake.StoreId, &stocktake.CategoryId, &stocktake.Status,
+		&stocktake.EmployeeId, &stocktake.Products, &stocktake.Counted, &stocktake.CreatedAt, &stocktake.FinalizedAt,
+		&stocktake.UpdatedAt)
+}
+
+// Create starts a stocktake in the given store, or in the default store when StoreId is
+// zero, and freezes the expected quantity of every product in scope. Sales carry on while
+// counting; they are booked as usual and only the variance against the frozen quantity is
+// adjusted when the stocktake is finalized.
+func (s StocktakeModule) Create(stocktake *Stocktake) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	storeId, err := resolveStore(ctx, tx, stocktake.StoreId)
+	if err != nil {
+		return err
+	}
+
+	var id int
+	query := `
+			INSERT INTO stocktakes (name, store_id, category_id, employee_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+			`
+	err = tx.QueryRowContext(ctx, query, stocktake.Name, storeId, stocktake.CategoryId, stocktake.EmployeeId).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	// A category takes in the categories under it.
+	query = `
+			WITH RECURSIVE ` + categorySubtree("$3") + `
+			INSERT INTO stocktake_lines (stocktake_id, product_id, name, unit_value, expected_qty)
+			SELECT $1, products.id, COALESCE(products.name, ''), COALESCE(products.price, 0), COALESCE(product_stock.amount, 0)
+			FROM products
+			LEFT JOIN product_stock ON product_stock.product_id = products.id AND product_stock.store_id = $2
+			WHERE $3::int IS NULL OR products.category_id IN (SELECT id FROM subtree)
+			`
+	if _, err := tx.ExecContext(ctx, query, id, storeId, stocktake.CategoryId); err != nil {
+		return err
+	}
+
+	query = `SELECT ` + stocktakeColumns + ` FROM stocktakes WHERE id = $1`
+	if err := scanStocktake(tx.QueryRowContext(ctx, query, id), stocktake); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s StocktakeModule) Get(id int) (*Stocktake, error) {
+	query := `SELECT ` + stocktakeColumns + ` FROM stocktakes WHERE id = $1`
+
+	var stocktake Stocktake
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStocktake(s.DB.QueryRowContext(ctx, query, id), &stocktake)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &stocktake, nil
+}
+
+// GetAll lists stocktakes, newest first, optionally only those in one status or of one
+// store (storeId > 0).
+func (s StocktakeModule) GetAll(status string, storeId int, filters Filters) ([]Stocktake, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + stocktakeColumns + `
+			FROM stocktakes
+			WHERE (status = $1 OR $1 = '') AND (store_id = $2 OR $2 = 0)
+			ORDER BY id DESC
+			LIMIT $3 OFFSET $4
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, status, storeId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	stocktakes := []Stocktake{}
+	for rows.Next() {
+		var st Stocktake
+		err := rows.Scan(&totalRecords, &st.Id, &st.Name, &st.StoreId, &st.CategoryId, &st.Status, &st.EmployeeId,
+			&st.Products, &st.Counted, &st.CreatedAt, &st.FinalizedAt, &st.UpdatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		stocktakes = append(stocktakes, st)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return stocktakes, metadata, nil
+}
+
+// AddCounts enters counts into an open stocktake. Each count updates its line in a
+// single statement, so counts sent at the same time from several devices all add up.
+func (s StocktakeModule) AddCounts(id int, counts []StocktakeCount, employeeId, stationId *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Counts update the stocktake row below, so take the row lock they need up front: two
+	// counts upgrading shared locks would deadlock. It also keeps Finalize out.
+	var status string
+	query := `SELECT status FROM stocktakes WHERE id = $1 FOR NO KEY UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if status != StocktakeOpen {
+		return ErrStocktakeClosed
+	}
+
+	for _, count := range counts {
+		factor, err := unitFactor(ctx, tx, count.ProductId, count.Unit)
+		if errors.Is(err, ErrUnknownProduct) {
+			return fmt.Errorf("%w: %d", ErrNotInStocktake, count.ProductId)
+		}
+		if err != nil {
+			return err
+		}
+		count.Qty *= factor
+
+		query := `
+				UPDATE stocktake_lines
+				SET counted_qty = CASE WHEN $4 THEN $3 ELSE COALESCE(counted_qty, 0) + $3 END,
+					recounts = recounts + CASE WHEN $4 AND counted_qty IS NOT NULL THEN 1 ELSE 0 END,
+					counted_at = NOW()
+				WHERE stocktake_id = $1 AND product_id = $2
+				`
+		result, err := tx.ExecContext(ctx, query, id, count.ProductId, count.Qty, count.Recount)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("%w: %d", ErrNotInStocktake, count.ProductId)
+		}
+
+		query = `
+				INSERT INTO stocktake_counts (stocktake_id, product_id, qty, recount, employee_id, station_id)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				`
+		_, err = tx.ExecContext(ctx, query, id, count.ProductId, count.Qty, count.Recount, employeeId, stationId)
+		if err != nil {
+			return err
+		}
+	}
+
+	query = `UPDATE stocktakes SET updated_at = NOW() WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Variance reports every line of the stocktake, largest value variance first. Lines not
+// counted yet have no variance.
+func (s StocktakeModule) Variance(id int) (*VarianceReport, error) {
+	stocktake, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+			SELECT product_id, name, expected_qty, counted_qty, recounts, counted_at, unit_value
+			FROM stocktake_lines
+			WHERE stocktake_id = $1
+			ORDER BY abs((COALESCE(counted_qty, expected_qty) - expected_qty) * unit_value) DESC, product_id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &VarianceReport{Stocktake: stocktake, Lines: []VarianceLine{}}
+	for rows.Next() {
+		var line VarianceLine
+		var unitValue int
+		err := rows.Scan(&line.ProductId, &line.Name, &line.ExpectedQty, &line.CountedQty, &line.Recounts,
+			&line.CountedAt, &unitValue)
+		if err != nil {
+			return nil, err
+		}
+
+		if line.CountedQty == nil {
+			report.Uncounted++
+		} else {
+			line.Variance = *line.CountedQty - line.ExpectedQty
+			line.Value = line.Variance * unitValue
+		}
+		report.UnitsVariance += line.Variance
+		report.ValueVariance += line.Value
+		report.Lines = append(report.Lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Finalize closes the stocktake and posts a stocktake movement for the variance of every
+// counted line. With zeroUncounted, products nobody counted are taken to be out of stock;
+// otherwise they are left alone.
+func (s StocktakeModule) Finalize(id int, zeroUncounted bool, employeeId *int) ([]*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var storeId int
+	query := `SELECT status, store_id FROM stocktakes WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&status, &storeId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	if status != StocktakeOpen {
+		return nil, ErrStocktakeClosed
+	}
+
+	query = `
+			SELECT product_id, COALESCE(counted_qty, 0) - expected_qty
+			FROM stocktake_lines
+			WHERE stocktake_id = $1 AND (counted_qty IS NOT NULL OR $2)
+				AND COALESCE(counted_qty, 0) <> expected_qty
+				AND EXISTS (SELECT 1 FROM products WHERE products.id = stocktake_lines.product_id)
+			ORDER BY product_id
+			`
+	rows, err := tx.QueryContext(ctx, query, id, zeroUncounted)
+	if err != nil {
+		return nil, err
+	}
+	movements := []*StockMovement{}
+	for rows.Next() {
+		m := &StockMovement{
+			StoreId:    storeId,
+			Type:       MovementStocktake,
+			Reason:     "stocktake variance",
+			EmployeeId: employeeId,
+			SourceType: "stocktake",
+			SourceId:   strconv.Itoa(id),
+		}
+		if err := rows.Scan(&m.ProductId, &m.QtyDelta); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE stocktakes SET status = 'finalized', finalized_at = NOW(), updated_at = NOW() WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
+
+// Cancel abandons an open stocktake without touching stock.
+func (s StocktakeModule) Cancel(id int) (*Stocktake, error) {
+	query := `
+			UPDATE stocktakes
+			SET status = 'cancelled', updated_at = NOW()
+			WHERE id = $1 AND status = 'open'
+			RETURNING ` + stocktakeColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stocktake Stocktake
+	err := scanStocktake(s.DB.QueryRowContext(ctx, query, id), &stocktake)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := s.Get(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrStocktakeClosed
+	}
+
+	return &stocktake, nil
+}
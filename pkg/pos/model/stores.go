@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+var (
+	// ErrUnknownStore is returned when something is assigned to a store that does not exist.
+	ErrUnknownStore = errors.New("store does not exist")
+	// ErrStoreInUse is returned when deleting a store that stations, orders or stock refer to.
+	ErrStoreInUse = errors.New("store is in use")
+)
+
+// Store is a shop location. Stations, orders and stock belong to a store; whatever is
+// recorded without one belongs to the default store.
+type Store struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type StoreModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateStore(v *validator.Validator, store *Store) {
+	v.Check(store.Name != "", "name", "must be provided")
+	v.Check(len(store.Name) <= 255, "name", "must not be more than 255 bytes long")
+}
+
+// isForeignKeyViolation reports whether err is PostgreSQL rejecting a row that refers to
+// a missing one, or the deletion of a row that is still referred to.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+const storeColumns = `id, name, address, is_default, created_at, updated_at`
+
+func scanStore(row interface{ Scan(...interface{}) error }, store *Store) error {
+	return row.Scan(&store.Id, &store.Name, &store.Address, &store.IsDefault, &store.CreatedAt, &store.UpdatedAt)
+}
+
+// Create adds a store. Making it the default takes that role away from the current
+// default store.
+func (s StoreModule) Create(store *Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if store.IsDefault {
+		if _, err := tx.ExecContext(ctx, `UPDATE stores SET is_default = FALSE WHERE is_default`); err != nil {
+			return err
+		}
+	}
+
+	query := `
+			INSERT INTO stores (name, address, is_default)
+			VALUES ($1, $2, $3)
+			RETURNING ` + storeColumns
+	if err := scanStore(tx.QueryRowContext(ctx, query, store.Name, store.Address, store.IsDefault), store); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s StoreModule) Get(id int) (*Store, error) {
+	query := `SELECT ` + storeColumns + ` FROM stores WHERE id = $1`
+
+	var store Store
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStore(s.DB.QueryRowContext(ctx, query, id), &store)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+func (s StoreModule) GetAll() ([]Store, error) {
+	query := `SELECT ` + storeColumns + ` FROM stores ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stores := []Store{}
+	for rows.Next() {
+		var store Store
+		if err := scanStore(rows, &store); err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stores, nil
+}
+
+// Update changes a store. A store can be made the default, but the default store cannot
+// stop being the default other than by making another store the default.
+func (s StoreModule) Update(id int, store *Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if store.IsDefault {
+		if _, err := tx.ExecContext(ctx, `UPDATE stores SET is_default = FALSE WHERE is_default AND id <> $1`, id); err != nil {
+			return err
+		}
+	}
+
+	query := `
+			UPDATE stores
+			SET name = $1, address = $2, is_default = is_default OR $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+			RETURNING ` + storeColumns
+	err = scanStore(tx.QueryRowContext(ctx, query, store.Name, store.Address, store.IsDefault, id), store)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a store that nothing refers to. The default store cannot be deleted.
+func (s StoreModule) Delete(id int) error {
+	query := `DELETE FROM stores WHERE id = $1 AND NOT is_default`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrStoreInUse
+		}
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	return ErrStoreInUse
+}
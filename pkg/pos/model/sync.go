@@ -0,0 +1,164 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+const (
+	SyncCreated   = "created"
+	SyncDuplicate = "duplicate"
+	SyncRejected  = "rejected"
+)
+
+// UUIDRX sanity checks the format of client generated order UUIDs.
+var UUIDRX = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// OrderSyncResult reports what happened to one order of an offline sync batch.
+type OrderSyncResult struct {
+	ClientUUID      string `json:"client_uuid"`
+	Status          string `json:"status"`
+	OrderId         int    `json:"order_id,omitempty"`
+	ReceiptID       string `json:"receipt_id,omitempty"`
+	FlaggedProducts []int  `json:"flagged_products,omitempty"`
+	Error           string `json:"error,omitempty"`
+	// Movements are the stock movements the order caused.
+	Movements []*StockMovement `json:"-"`
+}
+
+func ValidateOfflineOrder(v *validator.Validator, order *Order) {
+	v.Check(order.ClientUUID != nil && validator.Matches(*order.ClientUUID, UUIDRX), "client_uuid", "must be a valid UUID")
+	v.Check(order.LocalCreatedAt != nil && !order.LocalCreatedAt.IsZero(), "local_created_at", "must be provided")
+	v.Check(len(order.Products) > 0, "products", "must contain at least one product")
+	v.Check(len(order.CustomerGroup) <= 32, "customer_group", "must not be more than 32 bytes long")
+	for _, p := range order.Products {
+		_, err := strconv.Atoi(p.ProductId)
+		v.Check(err == nil, "products", "product_id must be an integer")
+		v.Check(p.Qty > 0, "products", "qty must be greater than zero")
+	}
+}
+
+// ApplyOffline stores an order that a station created while offline. Orders are keyed by
+// their client UUID, so applying the same order twice returns the original result with a
+// duplicate status. Stock is decremented even if it goes negative; products that end up
+// below zero are flagged for a recount and listed in the result.
+func (o OrderModule) ApplyOffline(stationId int, order *Order) (*OrderSyncResult, error) {
+	result := &OrderSyncResult{ClientUUID: *order.ClientUUID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Reserve the receipt number first; on a duplicate the rollback gives it back.
+	query := `
+			UPDATE stations
+			SET receipt_seq = receipt_seq + 1
+			WHERE id = $1
+			RETURNING receipt_prefix, receipt_seq, store_id
+			`
+	var prefix string
+	var seq int
+	if err := tx.QueryRowContext(ctx, query, stationId).Scan(&prefix, &seq, &order.StoreId); err != nil {
+		return nil, err
+	}
+	order.ReceiptID = fmt.Sprintf("%s-%06d", prefix, seq)
+	order.StationId = &stationId
+
+	productsJSON, err := json.Marshal(order.Products)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+			INSERT INTO orders (employee_id, total_price, total_paid, total_return, receipt_id, created_at, updated_at,
+				products, shift_id, station_id, store_id, client_uuid, local_created_at, synced_at, customer_group, price_list_id)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7, $8, $9, $10, $11, $6, NOW(), $12, $13)
+			ON CONFLICT (client_uuid) DO NOTHING
+			RETURNING id, created_at, updated_at, synced_at
+			`
+	args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID,
+		order.LocalCreatedAt, productsJSON, order.ShiftId, order.StationId, order.StoreId, order.ClientUUID,
+		order.CustomerGroup, order.PriceListId}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&order.Id, &order.CreatedAt, &order.UpdatedAt, &order.SyncedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+
+		query = `SELECT id, receipt_id FROM orders WHERE client_uuid = $1`
+		err = o.DB.QueryRowContext(ctx, query, order.ClientUUID).Scan(&result.OrderId, &result.ReceiptID)
+		if err != nil {
+			return nil, err
+		}
+		result.Status = SyncDuplicate
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	movements, err := OrderMovements(MovementSale, -1, order, order.employee(), "", order.Products)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range movements {
+		// The sale already happened, whatever lots and units it came from.
+		m.offline = true
+		if err := recordMovement(ctx, tx, m); err != nil {
+			if reason := offlineRejection(m.ProductId, err); reason != "" {
+				result.Status = SyncRejected
+				result.Error = reason
+				return result, nil
+			}
+			return nil, err
+		}
+		for _, moved := range append([]*StockMovement{m}, m.Ingredients...) {
+			if moved.Id != 0 && moved.BalanceAfter < 0 {
+				result.FlaggedProducts = append(result.FlaggedProducts, moved.ProductId)
+			}
+		}
+	}
+	result.Movements = movements
+
+	if err := saveOrderProducts(ctx, tx, order); err != nil {
+		return nil, err
+	}
+	if err := accrueCommissions(ctx, tx, order, order.Products); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result.Status = SyncCreated
+	result.OrderId = order.Id
+	result.ReceiptID = order.ReceiptID
+	return result, nil
+}
+
+// offlineRejection returns why an offline order is rejected when recording its movement of
+// productId failed with err, or an empty string when err is not a problem with the order.
+func offlineRejection(productId int, err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownProduct):
+		return fmt.Sprintf("product %d does not exist", productId)
+	case errors.Is(err, ErrUnknownUnit):
+		return fmt.Sprintf("product %d is not sold in that unit", productId)
+	case errors.Is(err, ErrNotSerialized):
+		return fmt.Sprintf("product %d does not take serial numbers", productId)
+	}
+	return ""
+}
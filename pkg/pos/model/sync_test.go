@@ -0,0 +1,50 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOfflineRejection(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "unknown product",
+			err:  fmt.Errorf("%w: %d", ErrUnknownProduct, 9),
+			want: "product 9 does not exist",
+		},
+		{
+			name: "unknown unit",
+			err:  fmt.Errorf("%w: product 9 unit %q", ErrUnknownUnit, "case"),
+			want: "product 9 is not sold in that unit",
+		},
+		{
+			name: "serials for a product without them",
+			err:  ErrNotSerialized,
+			want: "product 9 does not take serial numbers",
+		},
+		{
+			name: "database error is not a rejection",
+			err:  sql.ErrConnDone,
+			want: "",
+		},
+		{
+			name: "other error is not a rejection",
+			err:  errors.New("boom"),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offlineRejection(9, tt.err); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrAlreadyClockedIn is returned when an employee clocks in twice without clocking out.
+	ErrAlreadyClockedIn = errors.New("employee is already clocked in")
+
+	// ErrNotClockedIn is returned when an action needs an open time entry and there is none.
+	ErrNotClockedIn = errors.New("employee is not clocked in")
+
+	// ErrBreakInProgress is returned when a break is started while another one is running.
+	ErrBreakInProgress = errors.New("a break is already in progress")
+
+	// ErrNoBreakInProgress is returned when a break is ended but none was started.
+	ErrNoBreakInProgress = errors.New("no break in progress")
+
+	// ErrBreakOutsideEntry is returned when an edit would leave a break of the entry
+	// outside its clock-in and clock-out times.
+	ErrBreakOutsideEntry = errors.New("breaks must fall between clock-in and clock-out")
+)
+
+type TimeBreak struct {
+	Id          int        `json:"id"`
+	TimeEntryId int        `json:"time_entry_id"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at"`
+}
+
+type TimeEntry struct {
+	Id         int         `json:"id"`
+	EmployeeId int         `json:"employee_id"`
+	ClockIn    time.Time   `json:"clock_in"`
+	ClockOut   *time.Time  `json:"clock_out"`
+	Breaks     []TimeBreak `json:"breaks"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// BreakDuration returns the total length of the entry's finished breaks.
+func (e TimeEntry) BreakDuration() time.Duration {
+	var d time.Duration
+	for _, b := range e.Breaks {
+		if b.EndedAt != nil {
+			d += b.EndedAt.Sub(b.StartedAt)
+		}
+	}
+	return d
+}
+
+// WorkedDuration returns the paid time of a finished entry, or zero while still clocked in.
+func (e TimeEntry) WorkedDuration() time.Duration {
+	if e.ClockOut == nil {
+		return 0
+	}
+	return e.ClockOut.Sub(e.ClockIn) - e.BreakDuration()
+}
+
+type TimeEntryAudit struct {
+	Id          int       `json:"id"`
+	TimeEntryId int       `json:"time_entry_id"`
+	EditorId    int       `json:"editor_id"`
+	Field       string    `json:"field"`
+	OldValue    string    `json:"old_value"`
+	NewValue    string    `json:"new_value"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Timesheet struct {
+	EmployeeId  int         `json:"employee_id"`
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	Entries     []TimeEntry `json:"entries"`
+	TotalHours  float64     `json:"total_hours"`
+	BreakHours  float64     `json:"break_hours"`
+	OpenEntries int         `json:"open_entries"`
+}
+
+type TimeClockModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+const timeEntryColumns = `id, employee_id, clock_in, clock_out, updated_at`
+
+func scanTimeEntry(row interface{ Scan(...interface{}) error }, e *TimeEntry) error {
+	return row.Scan(&e.Id, &e.EmployeeId, &e.ClockIn, &e.ClockOut, &e.UpdatedAt)
+}
+
+func (t TimeClockModule) ClockIn(employeeId int) (*TimeEntry, error) {
+	query := `
+		INSERT INTO time_entries (employee_id)
+		VALUES ($1)
+		RETURNING ` + timeEntryColumns
+
+	entry := TimeEntry{Breaks: []TimeBreak{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanTimeEntry(t.DB.QueryRowContext(ctx, query, employeeId), &entry)
+	if err != nil {
+		if isUniqueViolation(err, "time_entries_single_open_idx") {
+			return nil, ErrAlreadyClockedIn
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// ClockOut closes the employee's open time entry, ending any break still in progress.
+func (t TimeClockModule) ClockOut(employeeId int) (*TimeEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE time_entries
+		SET clock_out = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE employee_id = $1 AND clock_out IS NULL
+		RETURNING ` + timeEntryColumns
+
+	var entry TimeEntry
+	err = scanTimeEntry(tx.QueryRowContext(ctx, query, employeeId), &entry)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotClockedIn
+		}
+		return nil, err
+	}
+
+	query = `
+		UPDATE time_breaks
+		SET ended_at = $2
+		WHERE time_entry_id = $1 AND ended_at IS NULL
+		`
+	_, err = tx.ExecContext(ctx, query, entry.Id, entry.ClockOut)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := t.loadBreaks(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (t TimeClockModule) StartBreak(employeeId int) (*TimeBreak, error) {
+	query := `
+		INSERT INTO time_breaks (time_entry_id)
+		SELECT id FROM time_entries WHERE employee_id = $1 AND clock_out IS NULL
+		RETURNING id, time_entry_id, started_at, ended_at
+		`
+
+	var b TimeBreak
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := t.DB.QueryRowContext(ctx, query, employeeId).Scan(&b.Id, &b.TimeEntryId, &b.StartedAt, &b.EndedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotClockedIn
+		case isUniqueViolation(err, "time_breaks_single_open_idx"):
+			return nil, ErrBreakInProgress
+		default:
+			return nil, err
+		}
+	}
+
+	return &b, nil
+}
+
+func (t TimeClockModule) EndBreak(employeeId int) (*TimeBreak, error) {
+	query := `
+		UPDATE time_breaks
+		SET ended_at = CURRENT_TIMESTAMP
+		FROM time_entries
+		WHERE time_breaks.time_entry_id = time_entries.id
+		  AND time_entries.employee_id = $1
+		  AND time_entries.clock_out IS NULL
+		  AND time_breaks.ended_at IS NULL
+		RETURNING time_breaks.id, time_breaks.time_entry_id, time_breaks.started_at, time_breaks.ended_at
+		`
+
+	var b TimeBreak
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := t.DB.QueryRowContext(ctx, query, employeeId).Scan(&b.Id, &b.TimeEntryId, &b.StartedAt, &b.EndedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoBreakInProgress
+		}
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (t TimeClockModule) Get(id int) (*TimeEntry, error) {
+	query := `SELECT ` + timeEntryColumns + ` FROM time_entries WHERE id = $1`
+
+	var entry TimeEntry
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanTimeEntry(t.DB.QueryRowContext(ctx, query, id), &entry)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err := t.loadBreaks(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Edit lets a manager correct the clock-in and clock-out times of an entry. The entry's
+// breaks must still fall within the new times, and a closed entry cannot have a break in
+// progress. Every changed field is written to the audit trail together with the editor
+// and the reason given.
+func (t TimeClockModule) Edit(id, editorId int, clockIn time.Time, clockOut *time.Time, reason string) (*TimeEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + timeEntryColumns + ` FROM time_entries WHERE id = $1 FOR UPDATE`
+
+	var old TimeEntry
+	err = scanTimeEntry(tx.QueryRowContext(ctx, query, id), &old)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	query = `
+		SELECT EXISTS (
+			SELECT 1 FROM time_breaks
+			WHERE time_entry_id = $1
+			  AND (started_at < $2 OR ($3::timestamptz IS NOT NULL AND COALESCE(ended_at, 'infinity') > $3))
+		)
+		`
+	var outside bool
+	if err := tx.QueryRowContext(ctx, query, id, clockIn, clockOut).Scan(&outside); err != nil {
+		return nil, err
+	}
+	if outside {
+		return nil, ErrBreakOutsideEntry
+	}
+
+	query = `
+		UPDATE time_entries
+		SET clock_in = $1, clock_out = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING ` + timeEntryColumns
+
+	var entry TimeEntry
+	err = scanTimeEntry(tx.QueryRowContext(ctx, query, clockIn, clockOut, id), &entry)
+	if err != nil {
+		if isUniqueViolation(err, "time_entries_single_open_idx") {
+			return nil, ErrAlreadyClockedIn
+		}
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO time_entry_audits (time_entry_id, editor_id, field, old_value, new_value, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`
+	if !old.ClockIn.Equal(entry.ClockIn) {
+		args := []interface{}{id, editorId, "clock_in", formatTime(&old.ClockIn), formatTime(&entry.ClockIn), reason}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return nil, err
+		}
+	}
+	if formatTime(old.ClockOut) != formatTime(entry.ClockOut) {
+		args := []interface{}{id, editorId, "clock_out", formatTime(old.ClockOut), formatTime(entry.ClockOut), reason}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := t.loadBreaks(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (t TimeClockModule) GetAudits(id int) ([]TimeEntryAudit, error) {
+	query := `
+		SELECT id, time_entry_id, editor_id, field, old_value, new_value, reason, created_at
+		FROM time_entry_audits
+		WHERE time_entry_id = $1
+		ORDER BY created_at, id
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := []TimeEntryAudit{}
+	for rows.Next() {
+		var a TimeEntryAudit
+		err := rows.Scan(&a.Id, &a.TimeEntryId, &a.EditorId, &a.Field, &a.OldValue, &a.NewValue, &a.Reason, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		audits = append(audits, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+}
+
+// Timesheet returns the employee's time entries that started within [from, to) with the
+// worked and break hours totalled for payroll.
+func (t TimeClockModule) Timesheet(employeeId int, from, to time.Time) (*Timesheet, error) {
+	query := `
+		SELECT ` + timeEntryColumns + `
+		FROM time_entries
+		WHERE employee_id = $1 AND clock_in >= $2 AND clock_in < $3
+		ORDER BY clock_in
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, employeeId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sheet := Timesheet{EmployeeId: employeeId, From: from, To: to, Entries: []TimeEntry{}}
+	index := make(map[int]int)
+	var ids []int
+
+	for rows.Next() {
+		entry := TimeEntry{Breaks: []TimeBreak{}}
+		if err := scanTimeEntry(rows, &entry); err != nil {
+			return nil, err
+		}
+		index[entry.Id] = len(sheet.Entries)
+		ids = append(ids, entry.Id)
+		sheet.Entries = append(sheet.Entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) > 0 {
+		breaks, err := t.breaksFor(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range breaks {
+			e := &sheet.Entries[index[b.TimeEntryId]]
+			e.Breaks = append(e.Breaks, b)
+		}
+	}
+
+	var worked, breaks time.Duration
+	for _, e := range sheet.Entries {
+		if e.ClockOut == nil {
+			sheet.OpenEntries++
+			continue
+		}
+		worked += e.WorkedDuration()
+		breaks += e.BreakDuration()
+	}
+	sheet.TotalHours = worked.Hours()
+	sheet.BreakHours = breaks.Hours()
+
+	return &sheet, nil
+}
+
+func (t TimeClockModule) loadBreaks(entry *TimeEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	breaks, err := t.breaksFor(ctx, []int{entry.Id})
+	if err != nil {
+		return err
+	}
+	entry.Breaks = breaks
+	return nil
+}
+
+func (t TimeClockModule) breaksFor(ctx context.Context, entryIds []int) ([]TimeBreak, error) {
+	query := `
+		SELECT id, time_entry_id, started_at, ended_at
+		FROM time_breaks
+		WHERE time_entry_id = ANY($1)
+		ORDER BY started_at
+		`
+	rows, err := t.DB.QueryContext(ctx, query, pq.Array(entryIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breaks := []TimeBreak{}
+	for rows.Next() {
+		var b TimeBreak
+		if err := rows.Scan(&b.Id, &b.TimeEntryId, &b.StartedAt, &b.EndedAt); err != nil {
+			return nil, err
+		}
+		breaks = append(breaks, b)
+	}
+
+	return breaks, rows.Err()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
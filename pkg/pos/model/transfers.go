@@ -0,0 +1,674 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+// Transfer statuses, in the order a transfer moves through them.
+const (
+	TransferDraft     = "draft"
+	TransferInTransit = "in_transit"
+	TransferReceived  = "received"
+	TransferCancelled = "cancelled"
+)
+
+var (
+	// ErrTransferSameStore is returned when a transfer would ship goods to the store they
+	// come from.
+	ErrTransferSameStore = errors.New("transfer must go to a different store")
+	// ErrTransferNotDraft is returned when changing or shipping a transfer that has been shipped.
+	ErrTransferNotDraft = errors.New("only draft transfers can be changed or shipped")
+	// ErrTransferNotInTransit is returned when receiving a transfer that has not been shipped
+	// or is closed.
+	ErrTransferNotInTransit = errors.New("only transfers in transit can be received")
+	// ErrTransferClosed is returned when cancelling a received or cancelled transfer.
+	ErrTransferClosed = errors.New("transfer has been received or cancelled")
+	// ErrUnknownTransferLine is returned when receiving a line the transfer does not have.
+	ErrUnknownTransferLine = errors.New("transfer line does not exist")
+	// ErrUnexplainedDiscrepancy is returned when a line is received for another quantity
+	// than was shipped without saying why.
+	ErrUnexplainedDiscrepancy = errors.New("a received quantity that differs from the shipped quantity needs a reason")
+)
+
+// TransferLine is one product moved between stores. QtyReceived and Discrepancy, received
+// less shipped, are set once the transfer has been received.
+type TransferLine struct {
+	Id                int    `json:"id"`
+	ProductId         int    `json:"product_id"`
+	Qty               int    `json:"qty"`
+	QtyReceived       *int   `json:"qty_received"`
+	Discrepancy       *int   `json:"discrepancy"`
+	DiscrepancyReason string `json:"discrepancy_reason"`
+}
+
+// Transfer moves goods from one store to another. Shipping takes them out of the source
+// store; until the transfer is received they are in transit and in neither store.
+type Transfer struct {
+	Id          int            `json:"id"`
+	FromStoreId int            `json:"from_store_id"`
+	ToStoreId   int            `json:"to_store_id"`
+	Status      string         `json:"status"`
+	Notes       string         `json:"notes"`
+	EmployeeId  *int           `json:"employee_id"`
+	Lines       []TransferLine `json:"lines,omitempty"`
+	ShippedAt   *time.Time     `json:"shipped_at"`
+	ReceivedAt  *time.Time     `json:"received_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// TransferReceipt is the quantity of one transfer line that arrived, and why it differs
+// from what was shipped if it does.
+type TransferReceipt struct {
+	LineId int    `json:"line_id"`
+	Qty    int    `json:"qty"`
+	Reason string `json:"reason"`
+}
+
+// InTransitStock is how much of a product is on its way between two stores.
+type InTransitStock struct {
+	ProductId   int `json:"product_id"`
+	FromStoreId int `json:"from_store_id"`
+	ToStoreId   int `json:"to_store_id"`
+	Qty         int `json:"qty"`
+}
+
+// TransferSummary is one shipped transfer in the transfer history report.
+type TransferSummary struct {
+	Id          int        `json:"id"`
+	FromStoreId int        `json:"from_store_id"`
+	ToStoreId   int        `json:"to_store_id"`
+	Status      string     `json:"status"`
+	ShippedAt   *time.Time `json:"shipped_at"`
+	ReceivedAt  *time.Time `json:"received_at"`
+	Lines       int        `json:"lines"`
+	QtyShipped  int        `json:"qty_shipped"`
+	QtyReceived *int       `json:"qty_received"`
+	Discrepancy *int       `json:"discrepancy"`
+}
+
+type TransferModule struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateTransfer(v *validator.Validator, transfer *Transfer) {
+	v.Check(transfer.FromStoreId >= 0, "from_store_id", "must be a valid store")
+	v.Check(transfer.ToStoreId > 0, "to_store_id", "must be provided")
+	v.Check(transfer.FromStoreId != transfer.ToStoreId, "to_store_id", "must differ from from_store_id")
+	v.Check(len(transfer.Lines) > 0, "lines", "must contain at least one line")
+
+	for i, line := range transfer.Lines {
+		key := fmt.Sprintf("lines[%d]", i)
+		v.Check(line.ProductId > 0, key+".product_id", "must be provided")
+		v.Check(line.Qty > 0, key+".qty", "must be greater than zero")
+	}
+}
+
+// ValidateTransferReceipts checks what arrived. Lines left out arrived as shipped.
+func ValidateTransferReceipts(v *validator.Validator, receipts []TransferReceipt) {
+	ids := make([]string, len(receipts))
+	for i, receipt := range receipts {
+		key := fmt.Sprintf("lines[%d]", i)
+		ids[i] = strconv.Itoa(receipt.LineId)
+		v.Check(receipt.Qty >= 0, key+".qty", "must not be negative")
+		v.Check(len(receipt.Reason) <= 500, key+".reason", "must not be more than 500 bytes long")
+	}
+	v.Check(validator.Unique(ids), "lines", "must not contain the same line twice")
+}
+
+const transferColumns = `id, from_store_id, to_store_id, status, notes, employee_id, shipped_at, received_at, created_at, updated_at`
+
+func scanTransfer(row interface{ Scan(...interface{}) error }, transfer *Transfer) error {
+	return row.Scan(&transfer.Id, &transfer.FromStoreId, &transfer.ToStoreId, &transfer.Status, &transfer.Notes,
+		&transfer.EmployeeId, &transfer.ShippedAt, &transfer.ReceivedAt, &transfer.CreatedAt, &transfer.UpdatedAt)
+}
+
+func getTransferLines(ctx context.Context, q queryer, transfer *Transfer) error {
+	query := `
+			SELECT id, product_id, qty, qty_received, discrepancy_reason
+			FROM transfer_lines
+			WHERE transfer_id = $1
+			ORDER BY id
+			`
+	rows, err := q.QueryContext(ctx, query, transfer.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	transfer.Lines = []TransferLine{}
+	for rows.Next() {
+		var line TransferLine
+		err := rows.Scan(&line.Id, &line.ProductId, &line.Qty, &line.QtyReceived, &line.DiscrepancyReason)
+		if err != nil {
+			return err
+		}
+		if line.QtyReceived != nil {
+			discrepancy := *line.QtyReceived - line.Qty
+			line.Discrepancy = &discrepancy
+		}
+		transfer.Lines = append(transfer.Lines, line)
+	}
+
+	return rows.Err()
+}
+
+// setTransferLines replaces the lines of a draft transfer. Every product must exist.
+func setTransferLines(ctx context.Context, tx *sql.Tx, transfer *Transfer) error {
+	productIds := make([]int64, len(transfer.Lines))
+	for i, line := range transfer.Lines {
+		productIds[i] = int64(line.ProductId)
+	}
+
+	if err := productsExist(ctx, tx, productIds); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM transfer_lines WHERE transfer_id = $1`
+	if _, err := tx.ExecContext(ctx, query, transfer.Id); err != nil {
+		return err
+	}
+
+	query = `
+			INSERT INTO transfer_lines (transfer_id, product_id, qty)
+			VALUES ($1, $2, $3)
+			RETURNING id
+			`
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		line.QtyReceived, line.Discrepancy, line.DiscrepancyReason = nil, nil, ""
+		if err := tx.QueryRowContext(ctx, query, transfer.Id, line.ProductId, line.Qty).Scan(&line.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveTransferStores resolves the stores of a transfer, a zero source being the
+// default store.
+func resolveTransferStores(ctx context.Context, tx *sql.Tx, transfer *Transfer) (fromStoreId, toStoreId int, err error) {
+	if fromStoreId, err = resolveStore(ctx, tx, transfer.FromStoreId); err != nil {
+		return 0, 0, err
+	}
+	if toStoreId, err = resolveStore(ctx, tx, transfer.ToStoreId); err != nil {
+		return 0, 0, err
+	}
+	if fromStoreId == toStoreId {
+		return 0, 0, ErrTransferSameStore
+	}
+	return fromStoreId, toStoreId, nil
+}
+
+// Create saves a new draft transfer with its lines.
+func (t TransferModule) Create(transfer *Transfer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fromStoreId, toStoreId, err := resolveTransferStores(ctx, tx, transfer)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO transfers (from_store_id, to_store_id, notes, employee_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING ` + transferColumns
+	lines := transfer.Lines
+	err = scanTransfer(tx.QueryRowContext(ctx, query, fromStoreId, toStoreId, transfer.Notes, transfer.EmployeeId), transfer)
+	if err != nil {
+		return err
+	}
+
+	transfer.Lines = lines
+	if err := setTransferLines(ctx, tx, transfer); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (t TransferModule) Get(id int) (*Transfer, error) {
+	query := `SELECT ` + transferColumns + ` FROM transfers WHERE id = $1`
+
+	var transfer Transfer
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanTransfer(t.DB.QueryRowContext(ctx, query, id), &transfer)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if err := getTransferLines(ctx, t.DB, &transfer); err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// GetAll lists transfers without their lines, newest first, optionally only those into or
+// out of one store (storeId > 0) or in one status.
+func (t TransferModule) GetAll(storeId int, status string, filters Filters) ([]Transfer, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), ` + transferColumns + `
+			FROM transfers
+			WHERE (from_store_id = $1 OR to_store_id = $1 OR $1 = 0) AND (status = $2 OR $2 = '')
+			ORDER BY id DESC
+			LIMIT $3 OFFSET $4
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, storeId, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	transfers := []Transfer{}
+	for rows.Next() {
+		var transfer Transfer
+		err := rows.Scan(&totalRecords, &transfer.Id, &transfer.FromStoreId, &transfer.ToStoreId, &transfer.Status, &transfer.Notes,
+			&transfer.EmployeeId, &transfer.ShippedAt, &transfer.ReceivedAt, &transfer.CreatedAt, &transfer.UpdatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return transfers, metadata, nil
+}
+
+// lockTransfer loads a transfer for update within tx.
+func lockTransfer(ctx context.Context, tx *sql.Tx, id int, transfer *Transfer) error {
+	query := `SELECT ` + transferColumns + ` FROM transfers WHERE id = $1 FOR UPDATE`
+	err := scanTransfer(tx.QueryRowContext(ctx, query, id), transfer)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Update changes the stores, notes and lines of a draft transfer.
+func (t TransferModule) Update(id int, transfer *Transfer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current Transfer
+	if err := lockTransfer(ctx, tx, id, &current); err != nil {
+		return err
+	}
+	if current.Status != TransferDraft {
+		return ErrTransferNotDraft
+	}
+	if transfer.FromStoreId == 0 {
+		transfer.FromStoreId = current.FromStoreId
+	}
+	fromStoreId, toStoreId, err := resolveTransferStores(ctx, tx, transfer)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE transfers
+			SET from_store_id = $1, to_store_id = $2, notes = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+			RETURNING ` + transferColumns
+	lines := transfer.Lines
+	err = scanTransfer(tx.QueryRowContext(ctx, query, fromStoreId, toStoreId, transfer.Notes, id), transfer)
+	if err != nil {
+		return err
+	}
+
+	transfer.Lines = lines
+	if err := setTransferLines(ctx, tx, transfer); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a draft transfer.
+func (t TransferModule) Delete(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var transfer Transfer
+	if err := lockTransfer(ctx, tx, id, &transfer); err != nil {
+		return err
+	}
+	if transfer.Status != TransferDraft {
+		return ErrTransferNotDraft
+	}
+
+	query := `DELETE FROM transfers WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transferMovements builds one transfer movement per line, of sign times qty(line), in
+// the given store. Stock coming in goes into the lots it was shipped from.
+func transferMovements(transfer *Transfer, storeId, sign int, reason string, employeeId *int, qty func(TransferLine) int) []*StockMovement {
+	movements := make([]*StockMovement, 0, len(transfer.Lines))
+	for _, line := range transfer.Lines {
+		if qty(line) == 0 {
+			continue
+		}
+		m := &StockMovement{
+			ProductId:  line.ProductId,
+			StoreId:    storeId,
+			Type:       MovementTransfer,
+			QtyDelta:   sign * qty(line),
+			Reason:     reason,
+			EmployeeId: employeeId,
+			SourceType: "transfer",
+			SourceId:   strconv.Itoa(transfer.Id),
+		}
+		if sign > 0 {
+			m.lotOrigin("transfer", transfer.Id)
+		}
+		movements = append(movements, m)
+	}
+	return movements
+}
+
+func shippedQty(line TransferLine) int { return line.Qty }
+
+// Ship takes the goods of a draft transfer out of the source store. They are in transit
+// until the transfer is received.
+func (t TransferModule) Ship(id int, employeeId *int) (*Transfer, []*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var transfer Transfer
+	if err := lockTransfer(ctx, tx, id, &transfer); err != nil {
+		return nil, nil, err
+	}
+	if transfer.Status != TransferDraft {
+		return nil, nil, ErrTransferNotDraft
+	}
+	if err := getTransferLines(ctx, tx, &transfer); err != nil {
+		return nil, nil, err
+	}
+
+	movements := transferMovements(&transfer, transfer.FromStoreId, -1, "transfer shipped", employeeId, shippedQty)
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+			UPDATE transfers
+			SET status = 'in_transit', shipped_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+			RETURNING ` + transferColumns
+	lines := transfer.Lines
+	if err := scanTransfer(tx.QueryRowContext(ctx, query, id), &transfer); err != nil {
+		return nil, nil, err
+	}
+	transfer.Lines = lines
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &transfer, movements, nil
+}
+
+// Receive books the arrival of a transfer in transit into the destination store. Lines
+// without a receipt arrived as shipped. A line that arrived short or over needs a reason;
+// the difference is recorded on the line and only what arrived is added to stock.
+func (t TransferModule) Receive(id int, receipts []TransferReceipt, employeeId *int) (*Transfer, []*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var transfer Transfer
+	if err := lockTransfer(ctx, tx, id, &transfer); err != nil {
+		return nil, nil, err
+	}
+	if transfer.Status != TransferInTransit {
+		return nil, nil, ErrTransferNotInTransit
+	}
+	if err := getTransferLines(ctx, tx, &transfer); err != nil {
+		return nil, nil, err
+	}
+
+	known := make(map[int]bool, len(transfer.Lines))
+	for _, line := range transfer.Lines {
+		known[line.Id] = true
+	}
+	byLine := make(map[int]TransferReceipt, len(receipts))
+	for _, receipt := range receipts {
+		if !known[receipt.LineId] {
+			return nil, nil, fmt.Errorf("%w: %d", ErrUnknownTransferLine, receipt.LineId)
+		}
+		byLine[receipt.LineId] = receipt
+	}
+
+	query := `UPDATE transfer_lines SET qty_received = $2, discrepancy_reason = $3 WHERE id = $1`
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		receipt, ok := byLine[line.Id]
+		if !ok {
+			receipt = TransferReceipt{LineId: line.Id, Qty: line.Qty}
+		}
+
+		if receipt.Qty != line.Qty && receipt.Reason == "" {
+			return nil, nil, fmt.Errorf("%w: line %d", ErrUnexplainedDiscrepancy, line.Id)
+		}
+		if _, err := tx.ExecContext(ctx, query, line.Id, receipt.Qty, receipt.Reason); err != nil {
+			return nil, nil, err
+		}
+
+		discrepancy := receipt.Qty - line.Qty
+		line.QtyReceived, line.Discrepancy, line.DiscrepancyReason = &receipt.Qty, &discrepancy, receipt.Reason
+	}
+
+	movements := transferMovements(&transfer, transfer.ToStoreId, 1, "transfer received", employeeId,
+		func(line TransferLine) int { return *line.QtyReceived })
+	if err := recordMovements(ctx, tx, movements); err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+			UPDATE transfers
+			SET status = 'received', received_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+			RETURNING ` + transferColumns
+	lines := transfer.Lines
+	if err := scanTransfer(tx.QueryRowContext(ctx, query, id), &transfer); err != nil {
+		return nil, nil, err
+	}
+	transfer.Lines = lines
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &transfer, movements, nil
+}
+
+// Cancel abandons a transfer that has not been received. Goods already shipped go back
+// into the source store.
+func (t TransferModule) Cancel(id int, employeeId *int) (*Transfer, []*StockMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var transfer Transfer
+	if err := lockTransfer(ctx, tx, id, &transfer); err != nil {
+		return nil, nil, err
+	}
+	if transfer.Status != TransferDraft && transfer.Status != TransferInTransit {
+		return nil, nil, ErrTransferClosed
+	}
+	if err := getTransferLines(ctx, tx, &transfer); err != nil {
+		return nil, nil, err
+	}
+
+	movements := []*StockMovement{}
+	if transfer.Status == TransferInTransit {
+		movements = transferMovements(&transfer, transfer.FromStoreId, 1, "transfer cancelled", employeeId, shippedQty)
+		if err := recordMovements(ctx, tx, movements); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	query := `
+			UPDATE transfers
+			SET status = 'cancelled', updated_at = NOW()
+			WHERE id = $1
+			RETURNING ` + transferColumns
+	lines := transfer.Lines
+	if err := scanTransfer(tx.QueryRowContext(ctx, query, id), &transfer); err != nil {
+		return nil, nil, err
+	}
+	transfer.Lines = lines
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &transfer, movements, nil
+}
+
+// InTransit returns what is on its way between stores per product, optionally only what
+// is going to or coming from one store (storeId > 0).
+func (t TransferModule) InTransit(storeId int) ([]InTransitStock, error) {
+	query := `
+			SELECT l.product_id, t.from_store_id, t.to_store_id, SUM(l.qty)
+			FROM transfer_lines l
+			JOIN transfers t ON t.id = l.transfer_id
+			WHERE t.status = 'in_transit' AND (t.from_store_id = $1 OR t.to_store_id = $1 OR $1 = 0)
+			GROUP BY l.product_id, t.from_store_id, t.to_store_id
+			ORDER BY l.product_id, t.from_store_id, t.to_store_id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := []InTransitStock{}
+	for rows.Next() {
+		var s InTransitStock
+		if err := rows.Scan(&s.ProductId, &s.FromStoreId, &s.ToStoreId, &s.Qty); err != nil {
+			return nil, err
+		}
+		stock = append(stock, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stock, nil
+}
+
+// History summarises the transfers shipped in [from, to), oldest first, optionally only
+// those into or out of one store (storeId > 0).
+func (t TransferModule) History(storeId int, from, to time.Time) ([]TransferSummary, error) {
+	query := `
+			SELECT t.id, t.from_store_id, t.to_store_id, t.status, t.shipped_at, t.received_at,
+				count(l.id), COALESCE(SUM(l.qty), 0),
+				CASE WHEN t.status = 'received' THEN COALESCE(SUM(l.qty_received), 0) END
+			FROM transfers t
+			LEFT JOIN transfer_lines l ON l.transfer_id = t.id
+			WHERE t.shipped_at >= $2 AND t.shipped_at < $3
+				AND (t.from_store_id = $1 OR t.to_store_id = $1 OR $1 = 0)
+			GROUP BY t.id
+			ORDER BY t.shipped_at, t.id
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, storeId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []TransferSummary{}
+	for rows.Next() {
+		var s TransferSummary
+		err := rows.Scan(&s.Id, &s.FromStoreId, &s.ToStoreId, &s.Status, &s.ShippedAt, &s.ReceivedAt,
+			&s.Lines, &s.QtyShipped, &s.QtyReceived)
+		if err != nil {
+			return nil, err
+		}
+		if s.QtyReceived != nil {
+			discrepancy := *s.QtyReceived - s.QtyShipped
+			s.Discrepancy = &discrepancy
+		}
+		history = append(history, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
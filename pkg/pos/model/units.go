@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+var (
+	// ErrUnknownUnit is returned when a quantity is given in a unit the product does not have.
+	ErrUnknownUnit = errors.New("unit of measure does not exist for product")
+	// ErrBaseUnitInUse is returned when changing the base unit of a product that has stock
+	// movements, whose quantities, costs and prices are all in the old base unit.
+	ErrBaseUnitInUse = errors.New("base unit cannot change once the product has stock movements")
+)
+
+// ProductUnit is a unit a product is bought, sold or counted in other than its base unit,
+// such as a case of 24 cans or a kilogram of 1000 grams. Factor is how many base units
+// one of it holds.
+type ProductUnit struct {
+	Name   string `json:"name"`
+	Factor int    `json:"factor"`
+}
+
+// ProductUnits scans the JSON array productColumns builds from product_units.
+type ProductUnits []ProductUnit
+
+func (u *ProductUnits) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into ProductUnits", src)
+	}
+	return json.Unmarshal(data, u)
+}
+
+// ValidateUnits checks a product's base unit and its other units.
+func ValidateUnits(v *validator.Validator, baseUnit string, units []ProductUnit) {
+	v.Check(len(baseUnit) <= 16, "baseUnit", "must not be more than 16 bytes long")
+
+	names := make([]string, len(units))
+	for i, unit := range units {
+		key := fmt.Sprintf("units[%d]", i)
+		names[i] = unit.Name
+		v.Check(unit.Name != "", key+".name", "must be provided")
+		v.Check(len(unit.Name) <= 16, key+".name", "must not be more than 16 bytes long")
+		v.Check(unit.Name != baseUnit, key+".name", "must not be the base unit")
+		v.Check(unit.Factor > 0, key+".factor", "must be greater than zero")
+	}
+	v.Check(validator.Unique(names), "units", "must not contain the same unit twice")
+}
+
+// setProductUnits replaces the units of a product.
+func setProductUnits(ctx context.Context, tx *sql.Tx, productId int, units []ProductUnit) error {
+	query := `DELETE FROM product_units WHERE product_id = $1`
+	if _, err := tx.ExecContext(ctx, query, productId); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO product_units (product_id, name, factor) VALUES ($1, $2, $3)`
+	for _, unit := range units {
+		if _, err := tx.ExecContext(ctx, query, productId, unit.Name, unit.Factor); err != nil {
+			return err
+		}
+	}
+
+	// Units are part of the catalog but not a column of the product.
+	query = `UPDATE products SET sync_version = catalog_sync_version() WHERE id = $1`
+	_, err := tx.ExecContext(ctx, query, productId)
+	return err
+}
+
+// unitFactor returns how many base units of the product one unit holds. No unit and the
+// base unit hold one.
+func unitFactor(ctx context.Context, tx *sql.Tx, productId int, unit string) (int, error) {
+	var factor sql.NullInt64
+	query := `
+			SELECT CASE WHEN $2 IN ('', base_unit) THEN 1
+				ELSE (SELECT factor FROM product_units WHERE product_id = products.id AND name = $2) END
+			FROM products
+			WHERE id = $1
+			`
+	if err := tx.QueryRowContext(ctx, query, productId, unit).Scan(&factor); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %d", ErrUnknownProduct, productId)
+		}
+		return 0, err
+	}
+	if !factor.Valid {
+		return 0, fmt.Errorf("%w: product %d unit %q", ErrUnknownUnit, productId, unit)
+	}
+	return int(factor.Int64), nil
+}
+
+// applyUnit converts a movement given in another unit than the product's base unit:
+// QtyDelta and the lots become base units, and a given UnitCost a cost per base unit.
+// UnitQty keeps the quantity as given. Movements in the base unit lose their Unit.
+func applyUnit(ctx context.Context, tx *sql.Tx, m *StockMovement) error {
+	if m.Unit == "" || m.UnitQty != nil {
+		return nil
+	}
+
+	factor, err := unitFactor(ctx, tx, m.ProductId, m.Unit)
+	if err != nil {
+		return err
+	}
+	convertUnit(m, factor)
+	return nil
+}
+
+// convertUnit converts a movement in a unit holding factor base units to base units.
+func convertUnit(m *StockMovement, factor int) {
+	if factor == 1 {
+		m.Unit = ""
+		return
+	}
+
+	unitQty := m.QtyDelta
+	m.UnitQty = &unitQty
+	m.QtyDelta *= factor
+	for i := range m.Lots {
+		m.Lots[i].Qty *= factor
+	}
+	if m.UnitCost != nil {
+		unitCost := roundDiv(int64(*m.UnitCost), int64(factor))
+		m.UnitCost = &unitCost
+	}
+}
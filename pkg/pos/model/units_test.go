@@ -0,0 +1,60 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertUnit(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		name   string
+		m      StockMovement
+		factor int
+		want   StockMovement
+	}{
+		{
+			name:   "base unit loses its unit",
+			m:      StockMovement{QtyDelta: 3, Unit: "can", UnitCost: intPtr(120)},
+			factor: 1,
+			want:   StockMovement{QtyDelta: 3, UnitCost: intPtr(120)},
+		},
+		{
+			name:   "cases sold become cans",
+			m:      StockMovement{QtyDelta: -2, Unit: "case"},
+			factor: 24,
+			want:   StockMovement{QtyDelta: -48, Unit: "case", UnitQty: intPtr(-2)},
+		},
+		{
+			name:   "cost per case becomes cost per can",
+			m:      StockMovement{QtyDelta: 1, Unit: "case", UnitCost: intPtr(2400)},
+			factor: 24,
+			want:   StockMovement{QtyDelta: 24, Unit: "case", UnitQty: intPtr(1), UnitCost: intPtr(100)},
+		},
+		{
+			name:   "cost per base unit is rounded",
+			m:      StockMovement{QtyDelta: 1, Unit: "pack", UnitCost: intPtr(1000)},
+			factor: 6,
+			want:   StockMovement{QtyDelta: 6, Unit: "pack", UnitQty: intPtr(1), UnitCost: intPtr(167)},
+		},
+		{
+			name: "lots are converted",
+			m: StockMovement{QtyDelta: 3, Unit: "kg",
+				Lots: []LotAllocation{{LotNumber: "A", Qty: 1}, {LotNumber: "B", Qty: 2}}},
+			factor: 1000,
+			want: StockMovement{QtyDelta: 3000, Unit: "kg", UnitQty: intPtr(3),
+				Lots: []LotAllocation{{LotNumber: "A", Qty: 1000}, {LotNumber: "B", Qty: 2000}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.m
+			convertUnit(&m, tt.factor)
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("got %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+}
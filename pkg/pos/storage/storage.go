@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned for keys that are empty, absolute or leave the storage root.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage keeps uploaded files, such as product images, under slash-separated keys like
+// "products/12/ab34/original.jpg".
+type Storage interface {
+	// Put stores the contents of r under key, replacing whatever was there.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Delete removes the file under key. Deleting a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients fetch the file under key from.
+	URL(key string) string
+}
+
+// Local is a Storage on the local filesystem. Files live under Dir and are served from
+// BaseURL, for instance by Handler.
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocal returns a Local storage rooted at dir, creating dir if need be.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(clean)), nil
+}
+
+// Put writes the file next to its destination first and renames it into place, so that
+// readers never see half a file.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.BaseURL + "/" + key
+}
+
+// Handler serves the stored files by key. Directories are not listed.
+func (l *Local) Handler() http.Handler {
+	files := http.FileServer(http.Dir(l.Dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") || strings.Contains(r.URL.Path, "/.") {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}